@@ -0,0 +1,712 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"vpod/internal/accounts"
+	"vpod/internal/data"
+	"vpod/internal/health"
+	"vpod/internal/podcast"
+	"vpod/internal/scheduledjobs"
+	"vpod/internal/sessions"
+	"vpod/internal/tokens"
+
+	"github.com/urfave/cli/v2"
+)
+
+// cliEnv is what the admin subcommands work with. Unlike Env it starts
+// nothing in the background, so they can run next to a server using the
+// same database and audio directory.
+type cliEnv struct {
+	baseURL  *url.URL
+	database *sql.DB
+	logger   *slog.Logger
+	queries  *data.Queries
+}
+
+func newCLIEnv(cCtx *cli.Context) (*cliEnv, error) {
+	// Only what goes wrong is logged, unless asked for more
+	level := "WARN"
+	if cCtx.IsSet("log-level") {
+		level = cCtx.String("log-level")
+	}
+	l := newLogger(cCtx.App.ErrWriter, level)
+
+	u, err := url.Parse(cCtx.String("base-url"))
+	if err != nil {
+		return nil, err
+	}
+	db, q, err := data.Initialize(cCtx.Context)
+	if err != nil {
+		return nil, err
+	}
+	return &cliEnv{baseURL: u, database: db, logger: l, queries: q}, nil
+}
+
+// withCLIEnv runs action with a cliEnv, closing it afterwards.
+func withCLIEnv(action func(cCtx *cli.Context, env *cliEnv) error) cli.ActionFunc {
+	return func(cCtx *cli.Context) error {
+		env, err := newCLIEnv(cCtx)
+		if err != nil {
+			return err
+		}
+		defer env.database.Close()
+		return action(cCtx, env)
+	}
+}
+
+func (e *cliEnv) feedURL(feedID string) string {
+	return e.baseURL.JoinPath("feed", feedID).String()
+}
+
+// checkFeed returns podcast.ErrFeedNotFound if there is no such feed.
+func (e *cliEnv) checkFeed(ctx context.Context, feedID string) error {
+	_, err := e.queries.GetFeedXML(ctx, []byte(feedID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%s: %w", feedID, podcast.ErrFeedNotFound)
+	}
+	return err
+}
+
+// args returns the command's arguments, or an error if there are fewer
+// than n.
+func args(cCtx *cli.Context, n int) ([]string, error) {
+	if cCtx.NArg() < n {
+		return nil, fmt.Errorf("%s needs %d arguments: %s", cCtx.Command.FullName(), n, cCtx.Command.ArgsUsage)
+	}
+	return cCtx.Args().Slice(), nil
+}
+
+func newTable(w io.Writer) *tabwriter.Writer {
+	return tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+}
+
+// formatBytes formats n bytes with a binary unit, like 1.5 GiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// readPassword reads a password from the first line of stdin.
+func readPassword(cCtx *cli.Context) (string, error) {
+	if !cCtx.Bool("password-stdin") {
+		return "", errors.New("a password is required, pass it on stdin with --password-stdin")
+	}
+	line, err := bufio.NewReader(cCtx.App.Reader).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+var passwordStdinFlag = &cli.BoolFlag{
+	Name:  "password-stdin",
+	Usage: "Read the password from the first line of stdin",
+}
+
+func commands() []*cli.Command {
+	return []*cli.Command{
+		{
+			Name:  "feeds",
+			Usage: "Manage feeds",
+			Subcommands: []*cli.Command{
+				{
+					Name:   "list",
+					Usage:  "List every feed",
+					Action: withCLIEnv(listFeeds),
+				},
+				{
+					Name:      "add",
+					Usage:     "Create the feed for a YouTube channel",
+					ArgsUsage: "<channel url>",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "to-user",
+							Usage: "Also put the feed in this user's library",
+						},
+					},
+					Action: withCLIEnv(addFeed),
+				},
+				{
+					Name:      "remove",
+					Usage:     "Delete feeds, their episodes and settings",
+					ArgsUsage: "<feed id>...",
+					Action:    withCLIEnv(removeFeeds),
+				},
+				{
+					Name:      "refresh",
+					Usage:     "Refresh feeds now",
+					ArgsUsage: "[feed id...]",
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:  "all",
+							Usage: "Refresh every feed",
+						},
+					},
+					Action: withCLIEnv(refreshFeeds),
+				},
+			},
+		},
+		{
+			Name:  "episodes",
+			Usage: "Inspect episodes",
+			Subcommands: []*cli.Command{
+				{
+					Name:      "list",
+					Usage:     "List the episodes of a feed",
+					ArgsUsage: "<feed id>",
+					Action:    withCLIEnv(listEpisodes),
+				},
+			},
+		},
+		{
+			Name:  "cache",
+			Usage: "Manage downloaded audio",
+			Subcommands: []*cli.Command{
+				{
+					Name:   "stats",
+					Usage:  "Show how much audio is cached and what a cull would remove",
+					Action: withCLIEnv(cacheStats),
+				},
+				{
+					Name:  "purge",
+					Usage: "Remove all downloaded audio, it's downloaded again when played",
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:  "include-pinned",
+							Usage: "Also remove pinned episodes",
+						},
+						&cli.BoolFlag{
+							Name:  "dry-run",
+							Usage: "Only list what would be removed",
+						},
+					},
+					Action: withCLIEnv(purgeCache),
+				},
+			},
+		},
+		{
+			Name:  "users",
+			Usage: "Manage users",
+			Subcommands: []*cli.Command{
+				{
+					Name:   "list",
+					Usage:  "List users",
+					Action: withCLIEnv(listUsers),
+				},
+				{
+					Name:      "add",
+					Usage:     "Create a user",
+					ArgsUsage: "<username>",
+					Flags: []cli.Flag{
+						passwordStdinFlag,
+						&cli.BoolFlag{
+							Name:  "admin",
+							Usage: "Let the user manage server wide settings and other users",
+						},
+					},
+					Action: withCLIEnv(addUser),
+				},
+				{
+					Name:      "remove",
+					Usage:     "Delete a user and sign them out",
+					ArgsUsage: "<username>",
+					Action:    withCLIEnv(removeUser),
+				},
+				{
+					Name:      "set-password",
+					Usage:     "Change a user's password and sign them out",
+					ArgsUsage: "<username>",
+					Flags:     []cli.Flag{passwordStdinFlag},
+					Action:    withCLIEnv(setUserPassword),
+				},
+				{
+					Name:      "set-admin",
+					Usage:     "Make a user an admin, or not",
+					ArgsUsage: "<username> <true|false>",
+					Action:    withCLIEnv(setUserAdmin),
+				},
+			},
+		},
+		{
+			Name:  "tokens",
+			Usage: "Manage the subscriber tokens of private feeds",
+			Subcommands: []*cli.Command{
+				{
+					Name:      "list",
+					Usage:     "List a feed's tokens",
+					ArgsUsage: "<feed id>",
+					Action:    withCLIEnv(listTokens),
+				},
+				{
+					Name:      "create",
+					Usage:     "Create a token and print its feed url",
+					ArgsUsage: "<feed id> <name>",
+					Action:    withCLIEnv(createToken),
+				},
+				{
+					Name:      "rotate",
+					Usage:     "Replace a token, and print the new feed url",
+					ArgsUsage: "<feed id> <token id>",
+					Action:    withCLIEnv(rotateToken),
+				},
+				{
+					Name:      "revoke",
+					Usage:     "Revoke a token",
+					ArgsUsage: "<feed id> <token id>",
+					Action:    withCLIEnv(revokeToken),
+				},
+			},
+		},
+		{
+			Name:   "doctor",
+			Usage:  "Check the configuration and that everything vpod needs is there",
+			Action: doctor,
+		},
+	}
+}
+
+func listFeeds(cCtx *cli.Context, env *cliEnv) error {
+	const pageSize = 100
+	w := newTable(cCtx.App.Writer)
+	fmt.Fprintln(w, "ID\tTITLE\tUPDATED\tURL")
+	for page := 1; ; page++ {
+		feeds, err := env.queries.GetAllFeeds(cCtx.Context, data.GetAllFeedsParams{
+			Column1: pageSize,
+			Column2: page,
+		})
+		if err != nil {
+			return err
+		}
+		for _, f := range feeds {
+			id := string(f.ID)
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", id, f.Title, formatTime(f.UpdatedAt), env.feedURL(id))
+		}
+		if len(feeds) == 0 || !feeds[len(feeds)-1].HasMore {
+			break
+		}
+	}
+	return w.Flush()
+}
+
+func addFeed(cCtx *cli.Context, env *cliEnv) error {
+	a, err := args(cCtx, 1)
+	if err != nil {
+		return err
+	}
+	users := accounts.New(env.queries)
+	var user data.User
+	if name := cCtx.String("to-user"); name != "" {
+		// Look the user up first, so a typo doesn't leave a feed behind
+		user, err = users.GetByUsername(cCtx.Context, name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	jars, err := newCookieStore(cCtx, env.queries)
+	if err != nil {
+		return err
+	}
+
+	p, err := podcast.Generate(cCtx.Context, a[0], env.baseURL, env.logger, env.queries, jars)
+	if err != nil {
+		return err
+	}
+	if user.ID != 0 {
+		if err := users.AddFeed(cCtx.Context, user.ID, p.Id); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(cCtx.App.Writer, "%s\t%s\t%s\n", p.Id, p.Title, env.feedURL(p.Id))
+	return nil
+}
+
+func removeFeeds(cCtx *cli.Context, env *cliEnv) error {
+	ids, err := args(cCtx, 1)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := podcast.Delete(cCtx.Context, env.database, env.queries, id); err != nil {
+			return fmt.Errorf("%s: %w", id, err)
+		}
+		env.logger.Info("removed feed", slog.String("feed_id", id))
+	}
+	return nil
+}
+
+func refreshFeeds(cCtx *cli.Context, env *cliEnv) error {
+	ids := cCtx.Args().Slice()
+	if cCtx.Bool("all") {
+		all, err := env.queries.GetAllFeedIds(cCtx.Context)
+		if err != nil {
+			return err
+		}
+		ids = ids[:0]
+		for _, id := range all {
+			ids = append(ids, string(id))
+		}
+	} else if len(ids) == 0 {
+		return errors.New("feed ids or --all are required")
+	}
+
+	jars, err := newCookieStore(cCtx, env.queries)
+	if err != nil {
+		return err
+	}
+	// Without a downloader nothing is prefetched, new episodes are
+	// downloaded when they're first played instead.
+	updater, err := newUpdater(cCtx, env.logger, env.baseURL, env.queries, nil, jars)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, id := range ids {
+		if err := updater.Refresh(cCtx.Context, id); err != nil {
+			fmt.Fprintf(cCtx.App.ErrWriter, "%s: %v\n", id, err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(cCtx.App.Writer, "%s: refreshed\n", id)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d feeds failed to refresh", failed, len(ids))
+	}
+	return nil
+}
+
+func listEpisodes(cCtx *cli.Context, env *cliEnv) error {
+	a, err := args(cCtx, 1)
+	if err != nil {
+		return err
+	}
+	if err := env.checkFeed(cCtx.Context, a[0]); err != nil {
+		return err
+	}
+	episodes, err := env.queries.GetEpisodesForFeed(cCtx.Context, a[0])
+	if err != nil {
+		return err
+	}
+
+	w := newTable(cCtx.App.Writer)
+	fmt.Fprintln(w, "VIDEO ID\tRELEASED\tDURATION\tTITLE")
+	for _, e := range episodes {
+		duration := "-"
+		if e.Duration.Valid {
+			duration = (time.Duration(e.Duration.Int64) * time.Second).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", e.VideoID, formatTime(e.ReleasedAt), duration, e.Title)
+	}
+	return w.Flush()
+}
+
+func formatTime(t sql.NullTime) string {
+	if !t.Valid {
+		return "-"
+	}
+	return t.Time.UTC().Format(time.DateTime)
+}
+
+func newCLICuller(cCtx *cli.Context, env *cliEnv, dryRun bool) (*scheduledjobs.Culler, error) {
+	maxBytes, err := scheduledjobs.ParseSize(cCtx.String("cache-max-size"))
+	if err != nil {
+		return nil, err
+	}
+	return newCuller(cCtx, env.logger, env.queries, maxBytes, dryRun)
+}
+
+func cacheStats(cCtx *cli.Context, env *cliEnv) error {
+	culler, err := newCLICuller(cCtx, env, true)
+	if err != nil {
+		return err
+	}
+	report, err := culler.Plan(cCtx.Context)
+	if err != nil {
+		return err
+	}
+
+	var evict int64
+	for _, f := range report.Evict {
+		evict += f.SizeBytes
+	}
+	w := newTable(cCtx.App.Writer)
+	fmt.Fprintf(w, "Files\t%d\n", report.NumFiles)
+	fmt.Fprintf(w, "Protected\t%d\n", report.NumProtected)
+	fmt.Fprintf(w, "Size\t%s\n", formatBytes(report.TotalBytes))
+	fmt.Fprintf(w, "Budget\t%s\n", formatBytes(report.MaxBytes))
+	fmt.Fprintf(w, "Next cull\t%d files, %s\n", len(report.Evict), formatBytes(evict))
+	return w.Flush()
+}
+
+func purgeCache(cCtx *cli.Context, env *cliEnv) error {
+	dryRun := cCtx.Bool("dry-run") || cCtx.Bool("cache-dry-run")
+	culler, err := newCLICuller(cCtx, env, dryRun)
+	if err != nil {
+		return err
+	}
+	report, err := culler.Purge(cCtx.Context, cCtx.Bool("include-pinned"))
+	if err != nil {
+		return err
+	}
+
+	var removed int64
+	for _, f := range report.Evict {
+		removed += f.SizeBytes
+		if dryRun {
+			fmt.Fprintln(cCtx.App.Writer, f.Path)
+		}
+	}
+	verb := "Removed"
+	if dryRun {
+		verb = "Would remove"
+	}
+	fmt.Fprintf(cCtx.App.Writer, "%s %d files, %s\n", verb, len(report.Evict), formatBytes(removed))
+	return nil
+}
+
+func listUsers(cCtx *cli.Context, env *cliEnv) error {
+	users, err := accounts.New(env.queries).List(cCtx.Context)
+	if err != nil {
+		return err
+	}
+	w := newTable(cCtx.App.Writer)
+	fmt.Fprintln(w, "ID\tUSERNAME\tADMIN\tCREATED")
+	for _, u := range users {
+		fmt.Fprintf(w, "%d\t%s\t%t\t%s\n", u.ID, u.Username, u.IsAdmin, formatTime(u.CreatedAt))
+	}
+	return w.Flush()
+}
+
+func addUser(cCtx *cli.Context, env *cliEnv) error {
+	a, err := args(cCtx, 1)
+	if err != nil {
+		return err
+	}
+	password, err := readPassword(cCtx)
+	if err != nil {
+		return err
+	}
+	u, err := accounts.New(env.queries).Create(cCtx.Context, a[0], password, cCtx.Bool("admin"))
+	if err != nil {
+		return err
+	}
+	env.logger.Info("created user", slog.Int64("user_id", u.ID))
+	return nil
+}
+
+// lookupUser finds a user by name for the user commands.
+func lookupUser(cCtx *cli.Context, users *accounts.Store, name string) (data.User, error) {
+	u, err := users.GetByUsername(cCtx.Context, name)
+	if err != nil {
+		return data.User{}, fmt.Errorf("%s: %w", name, err)
+	}
+	return u, nil
+}
+
+func removeUser(cCtx *cli.Context, env *cliEnv) error {
+	a, err := args(cCtx, 1)
+	if err != nil {
+		return err
+	}
+	users := accounts.New(env.queries)
+	u, err := lookupUser(cCtx, users, a[0])
+	if err != nil {
+		return err
+	}
+	if err := users.Delete(cCtx.Context, u.ID); err != nil {
+		return err
+	}
+	if err := sessions.New(env.queries, 0).DeleteForUser(cCtx.Context, u.ID, ""); err != nil {
+		return err
+	}
+	env.logger.Info("deleted user", slog.Int64("user_id", u.ID))
+	return nil
+}
+
+func setUserPassword(cCtx *cli.Context, env *cliEnv) error {
+	a, err := args(cCtx, 1)
+	if err != nil {
+		return err
+	}
+	users := accounts.New(env.queries)
+	u, err := lookupUser(cCtx, users, a[0])
+	if err != nil {
+		return err
+	}
+	password, err := readPassword(cCtx)
+	if err != nil {
+		return err
+	}
+	if err := users.SetPassword(cCtx.Context, u.ID, password); err != nil {
+		return err
+	}
+	if err := sessions.New(env.queries, 0).DeleteForUser(cCtx.Context, u.ID, ""); err != nil {
+		return err
+	}
+	env.logger.Info("changed user password", slog.Int64("user_id", u.ID))
+	return nil
+}
+
+func setUserAdmin(cCtx *cli.Context, env *cliEnv) error {
+	a, err := args(cCtx, 2)
+	if err != nil {
+		return err
+	}
+	admin, err := strconv.ParseBool(a[1])
+	if err != nil {
+		return fmt.Errorf("invalid admin value %q: must be true or false", a[1])
+	}
+	users := accounts.New(env.queries)
+	u, err := lookupUser(cCtx, users, a[0])
+	if err != nil {
+		return err
+	}
+	return users.SetAdmin(cCtx.Context, u.ID, admin)
+}
+
+func newTokenStore(env *cliEnv) *tokens.Store {
+	return tokens.New(env.database, env.queries, *env.baseURL)
+}
+
+// tokenArgs parses the feed id and token id the token commands take.
+func tokenArgs(cCtx *cli.Context) (string, int64, error) {
+	a, err := args(cCtx, 2)
+	if err != nil {
+		return "", 0, err
+	}
+	id, err := strconv.ParseInt(a[1], 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid token id %q", a[1])
+	}
+	return a[0], id, nil
+}
+
+func listTokens(cCtx *cli.Context, env *cliEnv) error {
+	a, err := args(cCtx, 1)
+	if err != nil {
+		return err
+	}
+	list, err := newTokenStore(env).List(cCtx.Context, a[0], 0)
+	if err != nil {
+		return err
+	}
+	w := newTable(cCtx.App.Writer)
+	fmt.Fprintln(w, "ID\tNAME\tCREATED")
+	for _, t := range list {
+		fmt.Fprintf(w, "%d\t%s\t%s\n", t.ID, t.Name, formatTime(t.CreatedAt))
+	}
+	return w.Flush()
+}
+
+func createToken(cCtx *cli.Context, env *cliEnv) error {
+	a, err := args(cCtx, 2)
+	if err != nil {
+		return err
+	}
+	// Tokens for a typo would never work
+	if err := env.checkFeed(cCtx.Context, a[0]); err != nil {
+		return err
+	}
+	store := newTokenStore(env)
+	g, err := store.Create(cCtx.Context, a[0], a[1], 0)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(cCtx.App.Writer, store.URL(g))
+	return nil
+}
+
+func rotateToken(cCtx *cli.Context, env *cliEnv) error {
+	feedID, id, err := tokenArgs(cCtx)
+	if err != nil {
+		return err
+	}
+	store := newTokenStore(env)
+	g, err := store.Rotate(cCtx.Context, feedID, id, 0)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(cCtx.App.Writer, store.URL(g))
+	return nil
+}
+
+func revokeToken(cCtx *cli.Context, env *cliEnv) error {
+	feedID, id, err := tokenArgs(cCtx)
+	if err != nil {
+		return err
+	}
+	return newTokenStore(env).Revoke(cCtx.Context, feedID, id, 0)
+}
+
+// doctor checks the configuration the way the server would load it, then
+// runs the readiness checks. It fails when anything is wrong.
+func doctor(cCtx *cli.Context) error {
+	env, err := newCLIEnv(cCtx)
+	if err != nil {
+		return err
+	}
+	defer env.database.Close()
+
+	config := []health.Check{
+		{Name: "base url", Run: func(context.Context) (string, error) {
+			if env.baseURL.Scheme != "http" && env.baseURL.Scheme != "https" || env.baseURL.Host == "" {
+				return "", errors.New("must be an absolute http(s) url")
+			}
+			return env.baseURL.String(), nil
+		}},
+		{Name: "cache size", Run: func(context.Context) (string, error) {
+			n, err := scheduledjobs.ParseSize(cCtx.String("cache-max-size"))
+			return formatBytes(n), err
+		}},
+		{Name: "cookie key", Run: func(context.Context) (string, error) {
+			jars, err := newCookieStore(cCtx, env.queries)
+			if jars == nil && err == nil {
+				return "not set, cookie jars are disabled", nil
+			}
+			return "set", err
+		}},
+		{Name: "url signing key", Run: func(context.Context) (string, error) {
+			signer, err := newSigner(cCtx, env.baseURL)
+			if signer == nil && err == nil {
+				return "not set, audio urls are unsigned", nil
+			}
+			return "set", err
+		}},
+	}
+
+	results, ok := health.New(append(config, checks(cCtx, env.database)...)...).Run(cCtx.Context)
+	w := newTable(cCtx.App.Writer)
+	for _, r := range results {
+		status, detail := "ok", r.Detail
+		if !r.OK {
+			status, detail = "FAIL", r.Error
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.Name, status, detail)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if !ok {
+		return cli.Exit("", 1)
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"vpod/internal/accounts"
+	"vpod/internal/data"
+	"vpod/internal/podcast"
+
+	"github.com/urfave/cli/v2"
+)
+
+// run runs vpod with args, against the database initDb made.
+func run(t *testing.T, stdin string, args ...string) (stdout, stderr string, err error) {
+	t.Helper()
+	app := newApp()
+	var out, errOut bytes.Buffer
+	app.Reader = strings.NewReader(stdin)
+	app.Writer = &out
+	app.ErrWriter = &errOut
+	// cli.Exit would otherwise exit the test binary
+	app.ExitErrHandler = func(*cli.Context, error) {}
+
+	err = app.Run(append([]string{"vpod", "--base-url", "http://localhost:8080"}, args...))
+	return out.String(), errOut.String(), err
+}
+
+// initDb creates the database the commands use, with two feeds, in a
+// working directory of the test's own.
+func initDb(t *testing.T) *data.Queries {
+	t.Chdir(t.TempDir())
+	db, queries, err := data.Initialize(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	for _, id := range []string{"UCone", "UCtwo"} {
+		_, err := db.Exec(`INSERT INTO Feeds (id, title, link, xml) VALUES (CAST(? AS BLOB), 't', 'l', '<rss/>')`, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return queries
+}
+
+// channelFeeds serves empty channel feeds, so refreshes finish without
+// running yt-dlp. UCbroken's is missing, and without yt-dlp on the PATH it
+// fails to refresh.
+func channelFeeds(t *testing.T) string {
+	t.Setenv("PATH", t.TempDir())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("channel_id") == "UCbroken" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`<feed xmlns="http://www.w3.org/2005/Atom"></feed>`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestRefreshFeeds(t *testing.T) {
+	initDb(t)
+	feedURL := channelFeeds(t)
+
+	if _, _, err := run(t, "", "--youtube-feed-url", feedURL, "feeds", "refresh"); err == nil {
+		t.Error("refresh without feeds or --all succeeded")
+	}
+
+	out, stderr, err := run(t, "", "--youtube-feed-url", feedURL, "feeds", "refresh", "UCone", "UCbroken")
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 feeds failed") {
+		t.Errorf("refresh with a broken feed err = %v, want 1 of 2 failed", err)
+	}
+	if !strings.Contains(out, "UCone: refreshed") || strings.Contains(out, "UCtwo") {
+		t.Errorf("refresh of UCone printed %q", out)
+	}
+	if !strings.Contains(stderr, "UCbroken: ") {
+		t.Errorf("refresh didn't report UCbroken failing, stderr %q", stderr)
+	}
+
+	// --all refreshes what's in the database, not what's on the command line
+	out, _, err = run(t, "", "--youtube-feed-url", feedURL, "feeds", "refresh", "--all", "UCbroken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "UCone: refreshed\nUCtwo: refreshed\n" {
+		t.Errorf("refresh --all printed %q", out)
+	}
+}
+
+func TestListEpisodes(t *testing.T) {
+	queries := initDb(t)
+
+	err := queries.UpsertEpisode(context.Background(), data.UpsertEpisodeParams{
+		ID:       []byte("https://vpod.local/audio/dQw4w9WgXcQ/140"),
+		AudioUrl: "https://vpod.local/audio/dQw4w9WgXcQ/140",
+		Duration: sql.NullInt64{Int64: 212, Valid: true},
+		FeedID:   "UCone",
+		Title:    "Never Gonna Give You Up",
+		VideoID:  "dQw4w9WgXcQ",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := run(t, "", "episodes", "list", "UCmissing"); !errors.Is(err, podcast.ErrFeedNotFound) {
+		t.Errorf("episodes list of a missing feed err = %v, want ErrFeedNotFound", err)
+	}
+	out, _, err := run(t, "", "episodes", "list", "UCone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("episodes list printed %q, want a header and one episode", out)
+	}
+	if fields := strings.Fields(lines[1]); fields[0] != "dQw4w9WgXcQ" || fields[2] != "3m32s" {
+		t.Errorf("episodes list printed %q, want the video id and duration", lines[1])
+	}
+	if strings.Contains(out, "https://") {
+		t.Errorf("episodes list printed the enclosure url: %q", out)
+	}
+
+	out, _, err = run(t, "", "episodes", "list", "UCtwo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(out, "\n") != 1 {
+		t.Errorf("episodes list of an empty feed printed %q, want only the header", out)
+	}
+}
+
+func TestPurgeCacheDryRun(t *testing.T) {
+	queries := initDb(t)
+	ctx := context.Background()
+
+	path, err := filepath.Abs("UCoAAAAAAAA.m4a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, make([]byte, 2048), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	err = queries.UpsertAudioFile(ctx, data.UpsertAudioFileParams{
+		Path:      path,
+		VideoID:   "UCoAAAAAAAA",
+		FormatID:  "m4a",
+		SizeBytes: 2048,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, _, err := run(t, "", "cache", "purge", "--dry-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := path + "\nWould remove 1 files, 2.0 KiB\n"; out != want {
+		t.Errorf("purge --dry-run printed %q, want %q", out, want)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("purge --dry-run removed the file: %v", err)
+	}
+
+	out, _, err = run(t, "", "cache", "purge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "Removed 1 files, 2.0 KiB\n" {
+		t.Errorf("purge printed %q", out)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("purge left the file behind: %v", err)
+	}
+}
+
+func TestReadPassword(t *testing.T) {
+	users := accounts.New(initDb(t))
+	ctx := context.Background()
+
+	if _, _, err := run(t, "correct horse\n", "users", "add", "alice"); err == nil {
+		t.Error("users add without --password-stdin succeeded")
+	}
+
+	// Only the first line is the password, without its line ending
+	if _, _, err := run(t, "correct horse\r\nmore\n", "users", "add", "--password-stdin", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.Authenticate(ctx, "alice", "correct horse"); err != nil {
+		t.Errorf("Authenticate() with the password from stdin err = %v", err)
+	}
+
+	// A last line without a newline counts too
+	if _, _, err := run(t, "battery staple", "users", "set-password", "--password-stdin", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.Authenticate(ctx, "alice", "battery staple"); err != nil {
+		t.Errorf("Authenticate() with the changed password err = %v", err)
+	}
+}
+
+func TestCreateTokenNeedsFeed(t *testing.T) {
+	initDb(t)
+
+	if _, _, err := run(t, "", "tokens", "create", "UCmissing", "phone"); !errors.Is(err, podcast.ErrFeedNotFound) {
+		t.Errorf("tokens create for a missing feed err = %v, want ErrFeedNotFound", err)
+	}
+	out, _, err := run(t, "", "tokens", "create", "UCone", "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out, "http://localhost:8080/f/") || !strings.HasSuffix(out, "/feed/UCone\n") {
+		t.Errorf("tokens create printed %q, want the feed url", out)
+	}
+}
+
+func TestDoctorExitCode(t *testing.T) {
+	initDb(t)
+	bin := t.TempDir()
+	t.Setenv("PATH", bin)
+	for _, name := range []string{"yt-dlp", "ffmpeg", "ffprobe"} {
+		if err := os.WriteFile(filepath.Join(bin, name), []byte("#!/bin/sh\necho 1.0\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out, _, err := run(t, "", "doctor")
+	if err != nil {
+		t.Fatalf("doctor err = %v, output:\n%s", err, out)
+	}
+
+	if err := os.Remove(filepath.Join(bin, "ffprobe")); err != nil {
+		t.Fatal(err)
+	}
+	out, _, err = run(t, "", "doctor")
+	var exit cli.ExitCoder
+	if !errors.As(err, &exit) || exit.ExitCode() != 1 {
+		t.Fatalf("doctor without ffprobe err = %v, want exit code 1", err)
+	}
+	if !strings.Contains(out, "FAIL") {
+		t.Errorf("doctor without ffprobe printed no failure:\n%s", out)
+	}
+}
@@ -4,68 +4,256 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"io"
 	"log/slog"
 	"net/url"
 	"os"
+	"strings"
+	"sync"
 	"time"
+	"vpod/internal/accounts"
+	"vpod/internal/audio"
+	"vpod/internal/clientip"
+	"vpod/internal/cookies"
 	"vpod/internal/data"
+	"vpod/internal/health"
+	"vpod/internal/loginguard"
+	"vpod/internal/metrics"
+	"vpod/internal/ratelimit"
 	"vpod/internal/scheduledjobs"
+	"vpod/internal/sessions"
+	"vpod/internal/signing"
+	"vpod/internal/sso"
+	"vpod/internal/tokens"
+	"vpod/internal/tracing"
+	"vpod/internal/websub"
 
 	"github.com/go-co-op/gocron/v2"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/time/rate"
 )
 
 type Env struct {
-	baseURL   *url.URL
-	database  *sql.DB
-	logger    *slog.Logger
-	queries   *data.Queries
-	scheduler *gocron.Scheduler
+	background *sync.WaitGroup
+	baseURL    *url.URL
+	cancel     context.CancelFunc
+	checker    *health.Checker
+	clientIP   *clientip.Resolver
+	cookies    *cookies.Store
+	culler     *scheduledjobs.Culler
+	database   *sql.DB
+	downloader *audio.Downloader
+	guard      *loginguard.Guard
+	limits     rateLimits
+	logger     *slog.Logger
+	queries    *data.Queries
+	scheduler  *gocron.Scheduler
+	sessions   *sessions.Store
+	signer     *signing.Signer
+	sso        *sso.Provider
+	started    time.Time
+	tokens     *tokens.Store
+	tracing    func(context.Context) error
+	updater    *scheduledjobs.Updater
+	users      *accounts.Store
+	websub     *websub.Subscriber
 }
 
-func NewEnv(
-	logLevel string,
-	baseURL string,
-) (*Env, error) {
-	l := newLogger(logLevel)
+func NewEnv(cCtx *cli.Context) (*Env, error) {
+	l := newLogger(os.Stdout, cCtx.String("log-level"))
 	if l == nil {
 		return nil, errors.New("could not initalize logger")
 	}
 
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Endpoint:    cCtx.String("otlp-endpoint"),
+		SampleRatio: cCtx.Float64("trace-sample-ratio"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	db, q, err := data.Initialize(context.Background())
 	if err != nil {
 		return nil, err
 	}
 
-	u, err := url.Parse(baseURL)
+	if cCtx.Bool("metrics") {
+		if err := metrics.RegisterDB(q); err != nil {
+			return nil, err
+		}
+	}
+
+	u, err := url.Parse(cCtx.String("base-url"))
+	if err != nil {
+		return nil, err
+	}
+
+	trusted, err := clientip.ParseTrusted(cCtx.StringSlice("trusted-proxies"))
+	if err != nil {
+		return nil, err
+	}
+	clientIPHeader, err := clientip.ParseHeader(cCtx.String("client-ip-header"))
+	if err != nil {
+		return nil, err
+	}
+
+	maxCacheBytes, err := scheduledjobs.ParseSize(cCtx.String("cache-max-size"))
+	if err != nil {
+		return nil, err
+	}
+
+	jars, err := newCookieStore(cCtx, q)
 	if err != nil {
 		return nil, err
 	}
 
-	s, err := newScheduler(l, u, q)
+	signer, err := newSigner(cCtx, u)
 	if err != nil {
 		return nil, err
 	}
 
+	users := accounts.New(q)
+	provider, err := newSSOProvider(cCtx, u, q, users)
+	if err != nil {
+		return nil, err
+	}
+
+	downloader, err := newDownloader(cCtx, l, q, jars, maxCacheBytes)
+	if err != nil {
+		return nil, err
+	}
+	// Nothing else downloads here while the server runs
+	if err := downloader.RemovePartial(); err != nil {
+		return nil, err
+	}
+
+	updater, err := newUpdater(cCtx, l, u, q, downloader, jars)
+	if err != nil {
+		return nil, err
+	}
+
+	var sub *websub.Subscriber
+	if cCtx.Bool("websub") {
+		sub, err = newWebSubSubscriber(cCtx, l, u, q, updater)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	culler, err := newCuller(cCtx, l, q, maxCacheBytes, cCtx.Bool("cache-dry-run"))
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := newScheduler(l, updater, sub, culler, cCtx.Duration("shutdown-timeout"))
+	if err != nil {
+		return nil, err
+	}
+
+	checker := health.New(append(checks(cCtx, db), health.Scheduler(*s))...)
+
+	guard := loginguard.New(l, loginguard.Config{
+		MaxFailures: cCtx.Int("login-max-failures"),
+		Lockout:     cCtx.Duration("login-lockout"),
+		MaxLockout:  cCtx.Duration("login-lockout-max"),
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var background sync.WaitGroup
+	background.Add(2)
+	go func() {
+		defer background.Done()
+		updater.Run(ctx)
+	}()
+	go func() {
+		defer background.Done()
+		downloader.Run(ctx)
+	}()
+
 	return &Env{
-		baseURL:   u,
-		database:  db,
-		logger:    l,
-		queries:   q,
-		scheduler: s,
+		background: &background,
+		baseURL:    u,
+		cancel:     cancel,
+		checker:    checker,
+		clientIP:   clientip.New(trusted, clientIPHeader),
+		cookies:    jars,
+		culler:     culler,
+		database:   db,
+		downloader: downloader,
+		guard:      guard,
+		limits:     newRateLimits(cCtx),
+		logger:     l,
+		queries:    q,
+		scheduler:  s,
+		sessions:   sessions.New(q, cCtx.Duration("session-ttl")),
+		signer:     signer,
+		sso:        provider,
+		started:    time.Now(),
+		tokens:     tokens.New(db, q, *u),
+		tracing:    shutdownTracing,
+		updater:    updater,
+		users:      users,
+		websub:     sub,
 	}, nil
 }
 
+// Cleanup stops the background work, waiting for running jobs up to the
+// scheduler's stop timeout and for downloads to remove their partial files,
+// then closes the database.
 func (e *Env) Cleanup() {
+	if e.cancel != nil {
+		e.cancel()
+	}
 	if e.scheduler != nil {
 		s := *e.scheduler
-		s.Shutdown()
+		if err := s.Shutdown(); err != nil {
+			e.logger.Error("could not stop scheduled jobs", slog.String("err", err.Error()))
+		}
+	}
+	if e.downloader != nil {
+		e.downloader.Close()
+	}
+	if e.background != nil {
+		e.background.Wait()
+	}
+	if e.tracing != nil {
+		// Send the spans of whatever was still running
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := e.tracing(ctx); err != nil {
+			e.logger.Error("could not flush traces", slog.String("err", err.Error()))
+		}
 	}
 	if e.database != nil {
 		e.database.Close()
 	}
 }
 
-func newLogger(logLevel string) *slog.Logger {
+// rateLimits are the per client limits of each part of the server.
+type rateLimits struct {
+	api   *ratelimit.Limiter
+	audio *ratelimit.Limiter
+	feed  *ratelimit.Limiter
+	ui    *ratelimit.Limiter
+}
+
+func newRateLimits(cCtx *cli.Context) rateLimits {
+	limiter := func(name string) *ratelimit.Limiter {
+		return ratelimit.New(
+			rate.Limit(cCtx.Float64("rate-limit-"+name)),
+			cCtx.Int("rate-limit-"+name+"-burst"),
+		)
+	}
+	return rateLimits{
+		api:   limiter("api"),
+		audio: limiter("audio"),
+		feed:  limiter("feed"),
+		ui:    limiter("ui"),
+	}
+}
+
+func newLogger(w io.Writer, logLevel string) *slog.Logger {
 	lvl := new(slog.LevelVar)
 	switch logLevel {
 	case "DEBUG":
@@ -80,26 +268,229 @@ func newLogger(logLevel string) *slog.Logger {
 
 	return slog.New(
 		slog.NewJSONHandler(
-			os.Stdout,
+			w,
 			&slog.HandlerOptions{Level: lvl},
 		),
 	)
 }
 
-func newScheduler(logger *slog.Logger, baseURL *url.URL, queries *data.Queries) (*gocron.Scheduler, error) {
+// newCookieStore returns nil when no cookie key is set, which disables
+// cookie jars.
+func newCookieStore(cCtx *cli.Context, queries *data.Queries) (*cookies.Store, error) {
+	key := cCtx.String("cookie-key")
+	if path := cCtx.String("cookie-key-file"); path != "" {
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key = string(contents)
+	}
+	if key == "" {
+		return nil, nil
+	}
+
+	k, err := cookies.ParseKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return cookies.New(queries, k)
+}
+
+// newSigner returns nil when no url signing key is set, which leaves
+// enclosure urls unsigned.
+func newSigner(cCtx *cli.Context, baseURL *url.URL) (*signing.Signer, error) {
+	key := cCtx.String("url-signing-key")
+	if path := cCtx.String("url-signing-key-file"); path != "" {
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key = string(contents)
+	}
+	if key == "" {
+		return nil, nil
+	}
+
+	k, err := signing.ParseKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return signing.New(k, cCtx.Duration("url-signing-ttl"), *baseURL), nil
+}
+
+// newSSOProvider returns nil when no oidc issuer is set, which leaves
+// single sign-on off.
+func newSSOProvider(
+	cCtx *cli.Context,
+	baseURL *url.URL,
+	queries *data.Queries,
+	users *accounts.Store,
+) (*sso.Provider, error) {
+	issuer := cCtx.String("oidc-issuer")
+	if issuer == "" {
+		return nil, nil
+	}
+	secret := cCtx.String("oidc-client-secret")
+	if path := cCtx.String("oidc-client-secret-file"); path != "" {
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		secret = strings.TrimSpace(string(contents))
+	}
+
+	return sso.New(cCtx.Context, sso.Config{
+		IssuerURL:      issuer,
+		ClientID:       cCtx.String("oidc-client-id"),
+		ClientSecret:   secret,
+		RedirectURL:    baseURL.JoinPath("/ui/oidc/callback").String(),
+		Scopes:         cCtx.StringSlice("oidc-scopes"),
+		AllowedEmails:  cCtx.StringSlice("oidc-allowed-emails"),
+		AllowedDomains: cCtx.StringSlice("oidc-allowed-domains"),
+		AllowedGroups:  cCtx.StringSlice("oidc-allowed-groups"),
+		AdminGroups:    cCtx.StringSlice("oidc-admin-groups"),
+		GroupsClaim:    cCtx.String("oidc-groups-claim"),
+	}, queries, users)
+}
+
+func newWebSubSubscriber(
+	cCtx *cli.Context,
+	logger *slog.Logger,
+	baseURL *url.URL,
+	queries *data.Queries,
+	updater *scheduledjobs.Updater,
+) (*websub.Subscriber, error) {
+	hubURL, err := url.Parse(cCtx.String("websub-hub"))
+	if err != nil {
+		return nil, err
+	}
+	topicURL, err := url.Parse(cCtx.String("websub-topic-url"))
+	if err != nil {
+		return nil, err
+	}
+
+	return websub.New(
+		logger,
+		queries,
+		websub.Config{
+			HubURL:          hubURL,
+			TopicURL:        topicURL,
+			CallbackBaseURL: baseURL,
+			Lease:           cCtx.Duration("websub-lease"),
+			RenewBefore:     cCtx.Duration("websub-lease") / 4,
+		},
+		func(feedID string) { updater.Enqueue(feedID) },
+	)
+}
+
+// checks are what vpod needs to work, apart from the scheduler, which only
+// runs in the server.
+func checks(cCtx *cli.Context, db *sql.DB) []health.Check {
+	return []health.Check{
+		health.DB(db),
+		health.Binary("yt-dlp", "--version"),
+		health.Binary("ffmpeg", "-version"),
+		health.Binary("ffprobe", "-version"),
+		health.Writable("audio storage", cCtx.String("audio-dir")),
+	}
+}
+
+func newDownloader(
+	cCtx *cli.Context,
+	logger *slog.Logger,
+	queries *data.Queries,
+	jars *cookies.Store,
+	maxCacheBytes int64,
+) (*audio.Downloader, error) {
+	audioDir := cCtx.String("audio-dir")
+	if err := os.MkdirAll(audioDir, 0o755); err != nil {
+		return nil, err
+	}
+	return audio.New(logger, queries, audio.Config{
+		Dir:                    audioDir,
+		MaxConcurrentDownloads: cCtx.Int("max-concurrent-downloads"),
+		MaxCacheBytes:          maxCacheBytes,
+		SponsorBlockAPI:        cCtx.String("sponsorblock-api"),
+		Cookies:                jars,
+	})
+}
+
+func newUpdater(
+	cCtx *cli.Context,
+	logger *slog.Logger,
+	baseURL *url.URL,
+	queries *data.Queries,
+	downloader *audio.Downloader,
+	jars *cookies.Store,
+) (*scheduledjobs.Updater, error) {
+	feedURL, err := url.Parse(cCtx.String("youtube-feed-url"))
+	if err != nil {
+		return nil, err
+	}
+	return scheduledjobs.NewUpdater(logger, baseURL, queries, scheduledjobs.UpdaterConfig{
+		Concurrency: cCtx.Int("refresh-concurrency"),
+		FeedTimeout: cCtx.Duration("refresh-timeout"),
+		RateLimit:   rate.Limit(cCtx.Float64("youtube-rate-limit")),
+		RateBurst:   cCtx.Int("youtube-rate-burst"),
+		FeedURL:     feedURL,
+		Downloader:  downloader,
+		Cookies:     jars,
+
+		RefreshInterval:    cCtx.Duration("refresh-interval"),
+		MinRefreshInterval: cCtx.Duration("refresh-min-interval"),
+		MaxRefreshInterval: cCtx.Duration("refresh-max-interval"),
+		RefreshJitter:      cCtx.Float64("refresh-jitter"),
+	})
+}
+
+func newCuller(
+	cCtx *cli.Context,
+	logger *slog.Logger,
+	queries *data.Queries,
+	maxBytes int64,
+	dryRun bool,
+) (*scheduledjobs.Culler, error) {
+	policies := []scheduledjobs.EvictionPolicy{
+		scheduledjobs.PinnedPolicy{Queries: queries},
+	}
+	if n := cCtx.Int("cache-keep-newest"); n > 0 {
+		policies = append(policies, scheduledjobs.NewestPerFeedPolicy{N: n, Queries: queries})
+	}
+
+	return scheduledjobs.NewCuller(logger, queries, scheduledjobs.CullerConfig{
+		MaxBytes: maxBytes,
+		Policies: policies,
+		DryRun:   dryRun,
+	})
+}
+
+func newScheduler(
+	logger *slog.Logger,
+	updater *scheduledjobs.Updater,
+	sub *websub.Subscriber,
+	culler *scheduledjobs.Culler,
+	stopTimeout time.Duration,
+) (*gocron.Scheduler, error) {
 	s, err := gocron.NewScheduler(
 		gocron.WithLocation(time.UTC),
 		gocron.WithLogger(logger),
+		gocron.WithStopTimeout(stopTimeout),
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := scheduledjobs.CreateUpdateJob(s, logger, baseURL, queries); err != nil {
+	if err := scheduledjobs.CreateUpdateJob(s, updater); err != nil {
 		return nil, err
 	}
 
-	if err = scheduledjobs.CreateFileCullingJob(s, logger); err != nil {
+	if sub != nil {
+		if err := scheduledjobs.CreateWebSubRenewalJob(s, sub); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = scheduledjobs.CreateFileCullingJob(s, culler); err != nil {
 		return nil, err
 	}
 
@@ -3,22 +3,87 @@ package main
 import (
 	"fmt"
 	"log"
+	"net/url"
 	"os"
+	"time"
+	"vpod/internal/clientip"
+	"vpod/internal/cookies"
+	"vpod/internal/scheduledjobs"
+	"vpod/internal/signing"
 
 	"github.com/urfave/cli/v2"
 )
 
 func main() {
-	app := &cli.App{
+	if err := newApp().Run(os.Args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// newApp returns the server and its admin subcommands.
+func newApp() *cli.App {
+	return &cli.App{
 		Name:  "vpod",
 		Usage: "beware the pipeline",
 		Flags: []cli.Flag{
+			&cli.StringFlag{
+				EnvVars: []string{"AUDIO_DIR"},
+				Name:    "audio-dir",
+				Usage:   "Directory downloaded audio is stored in",
+				Value:   ".",
+			},
 			&cli.StringFlag{
 				EnvVars:  []string{"BASE_URL"},
 				Name:     "base-url",
 				Required: true,
 				Usage:    "The base url for the podcast",
 			},
+			&cli.StringFlag{
+				EnvVars: []string{"CACHE_MAX_SIZE"},
+				Name:    "cache-max-size",
+				Usage:   "Budget for downloaded audio, e.g. 500MB or 2GB. Least recently played files are removed first once it is exceeded",
+				Value:   "1GB",
+				Action: func(ctx *cli.Context, v string) error {
+					_, err := scheduledjobs.ParseSize(v)
+					return err
+				},
+			},
+			&cli.IntFlag{
+				EnvVars: []string{"CACHE_KEEP_NEWEST"},
+				Name:    "cache-keep-newest",
+				Usage:   "Never remove audio for this many of the newest episodes of each feed. 0 disables this",
+				Value:   0,
+			},
+			&cli.BoolFlag{
+				EnvVars: []string{"CACHE_DRY_RUN"},
+				Name:    "cache-dry-run",
+				Usage:   "Only log which audio files would be removed, without removing them",
+				Value:   false,
+			},
+			&cli.StringFlag{
+				EnvVars: []string{"CLIENT_IP_HEADER"},
+				Name:    "client-ip-header",
+				Usage:   "Header trusted proxies pass the client's ip in, one of Forwarded, X-Forwarded-For or X-Real-Ip. The others are ignored",
+				Value:   "X-Forwarded-For",
+				Action: func(ctx *cli.Context, v string) error {
+					_, err := clientip.ParseHeader(v)
+					return err
+				},
+			},
+			&cli.StringFlag{
+				EnvVars: []string{"COOKIE_KEY"},
+				Name:    "cookie-key",
+				Usage:   "Base64 encoded 32 byte key that cookie jars for yt-dlp are encrypted with. Cookie jars are disabled without one",
+				Action: func(ctx *cli.Context, v string) error {
+					_, err := cookies.ParseKey(v)
+					return err
+				},
+			},
+			&cli.StringFlag{
+				EnvVars: []string{"COOKIE_KEY_FILE"},
+				Name:    "cookie-key-file",
+				Usage:   "File containing the cookie key",
+			},
 			&cli.StringFlag{
 				EnvVars: []string{"HOST"},
 				Name:    "host",
@@ -31,16 +96,179 @@ func main() {
 				Usage:   "Log level for the program",
 				Value:   "INFO",
 			},
+			&cli.IntFlag{
+				EnvVars: []string{"LOGIN_MAX_FAILURES"},
+				Name:    "login-max-failures",
+				Usage:   "Failed logins in a row from one IP or for one user before they are locked out",
+				Value:   5,
+				Action: func(ctx *cli.Context, v int) error {
+					if v < 1 {
+						return fmt.Errorf("Invalid login max failures: %v. Must be at least 1", v)
+					}
+					return nil
+				},
+			},
+			&cli.DurationFlag{
+				EnvVars: []string{"LOGIN_LOCKOUT"},
+				Name:    "login-lockout",
+				Usage:   "How long the first lockout after too many failed logins lasts. It doubles with every further failure",
+				Value:   time.Minute,
+				Action: func(ctx *cli.Context, v time.Duration) error {
+					if v <= 0 {
+						return fmt.Errorf("Invalid login lockout: %v. Must be positive", v)
+					}
+					return nil
+				},
+			},
+			&cli.DurationFlag{
+				EnvVars: []string{"LOGIN_LOCKOUT_MAX"},
+				Name:    "login-lockout-max",
+				Usage:   "The longest a lockout after failed logins can get",
+				Value:   time.Hour,
+			},
+			&cli.BoolFlag{
+				EnvVars: []string{"METRICS"},
+				Name:    "metrics",
+				Usage:   "Serve Prometheus metrics on /metrics",
+				Value:   false,
+			},
+			&cli.StringFlag{
+				EnvVars: []string{"METRICS_TOKEN"},
+				Name:    "metrics-token",
+				Usage:   "Bearer token scrapers must send for /metrics. Empty leaves it open",
+			},
+			&cli.StringFlag{
+				EnvVars: []string{"METRICS_TOKEN_FILE"},
+				Name:    "metrics-token-file",
+				Usage:   "Path to a file containing the bearer token for /metrics",
+			},
 			&cli.BoolFlag{
 				EnvVars: []string{"NO_AUTH"},
 				Name:    "no-auth",
 				Usage:   "Deactivate authentication for the frontend",
 				Value:   false,
 			},
+			&cli.StringFlag{
+				EnvVars: []string{"OIDC_ISSUER"},
+				Name:    "oidc-issuer",
+				Usage:   "Issuer URL of an OpenID Connect provider to log in to the frontend with. Empty turns single sign-on off",
+			},
+			&cli.StringFlag{
+				EnvVars: []string{"OIDC_CLIENT_ID"},
+				Name:    "oidc-client-id",
+				Usage:   "Client ID vpod is registered with at the OIDC provider",
+			},
+			&cli.StringFlag{
+				EnvVars: []string{"OIDC_CLIENT_SECRET"},
+				Name:    "oidc-client-secret",
+				Usage:   "Client secret for the OIDC provider",
+			},
+			&cli.StringFlag{
+				EnvVars: []string{"OIDC_CLIENT_SECRET_FILE"},
+				Name:    "oidc-client-secret-file",
+				Usage:   "Path to a file containing the client secret for the OIDC provider",
+			},
+			&cli.StringSliceFlag{
+				EnvVars: []string{"OIDC_SCOPES"},
+				Name:    "oidc-scopes",
+				Usage:   "Scopes to ask the OIDC provider for",
+				Value:   cli.NewStringSlice("openid", "email", "profile"),
+			},
+			&cli.StringSliceFlag{
+				EnvVars: []string{"OIDC_ALLOWED_EMAILS"},
+				Name:    "oidc-allowed-emails",
+				Usage:   "Verified emails allowed to log in through the OIDC provider",
+			},
+			&cli.StringSliceFlag{
+				EnvVars: []string{"OIDC_ALLOWED_DOMAINS"},
+				Name:    "oidc-allowed-domains",
+				Usage:   "Domains whose verified emails are allowed to log in through the OIDC provider",
+			},
+			&cli.StringSliceFlag{
+				EnvVars: []string{"OIDC_ALLOWED_GROUPS"},
+				Name:    "oidc-allowed-groups",
+				Usage:   "Groups allowed to log in through the OIDC provider",
+			},
+			&cli.StringSliceFlag{
+				EnvVars: []string{"OIDC_ADMIN_GROUPS"},
+				Name:    "oidc-admin-groups",
+				Usage:   "Groups whose members are admins. When set, admin rights of OIDC users follow them on every login",
+			},
+			&cli.StringFlag{
+				EnvVars: []string{"OIDC_GROUPS_CLAIM"},
+				Name:    "oidc-groups-claim",
+				Usage:   "ID token claim that lists the user's groups",
+				Value:   "groups",
+			},
+			&cli.StringFlag{
+				EnvVars: []string{"OTLP_ENDPOINT", "OTEL_EXPORTER_OTLP_ENDPOINT"},
+				Name:    "otlp-endpoint",
+				Usage:   "URL of an OTLP/HTTP collector to send traces to, like http://localhost:4318. Empty turns tracing off",
+				Action: func(ctx *cli.Context, v string) error {
+					u, err := url.Parse(v)
+					if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+						return fmt.Errorf("Invalid otlp endpoint: %v. Must be an http or https URL", v)
+					}
+					return nil
+				},
+			},
+			&cli.BoolFlag{
+				EnvVars: []string{"PPROF"},
+				Name:    "pprof",
+				Usage:   "Serve Go profiling data on /debug/pprof/ to admins",
+				Value:   false,
+			},
+			&cli.Float64Flag{
+				EnvVars: []string{"TRACE_SAMPLE_RATIO"},
+				Name:    "trace-sample-ratio",
+				Usage:   "Fraction of requests and jobs that are traced",
+				Value:   1,
+				Action: func(ctx *cli.Context, v float64) error {
+					if v < 0 || v > 1 {
+						return fmt.Errorf("Invalid trace sample ratio: %v. Must be in range [0-1]", v)
+					}
+					return nil
+				},
+			},
+			&cli.StringSliceFlag{
+				EnvVars: []string{"TRUSTED_PROXIES"},
+				Name:    "trusted-proxies",
+				Usage:   "CIDRs of reverse proxies whose client ip header is believed. Empty trusts none",
+				Action: func(ctx *cli.Context, v []string) error {
+					_, err := clientip.ParseTrusted(v)
+					return err
+				},
+			},
+			&cli.StringFlag{
+				EnvVars: []string{"URL_SIGNING_KEY"},
+				Name:    "url-signing-key",
+				Usage:   "Base64 encoded key of at least 32 bytes that enclosure urls are signed with. Only signed urls can download audio when set",
+				Action: func(ctx *cli.Context, v string) error {
+					_, err := signing.ParseKey(v)
+					return err
+				},
+			},
+			&cli.StringFlag{
+				EnvVars: []string{"URL_SIGNING_KEY_FILE"},
+				Name:    "url-signing-key-file",
+				Usage:   "File containing the url signing key",
+			},
+			&cli.DurationFlag{
+				EnvVars: []string{"URL_SIGNING_TTL"},
+				Name:    "url-signing-ttl",
+				Usage:   "How long signed enclosure urls keep working after the feed is fetched",
+				Value:   7 * 24 * time.Hour,
+				Action: func(ctx *cli.Context, v time.Duration) error {
+					if v < time.Hour {
+						return fmt.Errorf("Invalid url signing ttl: %v. Must be at least 1h", v)
+					}
+					return nil
+				},
+			},
 			&cli.StringFlag{
 				EnvVars: []string{"USER"},
 				Name:    "user",
-				Usage:   "Username for frontend auth",
+				Usage:   "Username of the first admin, created when there are no users yet. Requests act as this user with no-auth",
 				Value:   "admin",
 				Action: func(ctx *cli.Context, val string) error {
 					if val == "" {
@@ -51,14 +279,26 @@ func main() {
 			},
 			&cli.StringFlag{
 				Name:    "password",
-				Usage:   "Password for frontend auth",
+				Usage:   "Password of the first admin, created when there are no users yet",
 				EnvVars: []string{"PASSWORD"},
 			},
 			&cli.StringFlag{
 				Name:    "password-file",
-				Usage:   "File containing the password for frontend auth",
+				Usage:   "File containing the password of the first admin",
 				EnvVars: []string{"PASSWORD_FILE"},
 			},
+			&cli.IntFlag{
+				EnvVars: []string{"MAX_CONCURRENT_DOWNLOADS"},
+				Name:    "max-concurrent-downloads",
+				Usage:   "Maximum number of audio downloads to run at once, including prefetching",
+				Value:   2,
+				Action: func(ctx *cli.Context, v int) error {
+					if v < 1 {
+						return fmt.Errorf("Invalid max concurrent downloads: %v. Must be at least 1", v)
+					}
+					return nil
+				},
+			},
 			&cli.Uint64Flag{
 				Name:  "port",
 				Usage: "The port to run the web server on.",
@@ -72,37 +312,237 @@ func main() {
 					return nil
 				},
 			},
+			&cli.Float64Flag{
+				EnvVars: []string{"RATE_LIMIT_API"},
+				Name:    "rate-limit-api",
+				Usage:   "Maximum number of requests per second each client may make to the API. 0 means unlimited",
+				Value:   1,
+			},
+			&cli.IntFlag{
+				EnvVars: []string{"RATE_LIMIT_API_BURST"},
+				Name:    "rate-limit-api-burst",
+				Usage:   "Number of requests each client may make to the API in a burst",
+				Value:   10,
+			},
+			&cli.Float64Flag{
+				EnvVars: []string{"RATE_LIMIT_AUDIO"},
+				Name:    "rate-limit-audio",
+				Usage:   "Maximum number of requests per second each client may make to audio and video downloads. 0 means unlimited",
+				Value:   2,
+			},
+			&cli.IntFlag{
+				EnvVars: []string{"RATE_LIMIT_AUDIO_BURST"},
+				Name:    "rate-limit-audio-burst",
+				Usage:   "Number of requests each client may make to audio and video downloads in a burst",
+				Value:   60,
+			},
+			&cli.Float64Flag{
+				EnvVars: []string{"RATE_LIMIT_FEED"},
+				Name:    "rate-limit-feed",
+				Usage:   "Maximum number of requests per second each client may make to feeds. 0 means unlimited",
+				Value:   1,
+			},
+			&cli.IntFlag{
+				EnvVars: []string{"RATE_LIMIT_FEED_BURST"},
+				Name:    "rate-limit-feed-burst",
+				Usage:   "Number of requests each client may make to feeds in a burst",
+				Value:   30,
+			},
+			&cli.Float64Flag{
+				EnvVars: []string{"RATE_LIMIT_UI"},
+				Name:    "rate-limit-ui",
+				Usage:   "Maximum number of requests per second each client may make to the frontend. 0 means unlimited",
+				Value:   10,
+			},
+			&cli.IntFlag{
+				EnvVars: []string{"RATE_LIMIT_UI_BURST"},
+				Name:    "rate-limit-ui-burst",
+				Usage:   "Number of requests each client may make to the frontend in a burst",
+				Value:   100,
+			},
+			&cli.IntFlag{
+				EnvVars: []string{"REFRESH_CONCURRENCY"},
+				Name:    "refresh-concurrency",
+				Usage:   "Number of feeds to refresh at the same time",
+				Value:   4,
+				Action: func(ctx *cli.Context, v int) error {
+					if v < 1 {
+						return fmt.Errorf("Invalid refresh concurrency: %v. Must be at least 1", v)
+					}
+					return nil
+				},
+			},
+			&cli.DurationFlag{
+				EnvVars: []string{"REFRESH_TIMEOUT"},
+				Name:    "refresh-timeout",
+				Usage:   "Maximum time a single feed refresh may take before yt-dlp is killed",
+				Value:   10 * time.Minute,
+			},
+			&cli.DurationFlag{
+				EnvVars: []string{"REFRESH_INTERVAL"},
+				Name:    "refresh-interval",
+				Usage:   "How often to refresh feeds that have no interval of their own",
+				Value:   1 * time.Hour,
+			},
+			&cli.DurationFlag{
+				EnvVars: []string{"REFRESH_MIN_INTERVAL"},
+				Name:    "refresh-min-interval",
+				Usage:   "Shortest interval an adaptive feed may be refreshed at",
+				Value:   15 * time.Minute,
+			},
+			&cli.DurationFlag{
+				EnvVars: []string{"REFRESH_MAX_INTERVAL"},
+				Name:    "refresh-max-interval",
+				Usage:   "Longest interval an adaptive feed may be refreshed at",
+				Value:   24 * time.Hour,
+			},
+			&cli.Float64Flag{
+				EnvVars: []string{"REFRESH_JITTER"},
+				Name:    "refresh-jitter",
+				Usage:   "Fraction of a feed's interval its refresh may randomly move by, so refreshes don't all fire at once",
+				Value:   0.1,
+				Action: func(ctx *cli.Context, v float64) error {
+					if v < 0 || v >= 1 {
+						return fmt.Errorf("Invalid refresh jitter: %v. Must be in range [0-1)", v)
+					}
+					return nil
+				},
+			},
+			&cli.DurationFlag{
+				EnvVars: []string{"SESSION_TTL"},
+				Name:    "session-ttl",
+				Usage:   "How long a login to the frontend lasts",
+				Value:   14 * 24 * time.Hour,
+				Action: func(ctx *cli.Context, v time.Duration) error {
+					if v < time.Minute {
+						return fmt.Errorf("Invalid session ttl: %v. Must be at least 1m", v)
+					}
+					return nil
+				},
+			},
+			&cli.DurationFlag{
+				EnvVars: []string{"SHUTDOWN_TIMEOUT"},
+				Name:    "shutdown-timeout",
+				Usage:   "How long to wait on running requests and jobs when shutting down",
+				Value:   30 * time.Second,
+				Action: func(ctx *cli.Context, v time.Duration) error {
+					if v <= 0 {
+						return fmt.Errorf("Invalid shutdown timeout: %v. Must be positive", v)
+					}
+					return nil
+				},
+			},
+			&cli.StringFlag{
+				EnvVars: []string{"SPONSORBLOCK_API"},
+				Name:    "sponsorblock-api",
+				Usage:   "The SponsorBlock server to get segments from, such as a local mirror. Empty uses yt-dlp's default",
+				Value:   "",
+			},
+			&cli.BoolFlag{
+				EnvVars: []string{"WEBSUB"},
+				Name:    "websub",
+				Usage:   "Subscribe to channel feeds through a WebSub hub and refresh them as soon as they are pushed. Requires base-url to be reachable by the hub",
+				Value:   false,
+			},
+			&cli.StringFlag{
+				EnvVars: []string{"WEBSUB_HUB"},
+				Name:    "websub-hub",
+				Usage:   "The WebSub hub to subscribe with",
+				Value:   "https://pubsubhubbub.appspot.com/subscribe",
+			},
+			&cli.StringFlag{
+				EnvVars: []string{"WEBSUB_TOPIC_URL"},
+				Name:    "websub-topic-url",
+				Usage:   "The channel feed url to subscribe to, without the channel_id query",
+				Value:   "https://www.youtube.com/xml/feeds/videos.xml",
+			},
+			&cli.DurationFlag{
+				EnvVars: []string{"WEBSUB_LEASE"},
+				Name:    "websub-lease",
+				Usage:   "How long to ask the hub to keep each subscription for. Leases are renewed when a quarter of this remains",
+				Value:   5 * 24 * time.Hour,
+			},
+			&cli.StringFlag{
+				EnvVars: []string{"YOUTUBE_FEED_URL"},
+				Name:    "youtube-feed-url",
+				Usage:   "The channel Atom feed endpoint checked for new videos before running yt-dlp",
+				Value:   "https://www.youtube.com/feeds/videos.xml",
+			},
+			&cli.Float64Flag{
+				EnvVars: []string{"YOUTUBE_RATE_LIMIT"},
+				Name:    "youtube-rate-limit",
+				Usage:   "Maximum number of YouTube requests per second made by the updater. 0 means unlimited",
+				Value:   1,
+			},
+			&cli.IntFlag{
+				EnvVars: []string{"YOUTUBE_RATE_BURST"},
+				Name:    "youtube-rate-burst",
+				Usage:   "Number of YouTube requests the updater may make in a burst",
+				Value:   4,
+			},
 		},
 		Before: func(ctx *cli.Context) error {
-			authEnabled := !ctx.Bool("no-auth")
-			passwordVal := ctx.String("password")
-			passwordFileVal := ctx.String("password-file")
-			userVal := ctx.String("user")
-
-			if authEnabled {
-				userEmpty := userVal == ""
-				userProvidedButNoPass := userVal != "" && passwordFileVal == "" && passwordVal == ""
-				bothPassFlagsSet := passwordVal != "" && passwordFileVal != ""
-
-				if userEmpty {
-					return fmt.Errorf("When auth is enabled, user cannot be empty.")
+			if ctx.Duration("login-lockout-max") < ctx.Duration("login-lockout") {
+				return fmt.Errorf("login-lockout-max cannot be shorter than login-lockout.")
+			}
+			if ctx.String("metrics-token") != "" && ctx.String("metrics-token-file") != "" {
+				return fmt.Errorf("Cannot set both a metrics-token and a metrics-token-file.")
+			}
+			if ctx.String("cookie-key") != "" && ctx.String("cookie-key-file") != "" {
+				return fmt.Errorf("Cannot set both a cookie-key and a cookie-key-file.")
+			}
+			if ctx.String("url-signing-key") != "" && ctx.String("url-signing-key-file") != "" {
+				return fmt.Errorf("Cannot set both a url-signing-key and a url-signing-key-file.")
+			}
+			if ctx.String("oidc-issuer") != "" {
+				if ctx.String("oidc-client-id") == "" {
+					return fmt.Errorf("An oidc-client-id is required with an oidc-issuer.")
 				}
-				if userProvidedButNoPass {
-					return fmt.Errorf("Password is required when auth enabled and user specified.")
+				if ctx.String("oidc-client-secret") != "" && ctx.String("oidc-client-secret-file") != "" {
+					return fmt.Errorf("Cannot set both an oidc-client-secret and an oidc-client-secret-file.")
 				}
-				if bothPassFlagsSet {
-					return fmt.Errorf("Cannot set both a password and a password-file.")
+				noneAllowed := len(ctx.StringSlice("oidc-allowed-emails")) == 0 &&
+					len(ctx.StringSlice("oidc-allowed-domains")) == 0 &&
+					len(ctx.StringSlice("oidc-allowed-groups")) == 0
+				if noneAllowed {
+					return fmt.Errorf("At least one of oidc-allowed-emails, oidc-allowed-domains or oidc-allowed-groups is required with an oidc-issuer.")
 				}
 			}
 
 			return nil
 		},
 		Action: func(cCtx *cli.Context) error {
+			if err := checkAuthFlags(cCtx); err != nil {
+				return err
+			}
 			return serve(cCtx)
 		},
+		Commands: commands(),
 	}
+}
 
-	if err := app.Run(os.Args); err != nil {
-		log.Fatal(err)
+// checkAuthFlags checks the bootstrap user flags, which only the server
+// needs. The subcommands manage users themselves.
+func checkAuthFlags(ctx *cli.Context) error {
+	authEnabled := !ctx.Bool("no-auth")
+	passwordVal := ctx.String("password")
+	passwordFileVal := ctx.String("password-file")
+	userVal := ctx.String("user")
+
+	if authEnabled {
+		userEmpty := userVal == ""
+		userProvidedButNoPass := userVal != "" && passwordFileVal == "" && passwordVal == ""
+		bothPassFlagsSet := passwordVal != "" && passwordFileVal != ""
+
+		if userEmpty {
+			return fmt.Errorf("When auth is enabled, user cannot be empty.")
+		}
+		if userProvidedButNoPass {
+			return fmt.Errorf("Password is required when auth enabled and user specified.")
+		}
+		if bothPassFlagsSet {
+			return fmt.Errorf("Cannot set both a password and a password-file.")
+		}
 	}
+	return nil
 }
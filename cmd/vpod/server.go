@@ -1,16 +1,24 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"log/slog"
+	"net"
 	"net/http"
+	"net/http/pprof"
 	"os"
+	"os/signal"
+	"slices"
 	"strings"
+	"syscall"
 	"time"
 	"vpod/internal/api"
 	"vpod/internal/handlers"
+	"vpod/internal/metrics"
 	"vpod/internal/middleware"
+	"vpod/internal/ratelimit"
 	"vpod/internal/router"
 
 	"github.com/urfave/cli/v2"
@@ -30,11 +38,46 @@ func panicHandler(logger *slog.Logger) func(http.Handler) http.Handler {
 	}
 }
 
+// isLoopback reports whether host only accepts connections from this
+// machine. Empty and unspecified addresses listen everywhere.
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
+
+// secretFlags have values that mustn't be shown, even to admins.
+var secretFlags = []string{"password", "secret", "key", "token"}
+
+// settings lists the flags vpod was started with, for the status page.
+// Secrets only show whether they are set, files holding them are shown.
+func settings(cCtx *cli.Context) []handlers.Setting {
+	var list []handlers.Setting
+	for _, f := range cCtx.App.Flags {
+		name := f.Names()[0]
+		var value string
+		switch v := cCtx.Value(name).(type) {
+		case cli.StringSlice:
+			value = strings.Join(v.Value(), ", ")
+		case *cli.StringSlice:
+			value = strings.Join(v.Value(), ", ")
+		default:
+			value = fmt.Sprint(v)
+		}
+		if !strings.HasSuffix(name, "-file") && value != "" &&
+			slices.ContainsFunc(secretFlags, func(s string) bool { return strings.Contains(name, s) }) {
+			value = "(set)"
+		}
+		list = append(list, handlers.Setting{Name: name, Value: value})
+	}
+	slices.SortFunc(list, func(a, b handlers.Setting) int { return strings.Compare(a.Name, b.Name) })
+	return list
+}
+
 func serve(cCtx *cli.Context) error {
-	env, err := NewEnv(
-		cCtx.String("log-level"),
-		cCtx.String("base-url"),
-	)
+	env, err := NewEnv(cCtx)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -56,26 +99,164 @@ func serve(cCtx *cli.Context) error {
 		wantedPass = cCtx.String("password")
 	}
 
+	if cCtx.Bool("no-auth") && !isLoopback(cCtx.String("host")) {
+		logger.Warn(
+			"auth is turned off while listening beyond this machine, anyone who can reach vpod can manage it",
+			slog.String("host", cCtx.String("host")),
+		)
+	}
+
+	// The user flags only seed the first admin, later users live in the DB
+	if err := env.users.Bootstrap(cCtx.Context, wantedUser, wantedPass); err != nil {
+		return err
+	}
+
 	r := router.New()
+	r.Use(middleware.ClientIP(env.clientIP))
+	r.Use(middleware.Trace())
 	r.Use(middleware.LogRequest(logger))
 	r.Use(panicHandler(logger))
 
-	r.HandleFunc("GET /audio/", handlers.Audio())
-	r.HandleFunc("GET /feed/", handlers.Feed(env.queries))
+	// Probes for orchestrators, without auth or rate limits
+	r.HandleFunc("GET /healthz", handlers.Healthz())
+	r.HandleFunc("GET /readyz", handlers.Readyz(env.checker))
 
-	r.Group("/api", api.Routes)
-	r.Group("/ui", func(r *router.Router) {
-		if !cCtx.Bool("no-auth") {
-			r.Use(middleware.NewBasicAuth(&wantedUser, &wantedPass))
+	// Media and feeds are limited separately, an app downloading a backlog
+	// of episodes shouldn't stop it from refreshing feeds
+	media := func(r *router.Router) {
+		r.Group("", func(r *router.Router) {
+			r.Use(middleware.RateLimit(env.limits.audio, ratelimit.ByClientIP))
+			r.HandleFunc("GET /audio/{videoID}/{formatID}", handlers.Audio(env.downloader, env.tokens, env.signer))
+			r.HandleFunc("GET /video/{videoID}/{formatID}", handlers.Video(env.downloader, env.tokens, env.signer))
+		})
+		r.Group("", func(r *router.Router) {
+			r.Use(middleware.RateLimit(env.limits.feed, ratelimit.ByClientIP))
+			r.HandleFunc("GET /feed/", handlers.Feed(env.queries, env.tokens, env.signer))
+		})
+	}
+	r.Group("", media)
+
+	// Private feeds, through a subscriber's token
+	r.Group("/f/{token}", func(r *router.Router) {
+		r.Use(middleware.FeedToken(env.tokens))
+		media(r)
+	})
+
+	if cCtx.Bool("metrics") {
+		token := cCtx.String("metrics-token")
+		if path := cCtx.String("metrics-token-file"); path != "" {
+			contents, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			token = strings.TrimSpace(string(contents))
+		}
+		if token == "" {
+			logger.Warn("metrics are served without a token, anyone who can reach vpod can read them")
+		}
+		r.Group("", func(r *router.Router) {
+			r.Use(middleware.BearerToken(token))
+			r.Handle("GET /metrics", metrics.Handler())
+		})
+	}
+
+	if env.websub != nil {
+		r.HandleFunc("GET /websub/{feedID}", env.websub.Verify())
+		r.HandleFunc("POST /websub/{feedID}", env.websub.Notify())
+	}
+
+	r.Group("/api", func(r *router.Router) {
+		r.Use(middleware.RateLimit(env.limits.api, ratelimit.ByClientIP))
+		api.Routes(r)
+	})
+
+	// Session cookies only go over https when vpod is served over it
+	secureCookies := env.baseURL.Scheme == "https"
+
+	auth := middleware.NewSessionAuth(env.users, env.sessions, env.guard, "/ui/login")
+	if cCtx.Bool("no-auth") {
+		asUser, err := middleware.AsUser(cCtx.Context, env.users, wantedUser)
+		if err != nil {
+			return err
+		}
+		auth = asUser
+	}
+
+	r.Group("/debug", func(r *router.Router) {
+		r.Use(middleware.RateLimit(env.limits.ui, ratelimit.ByClientIP))
+		r.Use(auth)
+		r.Use(middleware.RequireAdmin)
+		r.HandleFunc("GET /status", handlers.DebugStatus(env.checker, *env.scheduler, settings(cCtx), env.started, cCtx.Bool("pprof")))
+		if cCtx.Bool("pprof") {
+			r.HandleFunc("GET /pprof/", pprof.Index)
+			r.HandleFunc("GET /pprof/cmdline", pprof.Cmdline)
+			r.HandleFunc("GET /pprof/profile", pprof.Profile)
+			r.HandleFunc("GET /pprof/symbol", pprof.Symbol)
+			r.HandleFunc("POST /pprof/symbol", pprof.Symbol)
+			r.HandleFunc("GET /pprof/trace", pprof.Trace)
 		}
+	})
+
+	r.Group("/ui", func(r *router.Router) {
+		r.Use(middleware.RateLimit(env.limits.ui, ratelimit.ByClientIP))
 
 		// The trailing slash is important here
 		// TODO: revisit after embeddings
 		r.Handle("GET /static/", handlers.Static())
+		r.HandleFunc("GET /login", handlers.LoginPage(env.sso))
+		r.HandleFunc("POST /login", handlers.Login(env.users, env.sessions, env.guard, env.sso, secureCookies))
+		if env.sso != nil {
+			r.HandleFunc("GET /oidc/login", handlers.SSOLogin(env.sso, secureCookies))
+			r.HandleFunc("GET /oidc/callback", handlers.SSOCallback(env.sso, env.sessions, secureCookies))
+		}
+
+		r.Group("", func(r *router.Router) {
+			r.Use(auth)
+
+			r.HandleFunc("GET /", handlers.Index(env.cookies))
+			r.HandleFunc("GET /feeds", handlers.GetFeeds(cCtx, env.queries, env.cookies))
+			r.HandleFunc("POST /gen", handlers.GenFeed(cCtx, env.queries, env.cookies))
+			r.HandleFunc("POST /logout", handlers.Logout(env.sessions, secureCookies))
+			r.HandleFunc("POST /account/password", handlers.SetUserPassword(env.users, env.sessions))
+			r.HandleFunc("POST /episodes/{videoID}/pin", handlers.PinEpisode(env.queries, env.users, true))
+			r.HandleFunc("DELETE /episodes/{videoID}/pin", handlers.PinEpisode(env.queries, env.users, false))
+
+			// Only feeds in the user's library
+			r.Group("/feeds/{feedID}", func(r *router.Router) {
+				r.Use(middleware.RequireFeed(env.users))
+				r.HandleFunc("DELETE ", handlers.RemoveFeed(env.users))
+				r.HandleFunc("GET /tokens", handlers.GetFeedTokens(env.tokens))
+				r.HandleFunc("POST /tokens", handlers.CreateFeedToken(env.queries, env.tokens))
+				r.HandleFunc("POST /tokens/{tokenID}/rotate", handlers.RotateFeedToken(env.tokens))
+				r.HandleFunc("DELETE /tokens/{tokenID}", handlers.RevokeFeedToken(env.tokens))
+			})
 
-		r.HandleFunc("GET /", handlers.Index())
-		r.HandleFunc("GET /feeds", handlers.GetFeeds(cCtx, env.queries))
-		r.HandleFunc("POST /gen", handlers.GenFeed(cCtx, env.queries))
+			// Server wide settings
+			r.Group("", func(r *router.Router) {
+				r.Use(middleware.RequireAdmin)
+				r.HandleFunc("GET /cache", handlers.CacheReport(env.culler))
+				r.HandleFunc("PUT /cookies", handlers.PutCookies(env.cookies))
+				r.HandleFunc("POST /cookies", handlers.PutCookies(env.cookies))
+				r.HandleFunc("DELETE /cookies", handlers.DeleteCookies(env.cookies))
+				r.HandleFunc("GET /users", handlers.GetUsers(env.users))
+				r.HandleFunc("POST /users", handlers.CreateUser(env.users))
+				r.HandleFunc("DELETE /users/{userID}", handlers.DeleteUser(env.users, env.sessions))
+				r.HandleFunc("POST /users/{userID}/password", handlers.SetUserPassword(env.users, env.sessions))
+
+				// Feeds are shared, so their settings are too
+				r.Group("/feeds/{feedID}", func(r *router.Router) {
+					r.HandleFunc("POST /schedule", handlers.SetFeedSchedule(env.queries))
+					r.HandleFunc("POST /prefetch", handlers.SetFeedPrefetch(env.queries))
+					r.HandleFunc("POST /sponsorblock", handlers.SetFeedSponsorBlock(env.queries))
+					r.HandleFunc("POST /postprocess", handlers.SetFeedPostProcess(env.queries))
+					r.HandleFunc("POST /media", handlers.SetFeedMedia(env.queries))
+					r.HandleFunc("POST /private", handlers.SetFeedPrivate(env.queries))
+					r.HandleFunc("PUT /cookies", handlers.PutCookies(env.cookies))
+					r.HandleFunc("POST /cookies", handlers.PutCookies(env.cookies))
+					r.HandleFunc("DELETE /cookies", handlers.DeleteCookies(env.cookies))
+				})
+			})
+		})
 	})
 
 	address := fmt.Sprintf("%s:%d", cCtx.String("host"), cCtx.Uint64("port"))
@@ -86,6 +267,33 @@ func serve(cCtx *cli.Context) error {
 		IdleTimeout:  300 * time.Second,
 		Handler:      r,
 	}
-	logger.Info("starting server", slog.String("address", address))
-	return srv.ListenAndServe()
+	ctx, stop := signal.NotifyContext(cCtx.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, 1)
+	go func() {
+		logger.Info("starting server", slog.String("address", address))
+		errs <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	// Another signal kills vpod straight away
+	stop()
+
+	timeout := cCtx.Duration("shutdown-timeout")
+	logger.Info("shutting down", slog.Duration("timeout", timeout))
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	// Requests waiting on a download would otherwise hold up Shutdown until
+	// the timeout. The deferred Cleanup waits for this to finish.
+	go env.downloader.Close()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Warn("requests were still running at the shutdown timeout", slog.String("err", err.Error()))
+		srv.Close()
+	}
+	// The deferred Cleanup stops jobs
+	return nil
 }
@@ -0,0 +1,257 @@
+// Package accounts keeps the users of the frontend and the feeds in each of
+// their libraries. Feeds themselves are shared, two users adding the same
+// channel get the same feed.
+package accounts
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"vpod/internal/data"
+
+	"github.com/mattn/go-sqlite3"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Passwords set through the frontend must be at least MinPasswordLength
+// bytes. bcrypt can't hash more than MaxPasswordLength.
+const (
+	MinPasswordLength = 8
+	MaxPasswordLength = 72
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrUserExists         = errors.New("user already exists")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrInvalidUsername    = errors.New("usernames must be 1 to 64 letters, numbers, dots, dashes or underscores")
+	ErrInvalidPassword    = fmt.Errorf("passwords must be %d to %d bytes long", MinPasswordLength, MaxPasswordLength)
+	ErrExternalUser       = errors.New("user signs in through SSO and has no password")
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+type Store struct {
+	queries *data.Queries
+}
+
+func New(queries *data.Queries) *Store {
+	return &Store{queries: queries}
+}
+
+func validatePassword(password string) error {
+	if len(password) < MinPasswordLength || len(password) > MaxPasswordLength {
+		return ErrInvalidPassword
+	}
+	return nil
+}
+
+func hashPassword(password string) (string, error) {
+	if len(password) > MaxPasswordLength {
+		return "", ErrInvalidPassword
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Create adds a user with an empty library.
+func (s *Store) Create(ctx context.Context, username, password string, admin bool) (data.User, error) {
+	if err := validatePassword(password); err != nil {
+		return data.User{}, err
+	}
+	return s.create(ctx, username, password, admin, false)
+}
+
+func (s *Store) create(ctx context.Context, username, password string, admin, external bool) (data.User, error) {
+	if !usernamePattern.MatchString(username) {
+		return data.User{}, ErrInvalidUsername
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return data.User{}, err
+	}
+	u, err := s.queries.CreateUser(ctx, data.CreateUserParams{
+		Username:     username,
+		PasswordHash: hash,
+		IsAdmin:      admin,
+		IsExternal:   external,
+	})
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return data.User{}, ErrUserExists
+	}
+	return u, err
+}
+
+func randomPassword() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawStdEncoding.EncodeToString(b), nil
+}
+
+// CreateExternal adds a user who signs in somewhere else, such as through
+// OIDC. Their password is random and can't be used or changed. A taken
+// username gets a number added.
+func (s *Store) CreateExternal(ctx context.Context, username string, admin bool) (data.User, error) {
+	password, err := randomPassword()
+	if err != nil {
+		return data.User{}, err
+	}
+	name := username
+	for i := 2; ; i++ {
+		u, err := s.create(ctx, name, password, admin, true)
+		if !errors.Is(err, ErrUserExists) || i > 100 {
+			return u, err
+		}
+		name = fmt.Sprintf("%s-%d", username, i)
+	}
+}
+
+// Bootstrap creates the first admin, from the user and password flags, when
+// there are no users yet. They get every existing feed, so nothing goes
+// missing for installs from before there were users. An empty password gets
+// a random one, for running without auth. The password flag has never had
+// a minimum length, so it doesn't get one here either.
+func (s *Store) Bootstrap(ctx context.Context, username, password string) error {
+	n, err := s.queries.CountUsers(ctx)
+	if err != nil || n > 0 {
+		return err
+	}
+	if password == "" {
+		password, err = randomPassword()
+		if err != nil {
+			return err
+		}
+	}
+	u, err := s.create(ctx, username, password, true, false)
+	if err != nil {
+		return fmt.Errorf("could not create the first admin: %w", err)
+	}
+	return s.queries.AddAllFeedsToUser(ctx, u.ID)
+}
+
+// dummyHash is checked against when there is no such user, so that takes
+// as long as a wrong password and doesn't give away who has an account.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+
+// Authenticate returns the user if the password is theirs. It takes as long
+// whether or not the user exists. External users never have a password.
+func (s *Store) Authenticate(ctx context.Context, username, password string) (data.User, error) {
+	u, err := s.queries.GetUserByUsername(ctx, username)
+	if errors.Is(err, sql.ErrNoRows) {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return data.User{}, ErrInvalidCredentials
+	} else if err != nil {
+		return data.User{}, err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)); err != nil || u.IsExternal {
+		return data.User{}, ErrInvalidCredentials
+	}
+	return u, nil
+}
+
+func (s *Store) Get(ctx context.Context, id int64) (data.User, error) {
+	u, err := s.queries.GetUser(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return data.User{}, ErrUserNotFound
+	}
+	return u, err
+}
+
+func (s *Store) GetByUsername(ctx context.Context, username string) (data.User, error) {
+	u, err := s.queries.GetUserByUsername(ctx, username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return data.User{}, ErrUserNotFound
+	}
+	return u, err
+}
+
+func (s *Store) List(ctx context.Context) ([]data.User, error) {
+	return s.queries.GetUsers(ctx)
+}
+
+// SetPassword changes a local user's password. External users get
+// ErrExternalUser.
+func (s *Store) SetPassword(ctx context.Context, id int64, password string) error {
+	u, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if u.IsExternal {
+		return ErrExternalUser
+	}
+	if err := validatePassword(password); err != nil {
+		return err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	return s.queries.SetUserPassword(ctx, data.SetUserPasswordParams{
+		PasswordHash: hash,
+		ID:           id,
+	})
+}
+
+// SetAdmin grants or takes away a user's admin rights.
+func (s *Store) SetAdmin(ctx context.Context, id int64, admin bool) error {
+	return s.queries.SetUserAdmin(ctx, data.SetUserAdminParams{IsAdmin: admin, ID: id})
+}
+
+// Delete removes a user, their library and their links to outside
+// identities. The feeds stay, other users may have them too.
+func (s *Store) Delete(ctx context.Context, id int64) error {
+	if _, err := s.Get(ctx, id); err != nil {
+		return err
+	}
+	if err := s.queries.DeleteUserFeeds(ctx, id); err != nil {
+		return err
+	}
+	if err := s.queries.DeleteUserIdentities(ctx, id); err != nil {
+		return err
+	}
+	return s.queries.DeleteUser(ctx, id)
+}
+
+// AddFeed puts a feed in a user's library.
+func (s *Store) AddFeed(ctx context.Context, userID int64, feedID string) error {
+	return s.queries.AddUserFeed(ctx, data.AddUserFeedParams{UserID: userID, FeedID: feedID})
+}
+
+// RemoveFeed takes a feed out of a user's library.
+func (s *Store) RemoveFeed(ctx context.Context, userID int64, feedID string) error {
+	return s.queries.RemoveUserFeed(ctx, data.RemoveUserFeedParams{UserID: userID, FeedID: feedID})
+}
+
+// HasFeed reports whether a feed is in a user's library.
+func (s *Store) HasFeed(ctx context.Context, userID int64, feedID string) (bool, error) {
+	n, err := s.queries.UserHasFeed(ctx, data.UserHasFeedParams{UserID: userID, FeedID: feedID})
+	return n > 0, err
+}
+
+// HasVideo reports whether a video is an episode of any feed in a user's
+// library.
+func (s *Store) HasVideo(ctx context.Context, userID int64, videoID string) (bool, error) {
+	n, err := s.queries.UserHasVideo(ctx, data.UserHasVideoParams{UserID: userID, VideoID: videoID})
+	return n > 0, err
+}
+
+// WithUser returns a copy of ctx carrying the signed in user.
+func WithUser(ctx context.Context, u data.User) context.Context {
+	return context.WithValue(ctx, "user", u)
+}
+
+// FromContext returns the signed in user, if there is one.
+func FromContext(ctx context.Context) (data.User, bool) {
+	u, ok := ctx.Value("user").(data.User)
+	return u, ok
+}
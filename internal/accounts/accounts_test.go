@@ -0,0 +1,181 @@
+package accounts
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"vpod/internal/data"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func initDb(t *testing.T) *data.Queries {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	if err := data.Migrate(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	return data.New(db)
+}
+
+func TestAuthenticate(t *testing.T) {
+	s := New(initDb(t))
+	ctx := context.Background()
+
+	created, err := s.Create(ctx, "alice", "correct horse", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.PasswordHash == "correct horse" {
+		t.Fatal("password was stored in plain text")
+	}
+	if _, err := s.Create(ctx, "alice", "another password", false); !errors.Is(err, ErrUserExists) {
+		t.Errorf("Create() of a taken username err = %v, want ErrUserExists", err)
+	}
+	if _, err := s.Create(ctx, "bob", "short", false); !errors.Is(err, ErrInvalidPassword) {
+		t.Errorf("Create() with a short password err = %v, want ErrInvalidPassword", err)
+	}
+	if _, err := s.Create(ctx, "bob smith", "long enough", false); !errors.Is(err, ErrInvalidUsername) {
+		t.Errorf("Create() with a space in the username err = %v, want ErrInvalidUsername", err)
+	}
+
+	u, err := s.Authenticate(ctx, "alice", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ID != created.ID {
+		t.Errorf("Authenticate() = %+v, want %+v", u, created)
+	}
+	for _, tt := range []struct{ username, password string }{
+		{"alice", "wrong horse"},
+		{"mallory", "correct horse"},
+	} {
+		if _, err := s.Authenticate(ctx, tt.username, tt.password); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Authenticate(%q, %q) err = %v, want ErrInvalidCredentials", tt.username, tt.password, err)
+		}
+	}
+
+	if err := s.SetPassword(ctx, u.ID, "battery staple"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Authenticate(ctx, "alice", "battery staple"); err != nil {
+		t.Errorf("Authenticate() with the new password err = %v", err)
+	}
+}
+
+func TestExternalUsersHaveNoPassword(t *testing.T) {
+	queries := initDb(t)
+	s := New(queries)
+	ctx := context.Background()
+
+	u, err := s.CreateExternal(ctx, "alice", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !u.IsExternal {
+		t.Error("CreateExternal() user isn't marked as external")
+	}
+	if err := s.SetPassword(ctx, u.ID, "correct horse"); !errors.Is(err, ErrExternalUser) {
+		t.Errorf("SetPassword() of an external user err = %v, want ErrExternalUser", err)
+	}
+
+	// Even a password that matches doesn't sign them in
+	hash, err := hashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := queries.SetUserPassword(ctx, data.SetUserPasswordParams{PasswordHash: hash, ID: u.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Authenticate(ctx, "alice", "correct horse"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Authenticate() of an external user err = %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func TestBootstrap(t *testing.T) {
+	queries := initDb(t)
+	s := New(queries)
+	ctx := context.Background()
+
+	// A feed from before there were users
+	err := queries.UpsertFeed(ctx, data.UpsertFeedParams{ID: []byte("UCold"), Title: "Old", Link: "l", Xml: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The password flag never had a minimum length
+	if err := s.Bootstrap(ctx, "admin", "pw"); err != nil {
+		t.Fatal(err)
+	}
+	admin, err := s.Authenticate(ctx, "admin", "pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !admin.IsAdmin {
+		t.Error("the first user should be an admin")
+	}
+	if has, err := s.HasFeed(ctx, admin.ID, "UCold"); err != nil || !has {
+		t.Errorf("HasFeed() = %v, %v, want the existing feed in the admin's library", has, err)
+	}
+
+	// Only ever runs once
+	if err := s.Bootstrap(ctx, "someone", "else"); err != nil {
+		t.Fatal(err)
+	}
+	users, err := s.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 {
+		t.Errorf("List() = %+v, want only the first admin", users)
+	}
+}
+
+func TestLibraries(t *testing.T) {
+	s := New(initDb(t))
+	ctx := context.Background()
+
+	alice, err := s.Create(ctx, "alice", "correct horse", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := s.Create(ctx, "bob", "battery staple", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range []data.User{alice, bob} {
+		if err := s.AddFeed(ctx, u.ID, "UCshared"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Adding twice is fine
+	if err := s.AddFeed(ctx, alice.ID, "UCshared"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Delete(ctx, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := s.HasFeed(ctx, alice.ID, "UCshared"); has {
+		t.Error("a deleted user's library should be removed")
+	}
+	if has, _ := s.HasFeed(ctx, bob.ID, "UCshared"); !has {
+		t.Error("removing one user's library shouldn't affect another's")
+	}
+
+	if err := s.RemoveFeed(ctx, bob.ID, "UCshared"); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := s.HasFeed(ctx, bob.ID, "UCshared"); has {
+		t.Error("RemoveFeed() left the feed in the library")
+	}
+	if err := s.Delete(ctx, alice.ID); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Delete() of a deleted user err = %v, want ErrUserNotFound", err)
+	}
+}
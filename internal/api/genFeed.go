@@ -37,14 +37,21 @@ func genFeed(cCtx *cli.Context, queries *data.Queries) http.HandlerFunc {
 			Host:   "youtube.com",
 			Path:   strings.TrimPrefix(r.URL.Path, "/gen/"),
 		}
-		c, err := youtube.FetchChannel(&ytURL, youtube.WithNItems(20))
+		c, err := youtube.FetchChannel(ctx, &ytURL, youtube.WithNItems(20))
 		if err != nil {
 			logger.With(slog.String("err", err.Error())).Error("Something went wrong when fetching feed")
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		p, err := podcast.FromChannel(*c, *baseURL) // TODO: decide what to do about PubDate
+		// Regenerating an existing feed keeps its settings
+		opts, err := podcast.FeedOptions(ctx, queries, c.Id)
+		if err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Something went wrong when getting feed settings")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		p, err := podcast.FromChannel(*c, *baseURL, opts...) // TODO: decide what to do about PubDate
 		if err != nil {
 			logger.With(slog.String("err", err.Error())).Error("Something went wrong when generating feed")
 			http.Error(w, err.Error(), http.StatusInternalServerError)
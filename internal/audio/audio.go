@@ -0,0 +1,427 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"vpod/internal/cookies"
+	"vpod/internal/data"
+	"vpod/internal/metrics"
+	"vpod/internal/proc"
+	"vpod/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+type Metadata struct {
+	FormatId string
+	VideoId  string
+	// Video is set for muxed video formats served from /video
+	Video bool
+	// FeedId is the feed the episode is served for, whose settings it's
+	// produced with. Without one it's produced with DefaultSettings.
+	FeedId string
+}
+
+var (
+	// videoIDPattern matches YouTube video ids, which are 11 characters of
+	// URL safe base64.
+	videoIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+	// formatIDPattern matches yt-dlp format ids such as 140, 251-drc or
+	// hls-1080p, and keeps out anything that isn't safe in an argument or
+	// filename, like bestaudio/best or 137+140. Single word selectors like
+	// best or bv still match, so which formats may be downloaded is up to
+	// the caller.
+	formatIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+)
+
+var ErrInvalidMetadata = errors.New("invalid video or format id")
+
+// NewMetadata checks the ids of a video and one of its formats, as given in
+// a request, before they go anywhere near yt-dlp or the filesystem.
+func NewMetadata(videoID, formatID string, video bool) (Metadata, error) {
+	m := Metadata{FormatId: formatID, VideoId: videoID, Video: video}
+	if err := m.Validate(); err != nil {
+		return Metadata{}, err
+	}
+	return m, nil
+}
+
+// Validate reports whether m's ids are safe to pass to yt-dlp and use in
+// filenames.
+func (m Metadata) Validate() error {
+	if !videoIDPattern.MatchString(m.VideoId) {
+		return fmt.Errorf("%w: video id %q", ErrInvalidMetadata, m.VideoId)
+	}
+	if !formatIDPattern.MatchString(m.FormatId) {
+		return fmt.Errorf("%w: format id %q", ErrInvalidMetadata, m.FormatId)
+	}
+	return nil
+}
+
+func (m Metadata) ext() string {
+	if m.Video {
+		return "mp4"
+	}
+	return "m4a"
+}
+
+// MetadataFromURL gets the video and format ids back out of an enclosure url
+// ending in /audio/{videoId}/{formatId} or /video/{videoId}/{formatId}.
+func MetadataFromURL(rawURL string) (Metadata, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return Metadata{}, err
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 3 {
+		return Metadata{}, fmt.Errorf("not an enclosure url: %s", rawURL)
+	}
+	kind := parts[len(parts)-3]
+	if kind != "audio" && kind != "video" {
+		return Metadata{}, fmt.Errorf("not an enclosure url: %s", rawURL)
+	}
+	return NewMetadata(parts[len(parts)-2], parts[len(parts)-1], kind == "video")
+}
+
+type Config struct {
+	// Dir is where audio files are stored.
+	Dir string
+	// MaxConcurrentDownloads bounds the number of yt-dlp downloads running
+	// at once, across requests and prefetching.
+	MaxConcurrentDownloads int
+	// MaxCacheBytes is the budget for cached audio. Prefetching stops once
+	// the cache is full.
+	MaxCacheBytes int64
+	// SponsorBlockAPI is the SponsorBlock server yt-dlp asks for segments.
+	// Empty uses yt-dlp's default.
+	SponsorBlockAPI string
+	// Cookies are passed to yt-dlp for episodes that need a login. May be
+	// nil.
+	Cookies *cookies.Store
+}
+
+type Downloader struct {
+	cookies         *cookies.Store
+	dir             string
+	logger          *slog.Logger
+	maxCacheBytes   int64
+	queries         *data.Queries
+	sem             chan struct{}
+	sponsorBlockAPI string
+
+	mu       sync.Mutex
+	inflight map[string]*download
+	closed   bool
+
+	// ctx is cancelled by Close, stopping every download. Downloads aren't
+	// tied to the request that started them, others may be waiting on them.
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	prefetch chan Metadata
+	// queued are the episodes waiting in prefetch, guarded by mu
+	queued map[Metadata]bool
+}
+
+// ErrClosed is returned for downloads asked for after Close.
+var ErrClosed = errors.New("downloader is closed")
+
+// download is a yt-dlp run that callers asking for the same file wait on.
+type download struct {
+	done chan struct{}
+	err  error
+}
+
+func New(logger *slog.Logger, queries *data.Queries, cfg Config) (*Downloader, error) {
+	if cfg.MaxConcurrentDownloads < 1 {
+		return nil, errors.New("max concurrent downloads must be at least 1")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Downloader{
+		cookies:         cfg.Cookies,
+		dir:             cfg.Dir,
+		logger:          logger,
+		maxCacheBytes:   cfg.MaxCacheBytes,
+		queries:         queries,
+		sem:             make(chan struct{}, cfg.MaxConcurrentDownloads),
+		sponsorBlockAPI: cfg.SponsorBlockAPI,
+		inflight:        make(map[string]*download),
+		ctx:             ctx,
+		cancel:          cancel,
+		prefetch:        make(chan Metadata, prefetchQueueSize),
+		queued:          make(map[Metadata]bool),
+	}, nil
+}
+
+// Close stops any running downloads, and waits for them to clean up after
+// themselves.
+func (d *Downloader) Close() {
+	d.mu.Lock()
+	d.closed = true
+	d.mu.Unlock()
+	d.cancel()
+	d.wg.Wait()
+}
+
+// partialPrefix starts the name of every file downloads write to before
+// they are complete, so they can't be mistaken for anything else in the
+// directory.
+const partialPrefix = ".vpod-"
+
+// partialName is where a download of filename is written before it's
+// complete, marked by kind, like partial.
+func partialName(filename, kind string) string {
+	ext := filepath.Ext(filename)
+	base := strings.TrimSuffix(filepath.Base(filename), ext)
+	return filepath.Join(filepath.Dir(filename), partialPrefix+base+"."+kind+ext)
+}
+
+// RemovePartial deletes what downloads that were cut short, by a crash or
+// kill, left behind. Only call it when no other vpod is downloading to the
+// same directory.
+func (d *Downloader) RemovePartial() error {
+	matches, err := filepath.Glob(filepath.Join(d.dir, partialPrefix+"*"))
+	if err != nil {
+		return err
+	}
+	for _, path := range matches {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		d.logger.Info("removed partial download", slog.String("path", path))
+	}
+	return nil
+}
+
+// removeLeftovers deletes downloadTo and the files yt-dlp keeps next to it
+// while downloading, like its .part and thumbnail files.
+func removeLeftovers(downloadTo string) {
+	base := strings.TrimSuffix(downloadTo, filepath.Ext(downloadTo))
+	matches, _ := filepath.Glob(base + ".*")
+	for _, path := range matches {
+		os.Remove(path)
+	}
+}
+
+// filename is where the audio for m, produced with s, is cached. Each
+// format and each way of producing it gets a file of its own.
+func (d *Downloader) filename(m Metadata, s Settings) string {
+	return filepath.Join(d.dir, fmt.Sprintf("%s.%s%s.%s", m.VideoId, m.FormatId, s.suffix(), m.ext()))
+}
+
+// cached returns whether the audio for m, produced with s, is already on
+// disk. Downloads are only moved to their filename once complete, so a file
+// there is all there.
+func (d *Downloader) cached(m Metadata, s Settings) bool {
+	fileInfo, err := os.Stat(d.filename(m, s))
+	if err != nil {
+		return false
+	}
+	isNonEmpty := fileInfo.Size() != 0
+	isAFile := !fileInfo.IsDir()
+	return isNonEmpty && isAFile
+}
+
+// Get returns the path to the audio for m, downloading it first if needed.
+func (d *Downloader) Get(ctx context.Context, m Metadata, logger *slog.Logger) (string, error) {
+	if err := m.Validate(); err != nil {
+		return "", err
+	}
+	// Serve up video quickly if it already exists
+	// TODO: make configurable? This could fetch old video versions sometimes
+	s, err := d.settingsFor(ctx, m)
+	if err != nil {
+		return "", err
+	}
+	filename := d.filename(m, s)
+	if d.cached(m, s) {
+		metrics.AudioCacheRequests.WithLabelValues("hit").Inc()
+		// Files we didn't download aren't tracked, so this is a no-op for them
+		err := d.queries.TouchAudioFile(ctx, data.TouchAudioFileParams{
+			LastAccessedAt: sql.NullTime{Time: time.Now().UTC(), Valid: true},
+			Path:           filename,
+		})
+		if err != nil {
+			logger.Warn("could not record audio access", slog.String("err", err.Error()))
+		}
+		return filename, nil
+	}
+
+	metrics.AudioCacheRequests.WithLabelValues("miss").Inc()
+	if err := d.download(ctx, m, s, logger); err != nil {
+		return "", err
+	}
+	return filename, nil
+}
+
+// download runs yt-dlp for m, unless another caller is already doing so, in
+// which case it waits for that run to finish.
+func (d *Downloader) download(ctx context.Context, m Metadata, s Settings, logger *slog.Logger) error {
+	filename := d.filename(m, s)
+
+	d.mu.Lock()
+	if dl, ok := d.inflight[filename]; ok {
+		d.mu.Unlock()
+		select {
+		case <-dl.done:
+			return dl.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if d.closed {
+		d.mu.Unlock()
+		return ErrClosed
+	}
+	dl := &download{done: make(chan struct{})}
+	d.inflight[filename] = dl
+	d.wg.Add(1)
+	d.mu.Unlock()
+
+	defer func() {
+		d.mu.Lock()
+		delete(d.inflight, filename)
+		d.mu.Unlock()
+		close(dl.done)
+		d.wg.Done()
+	}()
+
+	select {
+	case d.sem <- struct{}{}:
+	case <-ctx.Done():
+		dl.err = ctx.Err()
+		return dl.err
+	}
+	defer func() { <-d.sem }()
+
+	cookiesPath, saveCookies, err := d.cookiesFor(ctx, m)
+	if err != nil {
+		dl.err = err
+		return dl.err
+	}
+	defer func() {
+		// Even if the listener left, the cookies yt-dlp got are worth keeping
+		if err := saveCookies(context.WithoutCancel(ctx)); err != nil {
+			logger.Warn("could not save cookie jar", slog.String("err", err.Error()))
+		}
+	}()
+
+	// Keeps the request's trace, but only Close stops the download
+	runCtx, stop := context.WithCancel(context.WithoutCancel(ctx))
+	defer stop()
+	defer context.AfterFunc(d.ctx, stop)()
+
+	dl.err = d.runYtDlp(runCtx, m, s, cookiesPath, logger)
+	return dl.err
+}
+
+// cookiesFor writes out the cookie jar of a feed m's episode is in. When
+// several of its feeds have their own jar, the first by feed id is used.
+func (d *Downloader) cookiesFor(ctx context.Context, m Metadata) (string, func(context.Context) error, error) {
+	feedID, err := d.queries.GetCookieJarFeedForVideo(ctx, m.VideoId)
+	if errors.Is(err, sql.ErrNoRows) {
+		feedID = cookies.GlobalJar
+	} else if err != nil {
+		return "", nil, err
+	}
+	return d.cookies.File(ctx, feedID)
+}
+
+func (d *Downloader) runYtDlp(ctx context.Context, m Metadata, s Settings, cookiesPath string, logger *slog.Logger) (err error) {
+	filename := d.filename(m, s)
+	// Audio is downloaded next to where it ends up, and moved there once
+	// it's complete
+	downloadTo := partialName(filename, "partial")
+	if s.PostProcess.enabled() {
+		downloadTo = partialName(filename, "orig")
+	}
+	defer removeLeftovers(downloadTo)
+
+	youtubeUrl := fmt.Sprintf("https://www.youtube.com/watch?v=%s", m.VideoId)
+	logger = logger.With(slog.String("video_url", youtubeUrl))
+
+	args := []string{
+		fmt.Sprintf("--format=%s", m.FormatId),
+		"--embed-metadata",
+		"--embed-thumbnail",
+	}
+	args = append(args, s.SponsorBlock.args(d.sponsorBlockAPI)...)
+	if cookiesPath != "" {
+		args = append(args, "--cookies="+cookiesPath)
+	}
+	args = append(args,
+		// yt-dlp treats % as the start of a template field
+		fmt.Sprintf("--output=%s", strings.ReplaceAll(downloadTo, "%", "%%")),
+		youtubeUrl,
+	)
+	cmd := proc.Command(ctx, "yt-dlp", args...)
+	var errb bytes.Buffer
+	cmd.Stderr = &errb
+	logger = logger.With(slog.String("yt_dlp_command", fmt.Sprintf("%v", cmd.Args)))
+
+	ctx, span := tracing.Start(ctx, "yt-dlp audio",
+		attribute.String("video_id", m.VideoId),
+		attribute.String("format_id", m.FormatId),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	logger.Info("getting audio")
+	start := time.Now()
+	err = cmd.Run()
+	metrics.ObserveYtDlp("audio", start, err)
+	if err != nil {
+		var exitError *exec.ExitError
+		if errors.As(err, &exitError) {
+			logger = logger.With(slog.String("stderr", errb.String()))
+		}
+		logger.Error("failed to download audio from youtube",
+			slog.String("err", err.Error()),
+		)
+		return err
+	}
+
+	if s.PostProcess.enabled() {
+		if err := d.postProcess(ctx, s.PostProcess, downloadTo, filename, logger); err != nil {
+			return err
+		}
+	} else if err := os.Rename(downloadTo, filename); err != nil {
+		return err
+	}
+
+	fileInfo, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	// Detached from the request, so the file is tracked even if the client
+	// has gone away by now
+	err = d.queries.UpsertAudioFile(context.WithoutCancel(ctx), data.UpsertAudioFileParams{
+		Path:           filename,
+		VideoID:        m.VideoId,
+		FormatID:       m.FormatId,
+		SizeBytes:      fileInfo.Size(),
+		LastAccessedAt: sql.NullTime{Time: time.Now().UTC(), Valid: true},
+	})
+	if err != nil {
+		// The file is fine, it just won't be culled
+		logger.Error("could not record downloaded audio", slog.String("err", err.Error()))
+	}
+	if err := d.recordMeasurements(context.WithoutCancel(ctx), m, filename, fileInfo.Size(), logger); err != nil {
+		// Podcast apps cope with a wrong length, it's just less tidy
+		logger.Error("could not record enclosure measurements", slog.String("err", err.Error()))
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package audio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		videoID  string
+		formatID string
+		wantErr  bool
+	}{
+		{"audio format", "dQw4w9WgXcQ", "140", false},
+		{"suffixed format", "-_dQw4w9WgX", "251-drc", false},
+		{"short video id", "dQw4w9WgXc", "140", true},
+		{"long video id", "dQw4w9WgXcQQ", "140", true},
+		{"path in video id", "../../etc/p", "140", true},
+		{"empty format", "dQw4w9WgXcQ", "", true},
+		{"format selector", "dQw4w9WgXcQ", "bestaudio/best", true},
+		{"merged formats", "dQw4w9WgXcQ", "137+140", true},
+		{"format filter", "dQw4w9WgXcQ", "ba[ext=m4a]", true},
+		{"option in format", "dQw4w9WgXcQ", "140 --exec", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewMetadata(tt.videoID, tt.formatID, false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewMetadata() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidMetadata) {
+				t.Errorf("NewMetadata() err = %v, want ErrInvalidMetadata", err)
+			}
+		})
+	}
+}
+
+func TestMetadataFromURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		want    Metadata
+		wantErr bool
+	}{
+		{url: "https://vpod.local/audio/dQw4w9WgXcQ/140", want: Metadata{VideoId: "dQw4w9WgXcQ", FormatId: "140"}},
+		{url: "https://vpod.local/pod/video/dQw4w9WgXcQ/18", want: Metadata{VideoId: "dQw4w9WgXcQ", FormatId: "18", Video: true}},
+		{url: "https://vpod.local/feed/UCabc", wantErr: true},
+		{url: "https://vpod.local/audio/dQw4w9WgXcQ/best+140", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			got, err := MetadataFromURL(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MetadataFromURL() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("MetadataFromURL() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeYtDlp puts a yt-dlp on PATH that writes its output like the real one
+// does, through a .part file. It takes sleep seconds and exits with
+// exitCode.
+func fakeYtDlp(t *testing.T, exitCode, sleep int) {
+	dir := t.TempDir()
+	script := fmt.Sprintf(`#!/bin/sh
+for a; do case "$a" in --output=*) out="${a#--output=}";; esac; done
+printf 'audio' > "$out.part"
+sleep %d
+[ %d -ne 0 ] && exit %[2]d
+mv "$out.part" "$out"
+`, sleep, exitCode)
+	if err := os.WriteFile(filepath.Join(dir, "yt-dlp"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func newTestDownloader(t *testing.T) *Downloader {
+	d, err := New(slog.New(slog.DiscardHandler), initDb(t), Config{
+		Dir:                    t.TempDir(),
+		MaxConcurrentDownloads: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(d.Close)
+	return d
+}
+
+func TestRemovePartial(t *testing.T) {
+	dir := t.TempDir()
+	partial := []string{".vpod-abc.140.partial.m4a", ".vpod-abc.140.partial.m4a.part", ".vpod-def.251.orig.webm", ".vpod-ghi.140.tmp.m4a"}
+	// Whatever else is in the directory isn't vpod's to remove
+	kept := []string{"done.140.m4a", "notes.tmp", "song.orig.mp3", "abc.partial.m4a"}
+	for _, name := range append(partial, kept...) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d, err := New(slog.New(slog.DiscardHandler), nil, Config{Dir: dir, MaxConcurrentDownloads: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RemovePartial(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range partial {
+		if _, err := os.Stat(filepath.Join(dir, name)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s wasn't removed", name)
+		}
+	}
+	for _, name := range kept {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s was removed: %v", name, err)
+		}
+	}
+}
+
+func TestDownloadIsMovedIntoPlace(t *testing.T) {
+	fakeYtDlp(t, 0, 0)
+	d := newTestDownloader(t)
+	m := Metadata{VideoId: "dQw4w9WgXcQ", FormatId: "140"}
+
+	path, err := d.Get(context.Background(), m, d.logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(path); err != nil || string(b) != "audio" {
+		t.Errorf("downloaded file = %q, %v", b, err)
+	}
+	entries, _ := os.ReadDir(d.dir)
+	if len(entries) != 1 {
+		t.Errorf("download left %d files behind, want only the audio", len(entries)-1)
+	}
+}
+
+func TestFailedDownloadLeavesNothing(t *testing.T) {
+	fakeYtDlp(t, 1, 0)
+	d := newTestDownloader(t)
+	m := Metadata{VideoId: "dQw4w9WgXcQ", FormatId: "140"}
+
+	if _, err := d.Get(context.Background(), m, d.logger); err == nil {
+		t.Fatal("failed download returned no error")
+	}
+	entries, _ := os.ReadDir(d.dir)
+	for _, e := range entries {
+		t.Errorf("failed download left %s behind", e.Name())
+	}
+}
+
+func TestCloseStopsDownloads(t *testing.T) {
+	fakeYtDlp(t, 0, 60)
+	d := newTestDownloader(t)
+	m := Metadata{VideoId: "dQw4w9WgXcQ", FormatId: "140"}
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := d.Get(context.Background(), m, d.logger)
+		errs <- err
+	}()
+	// Wait for yt-dlp to have started writing
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		entries, _ := os.ReadDir(d.dir)
+		if len(entries) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("download never started")
+		}
+	}
+
+	d.Close()
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Error("stopped download returned no error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close didn't stop the download")
+	}
+	entries, _ := os.ReadDir(d.dir)
+	for _, e := range entries {
+		t.Errorf("stopped download left %s behind", e.Name())
+	}
+
+	if _, err := d.Get(context.Background(), m, d.logger); !errors.Is(err, ErrClosed) {
+		t.Errorf("download after Close = %v, want ErrClosed", err)
+	}
+}
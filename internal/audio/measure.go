@@ -0,0 +1,131 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"vpod/internal/data"
+	"vpod/internal/podcast"
+	"vpod/internal/proc"
+)
+
+// probeDuration asks ffprobe how many seconds long the file at path is.
+func probeDuration(ctx context.Context, path string) (int64, error) {
+	cmd := proc.Command(
+		ctx,
+		"ffprobe",
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		path,
+	)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return 0, err
+	}
+	secs, err := strconv.ParseFloat(strings.TrimSpace(out.String()), 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(math.Round(secs)), nil
+}
+
+// recordMeasurements writes the real size and duration of a downloaded file
+// back into its episodes, which SponsorBlock, thumbnails and post-processing
+// all change, and rebuilds the feeds they differ from. They are also
+// remembered so later refreshes don't put yt-dlp's guesses back.
+func (d *Downloader) recordMeasurements(ctx context.Context, m Metadata, path string, size int64, logger *slog.Logger) error {
+	eps, err := d.queries.GetEpisodesForVideo(ctx, m.VideoId)
+	if err != nil {
+		return err
+	}
+	if len(eps) == 0 {
+		// Not in any feed yet, the next refresh will pick it up
+		return nil
+	}
+
+	var duration sql.NullInt64
+	if secs, err := probeDuration(ctx, path); err != nil {
+		// The length is still worth having
+		logger.Warn("could not probe duration", slog.String("err", err.Error()))
+	} else {
+		duration = sql.NullInt64{Int64: secs, Valid: true}
+	}
+
+	for _, ep := range eps {
+		if err := d.recordEpisodeMeasurements(ctx, m, ep, size, duration, logger); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Downloader) recordEpisodeMeasurements(
+	ctx context.Context,
+	m Metadata,
+	ep data.GetEpisodesForVideoRow,
+	size int64,
+	duration sql.NullInt64,
+	logger *slog.Logger,
+) error {
+	url, length := ep.AudioUrl, ep.AudioLengthBytes
+	if m.Video {
+		if !ep.VideoEnclosureUrl.Valid {
+			return nil
+		}
+		url, length = ep.VideoEnclosureUrl.String, ep.VideoEnclosureLengthBytes.Int64
+	}
+	// Another feed may have the video in a different format
+	if !strings.HasSuffix(url, "/"+m.VideoId+"/"+m.FormatId) {
+		return nil
+	}
+
+	err := d.queries.UpsertMeasuredEnclosure(ctx, data.UpsertMeasuredEnclosureParams{
+		Url:             url,
+		LengthBytes:     size,
+		DurationSeconds: duration,
+	})
+	if err != nil {
+		return err
+	}
+	if length == size && (!duration.Valid || duration == ep.Duration) {
+		return nil
+	}
+
+	if m.Video {
+		err = d.queries.SetEpisodeVideoMeasurements(ctx, data.SetEpisodeVideoMeasurementsParams{
+			LengthBytes: sql.NullInt64{Int64: size, Valid: true},
+			Duration:    duration,
+			Url:         ep.VideoEnclosureUrl,
+		})
+	} else {
+		err = d.queries.SetEpisodeAudioMeasurements(ctx, data.SetEpisodeAudioMeasurementsParams{
+			LengthBytes: size,
+			Duration:    duration,
+			Url:         url,
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	opts, err := podcast.FeedOptions(ctx, d.queries, ep.FeedID)
+	if err != nil {
+		return err
+	}
+	p, err := podcast.Rebuild(ctx, d.queries, ep.FeedID, opts...)
+	if err != nil {
+		return err
+	}
+	logger.Info("rebuilding feed with measured enclosure",
+		slog.String("feed_id", ep.FeedID),
+		slog.Int64("length_bytes", size),
+		slog.Int64("duration_seconds", duration.Int64),
+	)
+	return podcast.UpsertPodcast(d.queries, *p, ctx)
+}
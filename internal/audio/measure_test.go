@@ -0,0 +1,92 @@
+package audio
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"vpod/internal/podcast"
+	"vpod/internal/youtube"
+)
+
+func TestRecordMeasurements(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	queries := initDb(t)
+
+	c := youtube.Channel{
+		Id:    "UCtest",
+		Title: "Test",
+		URL:   url.URL{Scheme: "https", Host: "youtube.com", Path: "/channel/UCtest"},
+		Videos: []youtube.Video{{
+			Id:               "abc",
+			Title:            "Episode",
+			Url:              "https://youtube.com/watch?v=abc",
+			Duration:         300,
+			ReleaseTimestamp: youtube.UnixTime{Time: time.Date(2023, 5, 15, 12, 0, 0, 0, time.UTC)},
+			Formats: []youtube.VideoFormat{{
+				Id:             "140",
+				Resolution:     "audio only",
+				AudioExt:       "m4a",
+				Language:       "en",
+				FilesizeApprox: 5000,
+			}},
+		}},
+	}
+	p, err := podcast.FromChannel(c, url.URL{Scheme: "https", Host: "vpod.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := podcast.UpsertPodcast(queries, *p, ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	feedXML, err := queries.GetFeedXML(ctx, []byte("UCtest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(feedXML, `length="5000"`) {
+		t.Fatalf("expected the approximate size before downloading:\n%s", feedXML)
+	}
+
+	d := &Downloader{queries: queries, logger: logger}
+	m := Metadata{VideoId: "abc", FormatId: "140"}
+	// There's no real audio here, so only the size can be measured
+	if err := d.recordMeasurements(ctx, m, "/nonexistent.m4a", 4321, logger); err != nil {
+		t.Fatal(err)
+	}
+
+	eps, err := queries.GetEpisodesForFeed(ctx, "UCtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(eps) != 1 || eps[0].AudioLengthBytes != 4321 || eps[0].Duration.Int64 != 300 {
+		t.Errorf("episode was not updated with the measured size: %+v", eps)
+	}
+	feedXML, err = queries.GetFeedXML(ctx, []byte("UCtest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(feedXML, `length="4321"`) {
+		t.Errorf("feed was not rebuilt with the measured size:\n%s", feedXML)
+	}
+
+	// A refresh reports yt-dlp's guess again, which shouldn't win
+	p, err = podcast.FromChannel(c, url.URL{Scheme: "https", Host: "vpod.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := podcast.UpsertPodcast(queries, *p, ctx); err != nil {
+		t.Fatal(err)
+	}
+	feedXML, err = queries.GetFeedXML(ctx, []byte("UCtest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(feedXML, `length="4321"`) {
+		t.Errorf("refreshing the feed lost the measured size:\n%s", feedXML)
+	}
+}
@@ -0,0 +1,168 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"vpod/internal/data"
+	"vpod/internal/proc"
+	"vpod/internal/tracing"
+)
+
+const (
+	// loudnormTarget is the integrated loudness most podcast apps expect.
+	loudnormTarget = "I=-16:TP=-1.5:LRA=11"
+	// silenceThreshold is how quiet audio has to be to count as silence.
+	silenceThreshold = "-50dB"
+
+	MinSpeed = 0.5
+	MaxSpeed = 4
+)
+
+// PostProcess is what ffmpeg does to audio after yt-dlp downloads it. The
+// zero value, or a Speed of 1, leaves the audio as downloaded.
+type PostProcess struct {
+	// Loudnorm normalizes loudness following EBU R128.
+	Loudnorm bool
+	// Mono downmixes to a single channel.
+	Mono bool
+	// TrimSilence cuts silence from the start and end.
+	TrimSilence bool
+	// BitrateKbps re-encodes at a lower bitrate, 0 keeps the original.
+	BitrateKbps int64
+	// Speed bakes a playback speed into the audio, 1 keeps it as is.
+	Speed float64
+}
+
+// Validate checks the options are ones ffmpeg can apply.
+func (pp PostProcess) Validate() error {
+	if pp.BitrateKbps < 0 {
+		return fmt.Errorf("invalid bitrate %d: must not be negative", pp.BitrateKbps)
+	}
+	if pp.Speed != 0 && (pp.Speed < MinSpeed || pp.Speed > MaxSpeed) {
+		return fmt.Errorf("invalid speed %v: must be in range [%v-%v]", pp.Speed, MinSpeed, MaxSpeed)
+	}
+	return nil
+}
+
+// Params returns the options in the form SetFeedPostProcess takes.
+func (pp PostProcess) Params(feedID string) data.SetFeedPostProcessParams {
+	speed := pp.Speed
+	if speed == 0 {
+		speed = 1
+	}
+	return data.SetFeedPostProcessParams{
+		FeedID:      feedID,
+		Loudnorm:    pp.Loudnorm,
+		Mono:        pp.Mono,
+		TrimSilence: pp.TrimSilence,
+		BitrateKbps: pp.BitrateKbps,
+		Speed:       speed,
+	}
+}
+
+func (pp PostProcess) changesSpeed() bool {
+	return pp.Speed != 0 && pp.Speed != 1
+}
+
+// enabled reports whether there is anything for ffmpeg to do.
+func (pp PostProcess) enabled() bool {
+	return pp.Loudnorm || pp.Mono || pp.TrimSilence || pp.BitrateKbps > 0 || pp.changesSpeed()
+}
+
+// key identifies the options in cached filenames, or is empty when audio
+// isn't post-processed.
+func (pp PostProcess) key() string {
+	if !pp.enabled() {
+		return ""
+	}
+	speed := 1.0
+	if pp.changesSpeed() {
+		speed = pp.Speed
+	}
+	sum := sha256.Sum256(fmt.Appendf(nil,
+		"loudnorm=%t;mono=%t;trim=%t;bitrate=%d;speed=%s",
+		pp.Loudnorm, pp.Mono, pp.TrimSilence, pp.BitrateKbps,
+		strconv.FormatFloat(speed, 'f', -1, 64),
+	))
+	return hex.EncodeToString(sum[:4])
+}
+
+// filters returns the ffmpeg audio filter chain. Silence is trimmed first
+// so it doesn't skew the loudness measurement, and loudnorm's 192kHz output
+// is resampled back down to something AAC can encode.
+func (pp PostProcess) filters() string {
+	var filters []string
+	if pp.TrimSilence {
+		trim := "silenceremove=start_periods=1:start_threshold=" + silenceThreshold
+		// silenceremove only trims the start, so trim the end by reversing
+		filters = append(filters, trim, "areverse", trim, "areverse")
+	}
+	if pp.changesSpeed() {
+		filters = append(filters, "atempo="+strconv.FormatFloat(pp.Speed, 'f', -1, 64))
+	}
+	if pp.Loudnorm {
+		filters = append(filters, "loudnorm="+loudnormTarget, "aresample=48000")
+	}
+	return strings.Join(filters, ",")
+}
+
+// args returns the ffmpeg arguments to process in into out. The thumbnail
+// and metadata yt-dlp embedded are carried over.
+func (pp PostProcess) args(in, out string) []string {
+	args := []string{
+		"-hide_banner",
+		"-loglevel", "error",
+		"-y",
+		"-i", in,
+		"-map", "0:a:0",
+		"-map", "0:v?",
+		"-map_metadata", "0",
+		"-c:v", "copy",
+	}
+	if f := pp.filters(); f != "" {
+		args = append(args, "-af", f)
+	}
+	if pp.Mono {
+		args = append(args, "-ac", "1")
+	}
+	args = append(args, "-c:a", "aac")
+	if pp.BitrateKbps > 0 {
+		args = append(args, "-b:a", fmt.Sprintf("%dk", pp.BitrateKbps))
+	}
+	return append(args, "-movflags", "+faststart", "-f", "mp4", out)
+}
+
+// postProcess runs ffmpeg on the downloaded file at in, and moves the result
+// to out. in is removed either way.
+func (d *Downloader) postProcess(ctx context.Context, pp PostProcess, in, out string, logger *slog.Logger) (err error) {
+	defer os.Remove(in)
+	_, span := tracing.Start(ctx, "ffmpeg post-process")
+	defer func() { tracing.End(span, err) }()
+
+	tmp := partialName(out, "tmp")
+	cmd := proc.Command(ctx, "ffmpeg", pp.args(in, tmp)...)
+	var errb bytes.Buffer
+	cmd.Stderr = &errb
+	logger = logger.With(slog.String("ffmpeg_command", fmt.Sprintf("%v", cmd.Args)))
+
+	logger.Info("post-processing audio")
+	if err := cmd.Run(); err != nil {
+		os.Remove(tmp)
+		var exitError *exec.ExitError
+		if errors.As(err, &exitError) {
+			logger = logger.With(slog.String("stderr", errb.String()))
+		}
+		logger.Error("failed to post-process audio", slog.String("err", err.Error()))
+		return err
+	}
+	return os.Rename(tmp, out)
+}
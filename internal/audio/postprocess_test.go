@@ -0,0 +1,59 @@
+package audio
+
+import "testing"
+
+func TestPostProcessFilters(t *testing.T) {
+	tests := []struct {
+		name string
+		pp   PostProcess
+		want string
+	}{
+		{
+			name: "nothing",
+			pp:   PostProcess{Speed: 1},
+			want: "",
+		},
+		{
+			name: "mono and bitrate need no filters",
+			pp:   PostProcess{Mono: true, BitrateKbps: 64},
+			want: "",
+		},
+		{
+			name: "loudnorm",
+			pp:   PostProcess{Loudnorm: true},
+			want: "loudnorm=I=-16:TP=-1.5:LRA=11,aresample=48000",
+		},
+		{
+			name: "everything, trimming before measuring loudness",
+			pp:   PostProcess{Loudnorm: true, TrimSilence: true, Speed: 1.25},
+			want: "silenceremove=start_periods=1:start_threshold=-50dB,areverse," +
+				"silenceremove=start_periods=1:start_threshold=-50dB,areverse," +
+				"atempo=1.25,loudnorm=I=-16:TP=-1.5:LRA=11,aresample=48000",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.pp.filters(); got != tt.want {
+				t.Errorf("filters() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPostProcessValidate(t *testing.T) {
+	tests := []struct {
+		pp      PostProcess
+		wantErr bool
+	}{
+		{PostProcess{}, false},
+		{PostProcess{Speed: 2, BitrateKbps: 64}, false},
+		{PostProcess{Speed: 0.25}, true},
+		{PostProcess{Speed: 5}, true},
+		{PostProcess{BitrateKbps: -1}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.pp.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%+v.Validate() error = %v, wantErr %v", tt.pp, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package audio
+
+import (
+	"context"
+	"log/slog"
+)
+
+// prefetchQueueSize is how many episodes may wait to be prefetched.
+const prefetchQueueSize = 256
+
+// Prefetch queues m to be downloaded in the background, unless it's already
+// waiting. It never blocks, and reports false if the queue is full.
+func (d *Downloader) Prefetch(m Metadata) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.queued[m] {
+		return true
+	}
+	select {
+	case d.prefetch <- m:
+		d.queued[m] = true
+		return true
+	default:
+		d.logger.Warn("prefetch queue is full", slog.String("video_id", m.VideoId))
+		return false
+	}
+}
+
+// Run downloads prefetched episodes one at a time until ctx is done. Doing
+// them one at a time leaves download slots free for listeners.
+func (d *Downloader) Run(ctx context.Context) {
+	for {
+		select {
+		case m := <-d.prefetch:
+			d.prefetchOne(ctx, m)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *Downloader) prefetchOne(ctx context.Context, m Metadata) {
+	d.mu.Lock()
+	delete(d.queued, m)
+	d.mu.Unlock()
+
+	logger := d.logger.With(
+		slog.String("video_id", m.VideoId),
+		slog.String("format_id", m.FormatId),
+	)
+	s, err := d.settingsFor(ctx, m)
+	if err != nil {
+		logger.Error("could not get audio settings", slog.String("err", err.Error()))
+		return
+	}
+	if d.cached(m, s) {
+		logger.Debug("audio already cached, not prefetching")
+		return
+	}
+
+	size, err := d.queries.GetAudioCacheSize(ctx)
+	if err != nil {
+		logger.Error("could not get audio cache size", slog.String("err", err.Error()))
+		return
+	}
+	if d.maxCacheBytes > 0 && size >= d.maxCacheBytes {
+		// Prefetching now would only evict episodes people actually played
+		logger.Info("audio cache is full, not prefetching", slog.Int64("cache_size_bytes", size))
+		return
+	}
+
+	logger.Info("prefetching audio")
+	if err := d.download(ctx, m, s, logger); err != nil {
+		logger.Error("could not prefetch audio", slog.String("err", err.Error()))
+	}
+}
@@ -0,0 +1,62 @@
+package audio
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"vpod/internal/data"
+)
+
+func TestPrefetchQueuesEpisodesOnce(t *testing.T) {
+	d := newTestDownloader(t)
+	m := Metadata{VideoId: "dQw4w9WgXcQ", FormatId: "140"}
+
+	d.Prefetch(m)
+	d.Prefetch(m)
+	if len(d.prefetch) != 1 {
+		t.Fatalf("queued %d prefetches of the same episode, want 1", len(d.prefetch))
+	}
+
+	// Once it's taken off the queue it can be queued again
+	if err := os.WriteFile(d.filename(m, DefaultSettings), []byte("audio"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	d.prefetchOne(context.Background(), <-d.prefetch)
+	d.Prefetch(m)
+	if len(d.prefetch) != 1 {
+		t.Errorf("prefetched episode wasn't queued again")
+	}
+}
+
+func TestPrefetchSkipsWhenCacheIsFull(t *testing.T) {
+	fakeYtDlp(t, 0, 0)
+	d := newTestDownloader(t)
+	ctx := context.Background()
+	err := d.queries.UpsertAudioFile(ctx, data.UpsertAudioFileParams{
+		Path:           "/audio/played.m4a",
+		VideoID:        "aaaaaaaaaaa",
+		FormatID:       "140",
+		SizeBytes:      1000,
+		LastAccessedAt: sql.NullTime{Time: time.Now(), Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := Metadata{VideoId: "dQw4w9WgXcQ", FormatId: "140"}
+	path := d.filename(m, DefaultSettings)
+
+	d.maxCacheBytes = 1000
+	d.prefetchOne(ctx, m)
+	if _, err := os.Stat(path); err == nil {
+		t.Fatal("prefetched into a full cache")
+	}
+
+	d.maxCacheBytes = 2000
+	d.prefetchOne(ctx, m)
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("didn't prefetch with room in the cache: %v", err)
+	}
+}
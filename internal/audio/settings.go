@@ -0,0 +1,67 @@
+package audio
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// Settings are a feed's choices about how its audio is produced.
+type Settings struct {
+	SponsorBlock SponsorBlock
+	PostProcess  PostProcess
+}
+
+// DefaultSettings are used for episodes of feeds without their own settings.
+var DefaultSettings = Settings{SponsorBlock: DefaultSponsorBlock}
+
+// suffix is added to cached filenames, so audio produced one way is never
+// served to a feed that wants it produced another. The default settings
+// have no suffix.
+func (s Settings) suffix() string {
+	var suffix string
+	if key := s.SponsorBlock.key(); key != "" {
+		suffix += ".sb-" + key
+	}
+	if key := s.PostProcess.key(); key != "" {
+		suffix += ".pp-" + key
+	}
+	return suffix
+}
+
+// settingsFor looks up the settings of the feed m is served for. A video in
+// several feeds is produced once for each feed's settings, never with
+// another feed's. Post-processing only applies to audio.
+func (d *Downloader) settingsFor(ctx context.Context, m Metadata) (Settings, error) {
+	if m.FeedId == "" {
+		return DefaultSettings, nil
+	}
+	row, err := d.queries.GetAudioSettingsForFeed(ctx, m.FeedId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return DefaultSettings, nil
+	} else if err != nil {
+		return Settings{}, err
+	}
+
+	s := Settings{
+		SponsorBlock: DefaultSponsorBlock,
+		PostProcess: PostProcess{
+			Loudnorm:    row.Loudnorm,
+			Mono:        row.Mono,
+			TrimSilence: row.TrimSilence,
+			BitrateKbps: row.BitrateKbps,
+			Speed:       row.Speed,
+		},
+	}
+	if m.Video {
+		s.PostProcess = PostProcess{}
+	}
+	// Feeds with settings for other things, but not SponsorBlock
+	if row.SponsorblockRemove.Valid || row.SponsorblockMark.Valid {
+		s.SponsorBlock = SponsorBlock{
+			Remove: splitCategories(row.SponsorblockRemove.String),
+			Mark:   splitCategories(row.SponsorblockMark.String),
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,136 @@
+package audio
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"os"
+	"slices"
+	"testing"
+	"vpod/internal/data"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func initDb(t *testing.T) *data.Queries {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	if err := data.Migrate(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	return data.New(db)
+}
+
+func TestFilenames(t *testing.T) {
+	d := &Downloader{dir: "/audio"}
+	m := Metadata{VideoId: "abc", FormatId: "140"}
+
+	if got := d.filename(m, DefaultSettings); got != "/audio/abc.140.m4a" {
+		t.Errorf("default settings should have no suffix, got %s", got)
+	}
+	// Formats never share a file, whichever is asked for first
+	if got := d.filename(Metadata{VideoId: "abc", FormatId: "137"}, DefaultSettings); got != "/audio/abc.137.m4a" {
+		t.Errorf("another format should get its own file, got %s", got)
+	}
+	if got := d.filename(m, Settings{
+		SponsorBlock: DefaultSponsorBlock,
+		PostProcess:  PostProcess{Speed: 1},
+	}); got != "/audio/abc.140.m4a" {
+		t.Errorf("a speed of 1 should not post-process, got %s", got)
+	}
+
+	names := map[string]bool{}
+	for _, s := range []Settings{
+		DefaultSettings,
+		{},
+		{SponsorBlock: SponsorBlock{Remove: []string{"sponsor", "intro"}}},
+		{SponsorBlock: SponsorBlock{Mark: []string{"sponsor"}}},
+		{SponsorBlock: SponsorBlock{Remove: []string{"sponsor"}, Mark: []string{"intro"}}},
+		{SponsorBlock: DefaultSponsorBlock, PostProcess: PostProcess{Loudnorm: true}},
+		{SponsorBlock: DefaultSponsorBlock, PostProcess: PostProcess{Mono: true}},
+		{SponsorBlock: DefaultSponsorBlock, PostProcess: PostProcess{Speed: 1.5}},
+		{PostProcess: PostProcess{Loudnorm: true, BitrateKbps: 64}},
+	} {
+		name := d.filename(m, s)
+		if names[name] {
+			t.Errorf("settings %+v share the filename %s with other settings", s, name)
+		}
+		names[name] = true
+	}
+}
+
+func TestSettingsFor(t *testing.T) {
+	ctx := context.Background()
+	queries := initDb(t)
+	d, err := New(slog.New(slog.NewTextHandler(os.Stdout, nil)), queries, Config{
+		Dir:                    t.TempDir(),
+		MaxConcurrentDownloads: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every feed has the same video, each gets it with its own settings
+	for _, feedID := range []string{"UCarchive", "UCdefault", "UCmobile"} {
+		err := queries.UpsertEpisode(ctx, data.UpsertEpisodeParams{
+			ID:       []byte("https://vpod.example.com/audio/dQw4w9WgXcQ/140"),
+			AudioUrl: "https://vpod.example.com/audio/dQw4w9WgXcQ/140",
+			FeedID:   feedID,
+			Title:    "Shared",
+			VideoID:  "dQw4w9WgXcQ",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := queries.SetFeedSponsorBlock(ctx, SponsorBlock{}.Params("UCarchive")); err != nil {
+		t.Fatal(err)
+	}
+	// Settings for something else shouldn't turn SponsorBlock off
+	err = queries.SetFeedPrefetchCount(ctx, data.SetFeedPrefetchCountParams{
+		FeedID:        "UCdefault",
+		PrefetchCount: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mobile := PostProcess{Mono: true, BitrateKbps: 48, Speed: 1.5}
+	if err := queries.SetFeedPostProcess(ctx, mobile.Params("UCmobile")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		feedID string
+		want   Settings
+	}{
+		{"UCarchive", Settings{PostProcess: PostProcess{Speed: 1}}},
+		{"UCdefault", Settings{SponsorBlock: DefaultSponsorBlock, PostProcess: PostProcess{Speed: 1}}},
+		{"UCmobile", Settings{SponsorBlock: DefaultSponsorBlock, PostProcess: mobile}},
+		{"UCunknown", DefaultSettings},
+		{"", DefaultSettings},
+	}
+	filenames := map[string]string{}
+	for _, tt := range tests {
+		t.Run(tt.feedID, func(t *testing.T) {
+			m := Metadata{VideoId: "dQw4w9WgXcQ", FormatId: "140", FeedId: tt.feedID}
+			got, err := d.settingsFor(ctx, m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got.SponsorBlock.Remove, tt.want.SponsorBlock.Remove) ||
+				!slices.Equal(got.SponsorBlock.Mark, tt.want.SponsorBlock.Mark) ||
+				got.PostProcess != tt.want.PostProcess {
+				t.Errorf("settingsFor(%s) = %+v, want %+v", tt.feedID, got, tt.want)
+			}
+			filenames[tt.feedID] = d.filename(m, got)
+		})
+	}
+	if filenames["UCarchive"] == filenames["UCmobile"] || filenames["UCmobile"] == filenames["UCdefault"] {
+		t.Errorf("feeds sharing a video with different settings share a file: %v", filenames)
+	}
+}
@@ -0,0 +1,134 @@
+package audio
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+	"vpod/internal/data"
+)
+
+// SponsorBlockCategories are the segment categories yt-dlp can act on.
+var SponsorBlockCategories = []string{
+	"sponsor",
+	"intro",
+	"outro",
+	"selfpromo",
+	"preview",
+	"filler",
+	"interaction",
+	"music_offtopic",
+}
+
+// SponsorBlockAction is what happens to a category of segments.
+type SponsorBlockAction string
+
+const (
+	SponsorBlockRemove SponsorBlockAction = "remove"
+	SponsorBlockMark   SponsorBlockAction = "mark"
+	SponsorBlockIgnore SponsorBlockAction = "ignore"
+)
+
+// ParseSponsorBlockAction parses an action, treating "" as ignore.
+func ParseSponsorBlockAction(s string) (SponsorBlockAction, error) {
+	switch a := SponsorBlockAction(s); a {
+	case SponsorBlockRemove, SponsorBlockMark, SponsorBlockIgnore:
+		return a, nil
+	case "":
+		return SponsorBlockIgnore, nil
+	}
+	return "", fmt.Errorf("invalid sponsorblock action %q: must be one of remove, mark or ignore", s)
+}
+
+// SponsorBlock is the set of categories cut out of a feed's audio, and the
+// set marked as chapters instead.
+type SponsorBlock struct {
+	Remove []string
+	Mark   []string
+}
+
+// DefaultSponsorBlock is used for feeds without their own settings. It
+// matches what vpod always did before settings were per feed.
+var DefaultSponsorBlock = SponsorBlock{Remove: []string{"sponsor"}}
+
+// NewSponsorBlock builds settings from an action per category. Categories
+// that aren't given are ignored.
+func NewSponsorBlock(actions map[string]SponsorBlockAction) (SponsorBlock, error) {
+	var sb SponsorBlock
+	for _, c := range SponsorBlockCategories {
+		switch actions[c] {
+		case SponsorBlockRemove:
+			sb.Remove = append(sb.Remove, c)
+		case SponsorBlockMark:
+			sb.Mark = append(sb.Mark, c)
+		}
+	}
+	for c := range actions {
+		if !slices.Contains(SponsorBlockCategories, c) {
+			return SponsorBlock{}, fmt.Errorf("unknown sponsorblock category %q", c)
+		}
+	}
+	return sb, nil
+}
+
+// joinCategories stores categories as a comma separated list. An empty list
+// is stored as "" rather than NULL, so it isn't mistaken for the default.
+func joinCategories(categories []string) sql.NullString {
+	return sql.NullString{String: strings.Join(categories, ","), Valid: true}
+}
+
+func splitCategories(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// Params returns the settings in the form SetFeedSponsorBlock takes.
+func (sb SponsorBlock) Params(feedID string) data.SetFeedSponsorBlockParams {
+	return data.SetFeedSponsorBlockParams{
+		FeedID:             feedID,
+		SponsorblockRemove: joinCategories(sb.Remove),
+		SponsorblockMark:   joinCategories(sb.Mark),
+	}
+}
+
+// isDefault reports whether sb is the same as DefaultSponsorBlock.
+func (sb SponsorBlock) isDefault() bool {
+	return slices.Equal(sb.Remove, DefaultSponsorBlock.Remove) &&
+		slices.Equal(sb.Mark, DefaultSponsorBlock.Mark)
+}
+
+// key identifies the settings in cached filenames, so audio cut one way is
+// never served to a feed that wants it cut another. The default settings
+// have an empty key, which keeps files cached before settings existed.
+func (sb SponsorBlock) key() string {
+	if sb.isDefault() {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(
+		"remove=" + strings.Join(sb.Remove, ",") + ";mark=" + strings.Join(sb.Mark, ","),
+	))
+	return hex.EncodeToString(sum[:4])
+}
+
+// args returns the yt-dlp arguments for sb. apiURL may be empty to use
+// yt-dlp's default SponsorBlock server.
+func (sb SponsorBlock) args(apiURL string) []string {
+	if len(sb.Remove) == 0 && len(sb.Mark) == 0 {
+		return []string{"--no-sponsorblock"}
+	}
+	var args []string
+	if len(sb.Remove) > 0 {
+		args = append(args, "--sponsorblock-remove="+strings.Join(sb.Remove, ","))
+	}
+	if len(sb.Mark) > 0 {
+		args = append(args, "--sponsorblock-mark="+strings.Join(sb.Mark, ","))
+	}
+	if apiURL != "" {
+		args = append(args, "--sponsorblock-api="+apiURL)
+	}
+	return args
+}
@@ -0,0 +1,44 @@
+package audio
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSponsorBlockArgs(t *testing.T) {
+	tests := []struct {
+		name   string
+		sb     SponsorBlock
+		apiURL string
+		want   []string
+	}{
+		{
+			name: "default",
+			sb:   DefaultSponsorBlock,
+			want: []string{"--sponsorblock-remove=sponsor"},
+		},
+		{
+			name:   "remove and mark with a mirror",
+			sb:     SponsorBlock{Remove: []string{"sponsor", "intro"}, Mark: []string{"outro"}},
+			apiURL: "http://sponsorblock.local",
+			want: []string{
+				"--sponsorblock-remove=sponsor,intro",
+				"--sponsorblock-mark=outro",
+				"--sponsorblock-api=http://sponsorblock.local",
+			},
+		},
+		{
+			name:   "nothing cut",
+			sb:     SponsorBlock{},
+			apiURL: "http://sponsorblock.local",
+			want:   []string{"--no-sponsorblock"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.sb.args(tt.apiURL); !slices.Equal(got, tt.want) {
+				t.Errorf("args() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
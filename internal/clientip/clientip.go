@@ -0,0 +1,168 @@
+// Package clientip works out which address a request came from. Only the
+// one header the proxies are configured to set is read, and only when it
+// was added by a trusted proxy. The client is the nearest address in the
+// chain that isn't one.
+package clientip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ParseTrusted parses a list of CIDRs, or bare addresses, of proxies whose
+// forwarding headers can be believed.
+func ParseTrusted(list []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if strings.Contains(s, "/") {
+			p, err := netip.ParsePrefix(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", s, err)
+			}
+			prefixes = append(prefixes, p.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", s, err)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// ParseHeader checks that a header is one the client's address can be read
+// from, Forwarded, X-Forwarded-For or X-Real-Ip, and returns its canonical
+// name.
+func ParseHeader(name string) (string, error) {
+	name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+	switch name {
+	case "Forwarded", "X-Forwarded-For", "X-Real-Ip":
+		return name, nil
+	}
+	return "", fmt.Errorf("invalid client ip header %q, must be Forwarded, X-Forwarded-For or X-Real-Ip", name)
+}
+
+type Resolver struct {
+	trusted []netip.Prefix
+	header  string
+}
+
+// New returns a Resolver that reads the client's address from header, as
+// returned by ParseHeader, when it comes from a trusted proxy.
+func New(trusted []netip.Prefix, header string) *Resolver {
+	return &Resolver{trusted: trusted, header: header}
+}
+
+func (res *Resolver) isTrusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range res.trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteAddr is the address of whoever opened the connection.
+func remoteAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Resolve returns the address of the client that made a request. The
+// other forwarding headers are ignored, since a proxy that only sets one
+// passes the rest on from the client untouched.
+func (res *Resolver) Resolve(r *http.Request) string {
+	client := remoteAddr(r)
+	addr, err := netip.ParseAddr(client)
+	if err != nil || !res.isTrusted(addr) {
+		return client
+	}
+
+	var hops []string
+	switch res.header {
+	case "Forwarded":
+		hops = forwardedFor(r.Header.Values("Forwarded"))
+	case "X-Forwarded-For":
+		for _, v := range r.Header.Values("X-Forwarded-For") {
+			for _, hop := range strings.Split(v, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+	case "X-Real-Ip":
+		if v := r.Header.Get("X-Real-Ip"); v != "" {
+			hops = []string{strings.TrimSpace(v)}
+		}
+	}
+
+	// Walk back from the proxy nearest to us. Each trusted proxy vouches
+	// for the hop before it, the first one that isn't trusted is the client.
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := parseHop(hops[i])
+		if err != nil {
+			// Can't be checked, so stop at the last one that could
+			break
+		}
+		client = addr.Unmap().String()
+		if !res.isTrusted(addr) {
+			break
+		}
+	}
+	return client
+}
+
+// forwardedFor returns the for= parameters of RFC 7239 Forwarded headers,
+// in order.
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, v := range values {
+		for _, element := range strings.Split(v, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				k, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(k, "for") {
+					hops = append(hops, strings.Trim(val, `"`))
+				}
+			}
+		}
+	}
+	return hops
+}
+
+// parseHop parses an address from a forwarding header, which may have a
+// port, and brackets around IPv6. Obfuscated identifiers and "unknown"
+// don't parse.
+func parseHop(hop string) (netip.Addr, error) {
+	if addr, err := netip.ParseAddr(hop); err == nil {
+		return addr, nil
+	}
+	if ap, err := netip.ParseAddrPort(hop); err == nil {
+		return ap.Addr(), nil
+	}
+	return netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]"))
+}
+
+// WithClientIP returns a copy of ctx carrying the client's address.
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, "client_ip", ip)
+}
+
+// FromRequest returns the client address resolved for a request, or the
+// address of the connection when it wasn't.
+func FromRequest(r *http.Request) string {
+	if ip, ok := r.Context().Value("client_ip").(string); ok {
+		return ip
+	}
+	return remoteAddr(r)
+}
@@ -0,0 +1,87 @@
+package clientip
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	trusted, err := ParseTrusted([]string{"10.0.0.0/8", "2001:db8::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		header  string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{"direct", "X-Forwarded-For", "203.0.113.7:1234", nil, "203.0.113.7"},
+		{"untrusted caller can't spoof", "X-Forwarded-For", "203.0.113.7:1234", map[string]string{"X-Forwarded-For": "1.2.3.4"}, "203.0.113.7"},
+		{"trusted proxy", "X-Forwarded-For", "10.0.0.2:1234", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+		// The client can put anything first, only what our proxies added counts
+		{"spoofed first hop", "X-Forwarded-For", "10.0.0.2:1234", map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.1, 10.0.0.3"}, "198.51.100.1"},
+		{"only proxies", "X-Forwarded-For", "10.0.0.2:1234", map[string]string{"X-Forwarded-For": "10.0.0.4, 10.0.0.3"}, "10.0.0.4"},
+		{"real ip", "X-Real-Ip", "10.0.0.2:1234", map[string]string{"X-Real-Ip": "198.51.100.1"}, "198.51.100.1"},
+		{"forwarded", "Forwarded", "10.0.0.2:1234", map[string]string{"Forwarded": `for=1.2.3.4, for=198.51.100.1;proto=https, for="10.0.0.3:8080"`}, "198.51.100.1"},
+		{"forwarded ipv6", "Forwarded", "[2001:db8::1]:1234", map[string]string{"Forwarded": `For="[2001:db8:cafe::17]:4711"`}, "2001:db8:cafe::17"},
+		{"forwarded obfuscated", "Forwarded", "10.0.0.2:1234", map[string]string{"Forwarded": "for=_hidden, for=10.0.0.3"}, "10.0.0.3"},
+		{"forwarded unknown", "Forwarded", "10.0.0.2:1234", map[string]string{"Forwarded": "for=unknown"}, "10.0.0.2"},
+		{"mapped ipv4", "X-Forwarded-For", "[::ffff:10.0.0.2]:1234", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+		// A proxy that sets one header passes the others on from the client
+		{"other headers ignored", "X-Forwarded-For", "10.0.0.2:1234", map[string]string{"Forwarded": "for=1.2.3.4", "X-Real-Ip": "1.2.3.4"}, "10.0.0.2"},
+		{"forwarded for ignored", "X-Real-Ip", "10.0.0.2:1234", map[string]string{"X-Forwarded-For": "1.2.3.4", "X-Real-Ip": "198.51.100.1"}, "198.51.100.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := New(trusted, tt.header)
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			if got := res.Resolve(r); got != tt.want {
+				t.Errorf("Resolve() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrusted(t *testing.T) {
+	if _, err := ParseTrusted([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("ParseTrusted() of an invalid CIDR succeeded")
+	}
+	if _, err := ParseTrusted([]string{"proxy.local"}); err == nil {
+		t.Error("ParseTrusted() of a hostname succeeded")
+	}
+	got, err := ParseTrusted([]string{"192.168.1.7/24", " ", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].String() != "192.168.1.0/24" || got[1].String() != "::1/128" {
+		t.Errorf("ParseTrusted() = %v", got)
+	}
+}
+
+func TestParseHeader(t *testing.T) {
+	if got, err := ParseHeader("x-real-ip"); err != nil || got != "X-Real-Ip" {
+		t.Errorf("ParseHeader(x-real-ip) = %q, %v, want X-Real-Ip", got, err)
+	}
+	if _, err := ParseHeader("X-Client-IP"); err == nil {
+		t.Error("ParseHeader() of an unsupported header succeeded")
+	}
+}
+
+func TestFromRequest(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "203.0.113.7:1234"
+	if got := FromRequest(r); got != "203.0.113.7" {
+		t.Errorf("FromRequest() without a resolved ip = %s, want the remote address", got)
+	}
+	r = r.WithContext(WithClientIP(r.Context(), "198.51.100.1"))
+	if got := FromRequest(r); got != "198.51.100.1" {
+		t.Errorf("FromRequest() = %s, want the resolved ip", got)
+	}
+}
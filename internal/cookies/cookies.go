@@ -0,0 +1,169 @@
+// Package cookies keeps the cookie jars yt-dlp uses to see videos that need
+// a login, encrypted at rest.
+package cookies
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"vpod/internal/data"
+)
+
+// GlobalJar is the feed id the server-wide jar is stored under. It's used
+// for feeds without a jar of their own.
+const GlobalJar = ""
+
+// KeySize is the size of the key jars are encrypted with, for AES-256.
+const KeySize = 32
+
+var ErrInvalidJar = errors.New("not a Netscape format cookie jar")
+
+// ParseKey decodes a base64 encoded encryption key.
+func ParseKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("cookie key is not valid base64: %w", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("cookie key must be %d bytes, got %d", KeySize, len(key))
+	}
+	return key, nil
+}
+
+type Store struct {
+	aead    cipher.AEAD
+	queries *data.Queries
+}
+
+func New(queries *data.Queries, key []byte) (*Store, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{aead: aead, queries: queries}, nil
+}
+
+// Validate checks jar is a cookies.txt file, as exported by browser
+// extensions and yt-dlp's --cookies-from-browser.
+func Validate(jar []byte) error {
+	cookies := 0
+	scanner := bufio.NewScanner(bytes.NewReader(jar))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		// HttpOnly cookies are written as comments
+		line = strings.TrimPrefix(line, "#HttpOnly_")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if len(strings.Split(line, "\t")) != 7 {
+			return ErrInvalidJar
+		}
+		cookies++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if cookies == 0 {
+		return ErrInvalidJar
+	}
+	return nil
+}
+
+// Put stores the jar for a feed, or the global jar for GlobalJar.
+func (s *Store) Put(ctx context.Context, feedID string, jar []byte) error {
+	if err := Validate(jar); err != nil {
+		return err
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	// The feed id is authenticated too, so a jar can't be moved to another
+	// feed in the DB
+	ciphertext := s.aead.Seal(nil, nonce, jar, []byte(feedID))
+	return s.queries.UpsertCookieJar(ctx, data.UpsertCookieJarParams{
+		FeedID:     feedID,
+		Nonce:      nonce,
+		Ciphertext: ciphertext,
+	})
+}
+
+func (s *Store) Delete(ctx context.Context, feedID string) error {
+	return s.queries.DeleteCookieJar(ctx, feedID)
+}
+
+// get returns the jar for a feed, falling back to the global jar, along with
+// the id it's stored under. The jar is nil if there is neither.
+func (s *Store) get(ctx context.Context, feedID string) ([]byte, string, error) {
+	row, err := s.queries.GetCookieJar(ctx, feedID)
+	if errors.Is(err, sql.ErrNoRows) && feedID != GlobalJar {
+		return s.get(ctx, GlobalJar)
+	} else if errors.Is(err, sql.ErrNoRows) {
+		return nil, GlobalJar, nil
+	} else if err != nil {
+		return nil, "", err
+	}
+	jar, err := s.aead.Open(nil, row.Nonce, row.Ciphertext, []byte(feedID))
+	if err != nil {
+		return nil, "", fmt.Errorf("could not decrypt cookie jar, has the cookie key changed? %w", err)
+	}
+	return jar, feedID, nil
+}
+
+// File writes the jar for a feed to a private temporary file for yt-dlp,
+// and returns its path along with a func to call once yt-dlp is done. That
+// stores any cookies yt-dlp saved back into the jar they came from, as
+// YouTube rotates them, and removes the file. The path is empty if there is
+// no jar to use. A nil Store never has a jar.
+func (s *Store) File(ctx context.Context, feedID string) (string, func(context.Context) error, error) {
+	noop := func(context.Context) error { return nil }
+	if s == nil {
+		return "", noop, nil
+	}
+	jar, jarID, err := s.get(ctx, feedID)
+	if err != nil || jar == nil {
+		return "", noop, err
+	}
+
+	// CreateTemp makes the file readable by us alone
+	f, err := os.CreateTemp("", "vpod-cookies-*.txt")
+	if err != nil {
+		return "", noop, err
+	}
+	path := f.Name()
+	if _, err := f.Write(jar); err != nil {
+		f.Close()
+		os.Remove(path)
+		return "", noop, err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return "", noop, err
+	}
+
+	done := func(ctx context.Context) error {
+		defer os.Remove(path)
+		saved, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(saved, jar) {
+			return nil
+		}
+		return s.Put(ctx, jarID, saved)
+	}
+	return path, done, nil
+}
@@ -0,0 +1,181 @@
+package cookies
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"os"
+	"testing"
+	"vpod/internal/data"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const testJar = "# Netscape HTTP Cookie File\n" +
+	".youtube.com\tTRUE\t/\tTRUE\t1999999999\tSID\tsecret-session\n" +
+	"#HttpOnly_.youtube.com\tTRUE\t/\tTRUE\t1999999999\tHSID\tsecret-http-only\n"
+
+func initDb(t *testing.T) (*sql.DB, *data.Queries) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	if err := data.Migrate(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	return db, data.New(db)
+}
+
+func newStore(t *testing.T, queries *data.Queries) *Store {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(queries, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func readFile(t *testing.T, s *Store, feedID string) string {
+	t.Helper()
+	path, done, err := s.File(context.Background(), feedID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done(context.Background())
+	if path == "" {
+		return ""
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("cookie file should only be readable by us, got %v", info.Mode().Perm())
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	db, queries := initDb(t)
+	s := newStore(t, queries)
+
+	if got := readFile(t, s, "UCfeed"); got != "" {
+		t.Errorf("expected no jar before any are stored, got %q", got)
+	}
+
+	if err := s.Put(ctx, GlobalJar, []byte(testJar)); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, s, "UCfeed"); got != testJar {
+		t.Errorf("feeds without a jar should use the global one, got %q", got)
+	}
+
+	feedJar := ".youtube.com\tTRUE\t/\tTRUE\t1999999999\tSID\tmembers-only\n"
+	if err := s.Put(ctx, "UCfeed", []byte(feedJar)); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, s, "UCfeed"); got != feedJar {
+		t.Errorf("expected the feed's own jar, got %q", got)
+	}
+
+	var stored []byte
+	if err := db.QueryRow("SELECT ciphertext FROM CookieJars WHERE feed_id = ?", "UCfeed").Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stored, []byte("members-only")) {
+		t.Error("cookie jar was stored in the clear")
+	}
+
+	// Moving a jar to another feed in the DB shouldn't decrypt
+	if _, err := db.Exec("UPDATE CookieJars SET feed_id = 'UCother' WHERE feed_id = 'UCfeed'"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.File(ctx, "UCother"); err == nil {
+		t.Error("a jar moved to another feed was decrypted")
+	}
+
+	if err := s.Delete(ctx, GlobalJar); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, s, "UCfeed"); got != "" {
+		t.Errorf("expected no jar after deleting the global one, got %q", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		jar     string
+		wantErr bool
+	}{
+		{"netscape jar", testJar, false},
+		{"only comments", "# Netscape HTTP Cookie File\n", true},
+		{"json", `[{"name": "SID", "value": "secret"}]`, true},
+		{"empty", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Validate([]byte(tt.jar)); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNilStore(t *testing.T) {
+	var s *Store
+	path, done, err := s.File(context.Background(), "UCfeed")
+	defer done(context.Background())
+	if path != "" || err != nil {
+		t.Errorf("a nil store should have no jar, got %q, %v", path, err)
+	}
+}
+
+func TestFileSavesRotatedCookies(t *testing.T) {
+	ctx := context.Background()
+	db, queries := initDb(t)
+	s := newStore(t, queries)
+	if err := s.Put(ctx, GlobalJar, []byte(testJar)); err != nil {
+		t.Fatal(err)
+	}
+
+	path, done, err := s.File(ctx, "UCfeed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// yt-dlp writes the jar back with whatever cookies YouTube set
+	rotated := ".youtube.com\tTRUE\t/\tTRUE\t1999999999\tSID\trotated-session\n"
+	if err := os.WriteFile(path, []byte(rotated), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := done(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("cookie file was left behind: %v", err)
+	}
+
+	if got := readFile(t, s, GlobalJar); got != rotated {
+		t.Errorf("rotated cookies weren't saved to the jar they came from, got %q", got)
+	}
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM CookieJars WHERE feed_id = 'UCfeed'").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Error("the global jar's cookies were saved as the feed's own")
+	}
+}
@@ -3,7 +3,12 @@ package data
 import (
 	"context"
 	"database/sql"
-	_ "embed"
+	"embed"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -12,6 +17,13 @@ import (
 //go:embed schema.sql
 var DDL string
 
+// Migrations change the schema of databases created from DDL. They are
+// applied in order of their numeric prefix, and the last one applied is
+// tracked in the database's user_version.
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
 func Initialize(ctx context.Context) (*sql.DB, *Queries, error) {
 	db, err := sql.Open("sqlite3", "./podcasts.db")
 	if err != nil {
@@ -22,10 +34,63 @@ func Initialize(ctx context.Context) (*sql.DB, *Queries, error) {
 	db.SetMaxIdleConns(25)
 	db.SetConnMaxLifetime(5 * time.Minute)
 
-	if _, err := db.ExecContext(ctx, DDL); err != nil {
+	if err := Migrate(ctx, db); err != nil {
 		return nil, nil, err
 	}
 
-	queries := New(db)
+	queries := New(Traced(db))
 	return db, queries, nil
 }
+
+// Migrate creates any missing tables and applies pending migrations.
+func Migrate(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, DDL); err != nil {
+		return err
+	}
+
+	var version int
+	if err := db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+
+	names, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return err
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		prefix, _, _ := strings.Cut(strings.TrimPrefix(name, "migrations/"), "_")
+		n, err := strconv.Atoi(prefix)
+		if err != nil {
+			return fmt.Errorf("migration %s has no numeric prefix", name)
+		}
+		if n <= version {
+			continue
+		}
+
+		stmts, err := migrations.ReadFile(name)
+		if err != nil {
+			return err
+		}
+
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, string(stmts)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %s: %w", name, err)
+		}
+		// PRAGMA doesn't take bound parameters
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", n)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		version = n
+	}
+	return nil
+}
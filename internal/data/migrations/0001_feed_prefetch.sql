@@ -0,0 +1 @@
+ALTER TABLE FeedSettings ADD COLUMN prefetch_count INTEGER NOT NULL DEFAULT 0;
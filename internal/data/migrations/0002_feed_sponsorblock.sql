@@ -0,0 +1,2 @@
+ALTER TABLE FeedSettings ADD COLUMN sponsorblock_remove TEXT;
+ALTER TABLE FeedSettings ADD COLUMN sponsorblock_mark TEXT;
@@ -0,0 +1,10 @@
+ALTER TABLE FeedSettings ADD COLUMN loudnorm BOOLEAN NOT NULL DEFAULT 0;
+ALTER TABLE FeedSettings ADD COLUMN mono BOOLEAN NOT NULL DEFAULT 0;
+ALTER TABLE FeedSettings ADD COLUMN trim_silence BOOLEAN NOT NULL DEFAULT 0;
+ALTER TABLE FeedSettings ADD COLUMN bitrate_kbps INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE FeedSettings ADD COLUMN speed REAL NOT NULL DEFAULT 1;
+CREATE TABLE IF NOT EXISTS MeasuredEnclosures (
+    url TEXT PRIMARY KEY NOT NULL UNIQUE,
+    length_bytes INTEGER NOT NULL,
+    measured_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
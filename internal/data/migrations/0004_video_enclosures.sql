@@ -0,0 +1,3 @@
+ALTER TABLE FeedSettings ADD COLUMN media TEXT NOT NULL DEFAULT 'audio';
+ALTER TABLE Episodes ADD COLUMN video_enclosure_url TEXT;
+ALTER TABLE Episodes ADD COLUMN video_enclosure_length_bytes INTEGER;
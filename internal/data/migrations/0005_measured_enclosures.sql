@@ -0,0 +1 @@
+ALTER TABLE MeasuredEnclosures ADD COLUMN duration_seconds INTEGER;
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS CookieJars (
+    feed_id TEXT PRIMARY KEY NOT NULL UNIQUE,
+    nonce BLOB NOT NULL,
+    ciphertext BLOB NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
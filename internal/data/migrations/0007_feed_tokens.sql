@@ -0,0 +1,10 @@
+ALTER TABLE FeedSettings ADD COLUMN private BOOLEAN NOT NULL DEFAULT 0;
+CREATE TABLE IF NOT EXISTS FeedTokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    feed_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    revoked_at TIMESTAMP,
+    FOREIGN KEY (feed_id) REFERENCES Feeds(id)
+);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS Sessions (
+    id_hash TEXT PRIMARY KEY NOT NULL UNIQUE,
+    user_id INTEGER NOT NULL,
+    csrf_token TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES Users(id)
+);
@@ -0,0 +1 @@
+ALTER TABLE WebSubSubscriptions ADD COLUMN pending_mode TEXT;
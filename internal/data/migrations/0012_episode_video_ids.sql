@@ -0,0 +1,42 @@
+-- Episodes are keyed by feed too, so a video in several feeds has a row in
+-- each, and can be found by its video id without scanning every url.
+CREATE TABLE Episodes_new (
+    id BLOB NOT NULL,
+    audio_url TEXT NOT NULL,
+    audio_length_bytes INTEGER NOT NULL,
+    description TEXT,
+    duration INTEGER,
+    feed_id TEXT NOT NULL,
+    released_at TIMESTAMP,
+    thumbnail TEXT,
+    title TEXT NOT NULL,
+    video_url TEXT,
+    video_enclosure_url TEXT,
+    video_enclosure_length_bytes INTEGER,
+    video_id TEXT NOT NULL,
+    PRIMARY KEY (feed_id, id),
+    FOREIGN KEY (feed_id) REFERENCES Feeds(id)
+);
+-- Enclosures are always <base url>/audio/<video id>/<format id>
+INSERT INTO Episodes_new
+SELECT
+    id,
+    audio_url,
+    audio_length_bytes,
+    description,
+    duration,
+    feed_id,
+    released_at,
+    thumbnail,
+    title,
+    video_url,
+    video_enclosure_url,
+    video_enclosure_length_bytes,
+    substr(path, 1, instr(path, '/') - 1)
+FROM (
+    SELECT *, substr(audio_url, instr(audio_url, '/audio/') + 7) AS path
+    FROM Episodes
+);
+DROP TABLE Episodes;
+ALTER TABLE Episodes_new RENAME TO Episodes;
+CREATE INDEX episodes_video_id ON Episodes (video_id);
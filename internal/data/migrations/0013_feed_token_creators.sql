@@ -0,0 +1,2 @@
+-- Tokens from before this have no creator, so only admins can manage them
+ALTER TABLE FeedTokens ADD COLUMN created_by INTEGER REFERENCES Users(id);
@@ -0,0 +1,3 @@
+-- Only OIDC sign ins have created identities so far
+ALTER TABLE Users ADD COLUMN is_external BOOLEAN NOT NULL DEFAULT 0;
+UPDATE Users SET is_external = 1 WHERE id IN (SELECT user_id FROM UserIdentities);
@@ -6,19 +6,44 @@ package data
 
 import (
 	"database/sql"
+	"time"
 )
 
+type AudioFile struct {
+	Path           string
+	VideoID        string
+	FormatID       string
+	SizeBytes      int64
+	CreatedAt      sql.NullTime
+	LastAccessedAt sql.NullTime
+}
+
+type CookieJar struct {
+	FeedID     string
+	Nonce      []byte
+	Ciphertext []byte
+	UpdatedAt  sql.NullTime
+}
+
 type Episode struct {
-	ID               []byte
-	AudioUrl         string
-	AudioLengthBytes int64
-	Description      sql.NullString
-	Duration         sql.NullInt64
-	FeedID           string
-	ReleasedAt       sql.NullTime
-	Thumbnail        sql.NullString
-	Title            string
-	VideoUrl         sql.NullString
+	ID                        []byte
+	AudioUrl                  string
+	AudioLengthBytes          int64
+	Description               sql.NullString
+	Duration                  sql.NullInt64
+	FeedID                    string
+	ReleasedAt                sql.NullTime
+	Thumbnail                 sql.NullString
+	Title                     string
+	VideoUrl                  sql.NullString
+	VideoEnclosureUrl         sql.NullString
+	VideoEnclosureLengthBytes sql.NullInt64
+	VideoID                   string
+}
+
+type EpisodePin struct {
+	VideoID   string
+	CreatedAt sql.NullTime
 }
 
 type Feed struct {
@@ -30,3 +55,77 @@ type Feed struct {
 	Link        string
 	Xml         string
 }
+
+type FeedSetting struct {
+	FeedID                 string
+	RefreshMode            string
+	RefreshIntervalSeconds sql.NullInt64
+	LastRefreshedAt        sql.NullTime
+	NextRefreshAt          sql.NullTime
+	PrefetchCount          int64
+	SponsorblockRemove     sql.NullString
+	SponsorblockMark       sql.NullString
+	Loudnorm               bool
+	Mono                   bool
+	TrimSilence            bool
+	BitrateKbps            int64
+	Speed                  float64
+	Media                  string
+	Private                bool
+}
+
+type FeedToken struct {
+	ID        int64
+	FeedID    string
+	Name      string
+	TokenHash string
+	CreatedAt sql.NullTime
+	RevokedAt sql.NullTime
+	CreatedBy sql.NullInt64
+}
+
+type MeasuredEnclosure struct {
+	Url             string
+	LengthBytes     int64
+	MeasuredAt      sql.NullTime
+	DurationSeconds sql.NullInt64
+}
+
+type Session struct {
+	IDHash    string
+	UserID    int64
+	CsrfToken string
+	CreatedAt sql.NullTime
+	ExpiresAt time.Time
+}
+
+type User struct {
+	ID           int64
+	Username     string
+	PasswordHash string
+	IsAdmin      bool
+	CreatedAt    sql.NullTime
+	IsExternal   bool
+}
+
+type UserFeed struct {
+	UserID  int64
+	FeedID  string
+	AddedAt sql.NullTime
+}
+
+type UserIdentity struct {
+	Issuer    string
+	Subject   string
+	UserID    int64
+	Email     string
+	CreatedAt sql.NullTime
+}
+
+type WebSubSubscription struct {
+	FeedID         string
+	Topic          string
+	Secret         string
+	LeaseExpiresAt sql.NullTime
+	PendingMode    sql.NullString
+}
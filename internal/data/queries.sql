@@ -28,7 +28,10 @@ INSERT OR REPLACE INTO Episodes (
     released_at,
     thumbnail,
     title,
-    video_url
+    video_url,
+    video_enclosure_url,
+    video_enclosure_length_bytes,
+    video_id
 ) VALUES (
     ?,
     ?,
@@ -39,6 +42,9 @@ INSERT OR REPLACE INTO Episodes (
     ?,
     ?,
     ?,
+    ?,
+    ?,
+    ?,
     ?
 );
 
@@ -52,7 +58,10 @@ SELECT id,
   released_at,
   thumbnail,
   title,
-  video_url
+  video_url,
+  video_enclosure_url,
+  video_enclosure_length_bytes,
+  video_id
 FROM Episodes
 WHERE feed_id = ?;
 
@@ -62,13 +71,8 @@ SELECT xml FROM Feeds WHERE id = ?;
 -- name: GetOlderEpisodesForFeed :many
 SELECT *
 FROM Episodes as e
-WHERE e.feed_id = ?1
-AND released_at < (
-    SELECT released_at
-    FROM Episodes AS e
-    WHERE e.id = ?2
-      AND e.feed_id = ?1
-)
+WHERE e.feed_id = ?
+  AND e.released_at < ?
 ORDER BY released_at DESC;
 
 -- name: GetAllFeedIds :many
@@ -90,3 +94,578 @@ TotalCount AS (
 SELECT fd.*,
        (SELECT total_rows > (?2 * ?1) FROM TotalCount) AS has_more
 FROM FeedData fd;
+
+-- name: GetDueFeedIds :many
+SELECT f.id
+FROM Feeds AS f
+LEFT JOIN FeedSettings AS s ON s.feed_id = CAST(f.id AS TEXT)
+WHERE s.next_refresh_at IS NULL
+   OR s.next_refresh_at <= ?;
+
+-- name: GetFeedSchedule :one
+SELECT refresh_mode, refresh_interval_seconds
+FROM FeedSettings
+WHERE feed_id = ?;
+
+-- name: UpsertFeedSchedule :exec
+INSERT INTO FeedSettings (
+    feed_id,
+    refresh_mode,
+    refresh_interval_seconds,
+    next_refresh_at
+) VALUES (
+    ?,
+    ?,
+    ?,
+    NULL
+)
+ON CONFLICT (feed_id) DO UPDATE SET
+    refresh_mode = excluded.refresh_mode,
+    refresh_interval_seconds = excluded.refresh_interval_seconds,
+    next_refresh_at = NULL;
+
+-- name: SetFeedRefreshed :exec
+INSERT INTO FeedSettings (
+    feed_id,
+    last_refreshed_at,
+    next_refresh_at
+) VALUES (
+    ?,
+    ?,
+    ?
+)
+ON CONFLICT (feed_id) DO UPDATE SET
+    last_refreshed_at = excluded.last_refreshed_at,
+    next_refresh_at = excluded.next_refresh_at;
+
+-- name: GetRecentReleaseTimes :many
+SELECT released_at
+FROM Episodes
+WHERE feed_id = ?
+  AND released_at IS NOT NULL
+ORDER BY released_at DESC
+LIMIT ?;
+
+-- name: GetWebSubSubscription :one
+SELECT *
+FROM WebSubSubscriptions
+WHERE feed_id = ?;
+
+-- name: UpsertWebSubSubscription :exec
+INSERT INTO WebSubSubscriptions (
+    feed_id,
+    topic,
+    secret,
+    pending_mode
+) VALUES (
+    ?,
+    ?,
+    ?,
+    ?
+)
+ON CONFLICT (feed_id) DO UPDATE SET
+    topic = excluded.topic,
+    secret = excluded.secret,
+    pending_mode = excluded.pending_mode;
+
+-- name: SetWebSubLease :exec
+UPDATE WebSubSubscriptions
+SET lease_expires_at = ?,
+    pending_mode = NULL
+WHERE feed_id = ?;
+
+-- name: GetFeedIdsNeedingWebSub :many
+SELECT f.id
+FROM Feeds AS f
+LEFT JOIN WebSubSubscriptions AS w ON w.feed_id = CAST(f.id AS TEXT)
+WHERE w.lease_expires_at IS NULL
+   OR w.lease_expires_at <= ?;
+
+-- name: UpsertAudioFile :exec
+INSERT INTO AudioFiles (
+    path,
+    video_id,
+    format_id,
+    size_bytes,
+    last_accessed_at
+) VALUES (
+    ?,
+    ?,
+    ?,
+    ?,
+    ?
+)
+ON CONFLICT (path) DO UPDATE SET
+    size_bytes = excluded.size_bytes,
+    last_accessed_at = excluded.last_accessed_at;
+
+-- name: TouchAudioFile :exec
+UPDATE AudioFiles
+SET last_accessed_at = ?
+WHERE path = ?;
+
+-- name: GetAudioFiles :many
+SELECT *
+FROM AudioFiles;
+
+-- name: DeleteAudioFile :exec
+DELETE FROM AudioFiles
+WHERE path = ?;
+
+-- name: GetPinnedVideoIds :many
+SELECT video_id
+FROM EpisodePins;
+
+-- name: PinEpisode :exec
+INSERT OR IGNORE INTO EpisodePins (
+    video_id
+) VALUES (
+    ?
+);
+
+-- name: UnpinEpisode :exec
+DELETE FROM EpisodePins
+WHERE video_id = ?;
+
+-- name: GetEpisodeReleases :many
+SELECT feed_id, video_url, released_at
+FROM Episodes;
+
+-- name: GetFeedPrefetchCount :one
+SELECT prefetch_count
+FROM FeedSettings
+WHERE feed_id = ?;
+
+-- name: SetFeedPrefetchCount :exec
+INSERT INTO FeedSettings (
+    feed_id,
+    prefetch_count
+) VALUES (
+    ?,
+    ?
+)
+ON CONFLICT (feed_id) DO UPDATE SET
+    prefetch_count = excluded.prefetch_count;
+
+-- name: GetNewestEpisodesForFeed :many
+SELECT *
+FROM Episodes
+WHERE feed_id = ?
+ORDER BY released_at DESC
+LIMIT ?;
+
+-- name: GetAudioCacheSize :one
+SELECT CAST(COALESCE(SUM(size_bytes), 0) AS INTEGER)
+FROM AudioFiles;
+
+-- name: GetAudioSettingsForFeed :one
+SELECT
+    sponsorblock_remove,
+    sponsorblock_mark,
+    loudnorm,
+    mono,
+    trim_silence,
+    bitrate_kbps,
+    speed
+FROM FeedSettings
+WHERE feed_id = ?;
+
+-- name: SetFeedSponsorBlock :exec
+INSERT INTO FeedSettings (
+    feed_id,
+    sponsorblock_remove,
+    sponsorblock_mark
+) VALUES (
+    ?,
+    ?,
+    ?
+)
+ON CONFLICT (feed_id) DO UPDATE SET
+    sponsorblock_remove = excluded.sponsorblock_remove,
+    sponsorblock_mark = excluded.sponsorblock_mark;
+
+-- name: SetFeedPostProcess :exec
+INSERT INTO FeedSettings (
+    feed_id,
+    loudnorm,
+    mono,
+    trim_silence,
+    bitrate_kbps,
+    speed
+) VALUES (
+    ?,
+    ?,
+    ?,
+    ?,
+    ?,
+    ?
+)
+ON CONFLICT (feed_id) DO UPDATE SET
+    loudnorm = excluded.loudnorm,
+    mono = excluded.mono,
+    trim_silence = excluded.trim_silence,
+    bitrate_kbps = excluded.bitrate_kbps,
+    speed = excluded.speed;
+
+-- name: GetEpisodesForVideo :many
+SELECT
+    feed_id,
+    audio_url,
+    audio_length_bytes,
+    duration,
+    video_enclosure_url,
+    video_enclosure_length_bytes
+FROM Episodes
+WHERE video_id = ?
+ORDER BY feed_id;
+
+-- name: GetCookieJarFeedForVideo :one
+SELECT Episodes.feed_id
+FROM Episodes
+JOIN CookieJars ON CookieJars.feed_id = Episodes.feed_id
+WHERE Episodes.video_id = ?
+ORDER BY Episodes.feed_id
+LIMIT 1;
+
+-- name: SetEpisodeAudioMeasurements :exec
+UPDATE Episodes
+SET audio_length_bytes = sqlc.arg(length_bytes),
+    duration = COALESCE(sqlc.narg(duration), duration)
+WHERE audio_url = sqlc.arg(url);
+
+-- name: SetEpisodeVideoMeasurements :exec
+UPDATE Episodes
+SET video_enclosure_length_bytes = sqlc.arg(length_bytes),
+    duration = COALESCE(sqlc.narg(duration), duration)
+WHERE video_enclosure_url = sqlc.arg(url);
+
+-- name: UpsertMeasuredEnclosure :exec
+INSERT INTO MeasuredEnclosures (
+    url,
+    length_bytes,
+    duration_seconds,
+    measured_at
+) VALUES (
+    ?,
+    ?,
+    ?,
+    CURRENT_TIMESTAMP
+)
+ON CONFLICT (url) DO UPDATE SET
+    length_bytes = excluded.length_bytes,
+    duration_seconds = excluded.duration_seconds,
+    measured_at = excluded.measured_at;
+
+-- name: GetMeasuredEnclosuresForFeed :many
+SELECT MeasuredEnclosures.url, MeasuredEnclosures.length_bytes, MeasuredEnclosures.duration_seconds
+FROM MeasuredEnclosures
+JOIN Episodes ON Episodes.audio_url = MeasuredEnclosures.url
+    OR Episodes.video_enclosure_url = MeasuredEnclosures.url
+WHERE Episodes.feed_id = ?;
+
+-- name: GetFeedMedia :one
+SELECT media
+FROM FeedSettings
+WHERE feed_id = ?;
+
+-- name: SetFeedMedia :exec
+INSERT INTO FeedSettings (
+    feed_id,
+    media
+) VALUES (
+    ?,
+    ?
+)
+ON CONFLICT (feed_id) DO UPDATE SET
+    media = excluded.media;
+
+-- name: GetCookieJar :one
+SELECT nonce, ciphertext
+FROM CookieJars
+WHERE feed_id = ?;
+
+-- name: UpsertCookieJar :exec
+INSERT INTO CookieJars (
+    feed_id,
+    nonce,
+    ciphertext,
+    updated_at
+) VALUES (
+    ?,
+    ?,
+    ?,
+    CURRENT_TIMESTAMP
+)
+ON CONFLICT (feed_id) DO UPDATE SET
+    nonce = excluded.nonce,
+    ciphertext = excluded.ciphertext,
+    updated_at = excluded.updated_at;
+
+-- name: DeleteCookieJar :exec
+DELETE FROM CookieJars
+WHERE feed_id = ?;
+
+-- name: GetFeedPrivate :one
+SELECT private
+FROM FeedSettings
+WHERE feed_id = ?;
+
+-- name: SetFeedPrivate :exec
+INSERT INTO FeedSettings (
+    feed_id,
+    private
+) VALUES (
+    ?,
+    ?
+)
+ON CONFLICT (feed_id) DO UPDATE SET
+    private = excluded.private;
+
+-- name: CreateFeedToken :one
+INSERT INTO FeedTokens (
+    feed_id,
+    name,
+    token_hash,
+    created_by
+) VALUES (
+    ?,
+    ?,
+    ?,
+    ?
+)
+RETURNING id;
+
+-- name: GetFeedTokenByHash :one
+SELECT id, feed_id
+FROM FeedTokens
+WHERE token_hash = ?
+  AND revoked_at IS NULL;
+
+-- name: GetFeedTokens :many
+SELECT id, feed_id, name, token_hash, created_at, revoked_at, created_by
+FROM FeedTokens
+WHERE feed_id = sqlc.arg(feed_id)
+  AND revoked_at IS NULL
+  AND (sqlc.narg(created_by) IS NULL OR created_by = sqlc.narg(created_by))
+ORDER BY id;
+
+-- name: RevokeFeedToken :one
+UPDATE FeedTokens
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE id = sqlc.arg(id)
+  AND feed_id = sqlc.arg(feed_id)
+  AND revoked_at IS NULL
+  AND (sqlc.narg(created_by) IS NULL OR created_by = sqlc.narg(created_by))
+RETURNING name, created_by;
+
+-- name: CountUsers :one
+SELECT COUNT(*)
+FROM Users;
+
+-- name: CreateUser :one
+INSERT INTO Users (
+    username,
+    password_hash,
+    is_admin,
+    is_external
+) VALUES (
+    ?,
+    ?,
+    ?,
+    ?
+)
+RETURNING *;
+
+-- name: GetUser :one
+SELECT *
+FROM Users
+WHERE id = ?;
+
+-- name: GetUserByUsername :one
+SELECT *
+FROM Users
+WHERE username = ?;
+
+-- name: GetUsers :many
+SELECT *
+FROM Users
+ORDER BY username;
+
+-- name: SetUserPassword :exec
+UPDATE Users
+SET password_hash = ?
+WHERE id = ?;
+
+-- name: DeleteUser :exec
+DELETE FROM Users
+WHERE id = ?;
+
+-- name: AddUserFeed :exec
+INSERT OR IGNORE INTO UserFeeds (
+    user_id,
+    feed_id
+) VALUES (
+    ?,
+    ?
+);
+
+-- name: AddAllFeedsToUser :exec
+INSERT OR IGNORE INTO UserFeeds (user_id, feed_id)
+SELECT sqlc.arg(user_id), CAST(id AS TEXT)
+FROM Feeds;
+
+-- name: RemoveUserFeed :exec
+DELETE FROM UserFeeds
+WHERE user_id = ?
+  AND feed_id = ?;
+
+-- name: DeleteUserFeeds :exec
+DELETE FROM UserFeeds
+WHERE user_id = ?;
+
+-- name: UserHasFeed :one
+SELECT EXISTS (
+    SELECT 1
+    FROM UserFeeds
+    WHERE user_id = ?
+      AND feed_id = ?
+);
+
+-- name: UserHasVideo :one
+SELECT EXISTS (
+    SELECT 1
+    FROM UserFeeds
+    JOIN Episodes ON Episodes.feed_id = UserFeeds.feed_id
+    WHERE UserFeeds.user_id = ?
+      AND Episodes.video_id = ?
+);
+
+-- name: GetFeedsForUser :many
+WITH FeedData AS (
+    SELECT f.*
+    FROM Feeds AS f
+    JOIN UserFeeds AS uf ON uf.feed_id = CAST(f.id AS TEXT)
+    WHERE uf.user_id = sqlc.arg(user_id)
+    ORDER BY uf.added_at, f.title
+    LIMIT sqlc.arg(page_size)
+    OFFSET (sqlc.arg(page_num) - 1) * sqlc.arg(page_size)
+),
+TotalCount AS (
+    SELECT COUNT(*) AS total_rows
+    FROM UserFeeds
+    WHERE user_id = sqlc.arg(user_id)
+)
+SELECT fd.*,
+       (SELECT total_rows > (sqlc.arg(page_num) * sqlc.arg(page_size)) FROM TotalCount) AS has_more
+FROM FeedData fd;
+
+-- name: CreateSession :exec
+INSERT INTO Sessions (
+    id_hash,
+    user_id,
+    csrf_token,
+    expires_at
+) VALUES (
+    ?,
+    ?,
+    ?,
+    ?
+);
+
+-- name: GetSession :one
+SELECT user_id, csrf_token, expires_at
+FROM Sessions
+WHERE id_hash = ?;
+
+-- name: DeleteSession :exec
+DELETE FROM Sessions
+WHERE id_hash = ?;
+
+-- name: DeleteExpiredSessions :exec
+DELETE FROM Sessions
+WHERE expires_at <= ?;
+
+-- name: DeleteUserSessions :exec
+DELETE FROM Sessions
+WHERE user_id = ?
+  AND id_hash != sqlc.arg(except_id_hash);
+
+-- name: SetUserAdmin :exec
+UPDATE Users
+SET is_admin = ?
+WHERE id = ?;
+
+-- name: GetUserIdentity :one
+SELECT user_id
+FROM UserIdentities
+WHERE issuer = ?
+  AND subject = ?;
+
+-- name: CreateUserIdentity :exec
+INSERT INTO UserIdentities (
+    issuer,
+    subject,
+    user_id,
+    email
+) VALUES (
+    ?,
+    ?,
+    ?,
+    ?
+);
+
+-- name: DeleteUserIdentities :exec
+DELETE FROM UserIdentities
+WHERE user_id = ?;
+
+-- name: CountFeeds :one
+SELECT COUNT(*)
+FROM Feeds;
+
+-- name: CountEpisodes :one
+SELECT COUNT(*)
+FROM Episodes;
+
+-- name: GetOldestDueRefresh :one
+SELECT next_refresh_at
+FROM FeedSettings
+WHERE next_refresh_at <= ?
+ORDER BY next_refresh_at
+LIMIT 1;
+
+-- name: DeleteFeed :exec
+DELETE FROM Feeds
+WHERE id = ?;
+
+-- name: DeleteFeedEpisodePins :exec
+DELETE FROM EpisodePins
+WHERE video_id IN (
+    SELECT video_id
+    FROM Episodes
+    WHERE feed_id = sqlc.arg(feed_id)
+)
+  AND video_id NOT IN (
+    SELECT video_id
+    FROM Episodes
+    WHERE feed_id != sqlc.arg(feed_id)
+);
+
+-- name: DeleteFeedEpisodes :exec
+DELETE FROM Episodes
+WHERE feed_id = ?;
+
+-- name: DeleteFeedSettings :exec
+DELETE FROM FeedSettings
+WHERE feed_id = ?;
+
+-- name: DeleteFeedTokens :exec
+DELETE FROM FeedTokens
+WHERE feed_id = ?;
+
+-- name: DeleteFeedUsers :exec
+DELETE FROM UserFeeds
+WHERE feed_id = ?;
+
+-- name: DeleteWebSubSubscription :exec
+DELETE FROM WebSubSubscriptions
+WHERE feed_id = ?;
@@ -8,8 +8,378 @@ package data
 import (
 	"context"
 	"database/sql"
+	"time"
 )
 
+const addAllFeedsToUser = `-- name: AddAllFeedsToUser :exec
+INSERT OR IGNORE INTO UserFeeds (user_id, feed_id)
+SELECT ?1, CAST(id AS TEXT)
+FROM Feeds
+`
+
+func (q *Queries) AddAllFeedsToUser(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, addAllFeedsToUser, userID)
+	return err
+}
+
+const addUserFeed = `-- name: AddUserFeed :exec
+INSERT OR IGNORE INTO UserFeeds (
+    user_id,
+    feed_id
+) VALUES (
+    ?,
+    ?
+)
+`
+
+type AddUserFeedParams struct {
+	UserID int64
+	FeedID string
+}
+
+func (q *Queries) AddUserFeed(ctx context.Context, arg AddUserFeedParams) error {
+	_, err := q.db.ExecContext(ctx, addUserFeed, arg.UserID, arg.FeedID)
+	return err
+}
+
+const countEpisodes = `-- name: CountEpisodes :one
+SELECT COUNT(*)
+FROM Episodes
+`
+
+func (q *Queries) CountEpisodes(ctx context.Context) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countEpisodes)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countFeeds = `-- name: CountFeeds :one
+SELECT COUNT(*)
+FROM Feeds
+`
+
+func (q *Queries) CountFeeds(ctx context.Context) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countFeeds)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countUsers = `-- name: CountUsers :one
+SELECT COUNT(*)
+FROM Users
+`
+
+func (q *Queries) CountUsers(ctx context.Context) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countUsers)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const createFeedToken = `-- name: CreateFeedToken :one
+INSERT INTO FeedTokens (
+    feed_id,
+    name,
+    token_hash,
+    created_by
+) VALUES (
+    ?,
+    ?,
+    ?,
+    ?
+)
+RETURNING id
+`
+
+type CreateFeedTokenParams struct {
+	FeedID    string
+	Name      string
+	TokenHash string
+	CreatedBy sql.NullInt64
+}
+
+func (q *Queries) CreateFeedToken(ctx context.Context, arg CreateFeedTokenParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, createFeedToken,
+		arg.FeedID,
+		arg.Name,
+		arg.TokenHash,
+		arg.CreatedBy,
+	)
+	var id int64
+	err := row.Scan(&id)
+	return id, err
+}
+
+const createSession = `-- name: CreateSession :exec
+INSERT INTO Sessions (
+    id_hash,
+    user_id,
+    csrf_token,
+    expires_at
+) VALUES (
+    ?,
+    ?,
+    ?,
+    ?
+)
+`
+
+type CreateSessionParams struct {
+	IDHash    string
+	UserID    int64
+	CsrfToken string
+	ExpiresAt time.Time
+}
+
+func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) error {
+	_, err := q.db.ExecContext(ctx, createSession,
+		arg.IDHash,
+		arg.UserID,
+		arg.CsrfToken,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
+const createUser = `-- name: CreateUser :one
+INSERT INTO Users (
+    username,
+    password_hash,
+    is_admin,
+    is_external
+) VALUES (
+    ?,
+    ?,
+    ?,
+    ?
+)
+RETURNING id, username, password_hash, is_admin, created_at, is_external
+`
+
+type CreateUserParams struct {
+	Username     string
+	PasswordHash string
+	IsAdmin      bool
+	IsExternal   bool
+}
+
+func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, createUser,
+		arg.Username,
+		arg.PasswordHash,
+		arg.IsAdmin,
+		arg.IsExternal,
+	)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.PasswordHash,
+		&i.IsAdmin,
+		&i.CreatedAt,
+		&i.IsExternal,
+	)
+	return i, err
+}
+
+const createUserIdentity = `-- name: CreateUserIdentity :exec
+INSERT INTO UserIdentities (
+    issuer,
+    subject,
+    user_id,
+    email
+) VALUES (
+    ?,
+    ?,
+    ?,
+    ?
+)
+`
+
+type CreateUserIdentityParams struct {
+	Issuer  string
+	Subject string
+	UserID  int64
+	Email   string
+}
+
+func (q *Queries) CreateUserIdentity(ctx context.Context, arg CreateUserIdentityParams) error {
+	_, err := q.db.ExecContext(ctx, createUserIdentity,
+		arg.Issuer,
+		arg.Subject,
+		arg.UserID,
+		arg.Email,
+	)
+	return err
+}
+
+const deleteAudioFile = `-- name: DeleteAudioFile :exec
+DELETE FROM AudioFiles
+WHERE path = ?
+`
+
+func (q *Queries) DeleteAudioFile(ctx context.Context, path string) error {
+	_, err := q.db.ExecContext(ctx, deleteAudioFile, path)
+	return err
+}
+
+const deleteCookieJar = `-- name: DeleteCookieJar :exec
+DELETE FROM CookieJars
+WHERE feed_id = ?
+`
+
+func (q *Queries) DeleteCookieJar(ctx context.Context, feedID string) error {
+	_, err := q.db.ExecContext(ctx, deleteCookieJar, feedID)
+	return err
+}
+
+const deleteExpiredSessions = `-- name: DeleteExpiredSessions :exec
+DELETE FROM Sessions
+WHERE expires_at <= ?
+`
+
+func (q *Queries) DeleteExpiredSessions(ctx context.Context, expiresAt time.Time) error {
+	_, err := q.db.ExecContext(ctx, deleteExpiredSessions, expiresAt)
+	return err
+}
+
+const deleteFeed = `-- name: DeleteFeed :exec
+DELETE FROM Feeds
+WHERE id = ?
+`
+
+func (q *Queries) DeleteFeed(ctx context.Context, id []byte) error {
+	_, err := q.db.ExecContext(ctx, deleteFeed, id)
+	return err
+}
+
+const deleteFeedEpisodePins = `-- name: DeleteFeedEpisodePins :exec
+DELETE FROM EpisodePins
+WHERE video_id IN (
+    SELECT video_id
+    FROM Episodes
+    WHERE feed_id = ?1
+)
+  AND video_id NOT IN (
+    SELECT video_id
+    FROM Episodes
+    WHERE feed_id != ?1
+)
+`
+
+func (q *Queries) DeleteFeedEpisodePins(ctx context.Context, feedID string) error {
+	_, err := q.db.ExecContext(ctx, deleteFeedEpisodePins, feedID)
+	return err
+}
+
+const deleteFeedEpisodes = `-- name: DeleteFeedEpisodes :exec
+DELETE FROM Episodes
+WHERE feed_id = ?
+`
+
+func (q *Queries) DeleteFeedEpisodes(ctx context.Context, feedID string) error {
+	_, err := q.db.ExecContext(ctx, deleteFeedEpisodes, feedID)
+	return err
+}
+
+const deleteFeedSettings = `-- name: DeleteFeedSettings :exec
+DELETE FROM FeedSettings
+WHERE feed_id = ?
+`
+
+func (q *Queries) DeleteFeedSettings(ctx context.Context, feedID string) error {
+	_, err := q.db.ExecContext(ctx, deleteFeedSettings, feedID)
+	return err
+}
+
+const deleteFeedTokens = `-- name: DeleteFeedTokens :exec
+DELETE FROM FeedTokens
+WHERE feed_id = ?
+`
+
+func (q *Queries) DeleteFeedTokens(ctx context.Context, feedID string) error {
+	_, err := q.db.ExecContext(ctx, deleteFeedTokens, feedID)
+	return err
+}
+
+const deleteFeedUsers = `-- name: DeleteFeedUsers :exec
+DELETE FROM UserFeeds
+WHERE feed_id = ?
+`
+
+func (q *Queries) DeleteFeedUsers(ctx context.Context, feedID string) error {
+	_, err := q.db.ExecContext(ctx, deleteFeedUsers, feedID)
+	return err
+}
+
+const deleteSession = `-- name: DeleteSession :exec
+DELETE FROM Sessions
+WHERE id_hash = ?
+`
+
+func (q *Queries) DeleteSession(ctx context.Context, idHash string) error {
+	_, err := q.db.ExecContext(ctx, deleteSession, idHash)
+	return err
+}
+
+const deleteUser = `-- name: DeleteUser :exec
+DELETE FROM Users
+WHERE id = ?
+`
+
+func (q *Queries) DeleteUser(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, deleteUser, id)
+	return err
+}
+
+const deleteUserFeeds = `-- name: DeleteUserFeeds :exec
+DELETE FROM UserFeeds
+WHERE user_id = ?
+`
+
+func (q *Queries) DeleteUserFeeds(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteUserFeeds, userID)
+	return err
+}
+
+const deleteUserIdentities = `-- name: DeleteUserIdentities :exec
+DELETE FROM UserIdentities
+WHERE user_id = ?
+`
+
+func (q *Queries) DeleteUserIdentities(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteUserIdentities, userID)
+	return err
+}
+
+const deleteUserSessions = `-- name: DeleteUserSessions :exec
+DELETE FROM Sessions
+WHERE user_id = ?
+  AND id_hash != ?2
+`
+
+type DeleteUserSessionsParams struct {
+	UserID       int64
+	ExceptIDHash string
+}
+
+func (q *Queries) DeleteUserSessions(ctx context.Context, arg DeleteUserSessionsParams) error {
+	_, err := q.db.ExecContext(ctx, deleteUserSessions, arg.UserID, arg.ExceptIDHash)
+	return err
+}
+
+const deleteWebSubSubscription = `-- name: DeleteWebSubSubscription :exec
+DELETE FROM WebSubSubscriptions
+WHERE feed_id = ?
+`
+
+func (q *Queries) DeleteWebSubSubscription(ctx context.Context, feedID string) error {
+	_, err := q.db.ExecContext(ctx, deleteWebSubSubscription, feedID)
+	return err
+}
+
 const getAllFeedIds = `-- name: GetAllFeedIds :many
 SELECT id
 FROM Feeds
@@ -103,41 +473,39 @@ func (q *Queries) GetAllFeeds(ctx context.Context, arg GetAllFeedsParams) ([]Get
 	return items, nil
 }
 
-const getEpisodesForFeed = `-- name: GetEpisodesForFeed :many
-SELECT id,
-  audio_url,
-  audio_length_bytes,
-  description,
-  duration,
-  feed_id,
-  released_at,
-  thumbnail,
-  title,
-  video_url
-FROM Episodes
-WHERE feed_id = ?
+const getAudioCacheSize = `-- name: GetAudioCacheSize :one
+SELECT CAST(COALESCE(SUM(size_bytes), 0) AS INTEGER)
+FROM AudioFiles
 `
 
-func (q *Queries) GetEpisodesForFeed(ctx context.Context, feedID string) ([]Episode, error) {
-	rows, err := q.db.QueryContext(ctx, getEpisodesForFeed, feedID)
+func (q *Queries) GetAudioCacheSize(ctx context.Context) (int64, error) {
+	row := q.db.QueryRowContext(ctx, getAudioCacheSize)
+	var column_1 int64
+	err := row.Scan(&column_1)
+	return column_1, err
+}
+
+const getAudioFiles = `-- name: GetAudioFiles :many
+SELECT path, video_id, format_id, size_bytes, created_at, last_accessed_at
+FROM AudioFiles
+`
+
+func (q *Queries) GetAudioFiles(ctx context.Context) ([]AudioFile, error) {
+	rows, err := q.db.QueryContext(ctx, getAudioFiles)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []Episode
+	var items []AudioFile
 	for rows.Next() {
-		var i Episode
+		var i AudioFile
 		if err := rows.Scan(
-			&i.ID,
-			&i.AudioUrl,
-			&i.AudioLengthBytes,
-			&i.Description,
-			&i.Duration,
-			&i.FeedID,
-			&i.ReleasedAt,
-			&i.Thumbnail,
-			&i.Title,
-			&i.VideoUrl,
+			&i.Path,
+			&i.VideoID,
+			&i.FormatID,
+			&i.SizeBytes,
+			&i.CreatedAt,
+			&i.LastAccessedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -152,59 +520,99 @@ func (q *Queries) GetEpisodesForFeed(ctx context.Context, feedID string) ([]Epis
 	return items, nil
 }
 
-const getFeedXML = `-- name: GetFeedXML :one
-SELECT xml FROM Feeds WHERE id = ?
+const getAudioSettingsForFeed = `-- name: GetAudioSettingsForFeed :one
+SELECT
+    sponsorblock_remove,
+    sponsorblock_mark,
+    loudnorm,
+    mono,
+    trim_silence,
+    bitrate_kbps,
+    speed
+FROM FeedSettings
+WHERE feed_id = ?
 `
 
-func (q *Queries) GetFeedXML(ctx context.Context, id []byte) (string, error) {
-	row := q.db.QueryRowContext(ctx, getFeedXML, id)
-	var xml string
-	err := row.Scan(&xml)
-	return xml, err
+type GetAudioSettingsForFeedRow struct {
+	SponsorblockRemove sql.NullString
+	SponsorblockMark   sql.NullString
+	Loudnorm           bool
+	Mono               bool
+	TrimSilence        bool
+	BitrateKbps        int64
+	Speed              float64
 }
 
-const getOlderEpisodesForFeed = `-- name: GetOlderEpisodesForFeed :many
-SELECT id, audio_url, audio_length_bytes, description, duration, feed_id, released_at, thumbnail, title, video_url
-FROM Episodes as e
-WHERE e.feed_id = ?1
-AND released_at < (
-    SELECT released_at
-    FROM Episodes AS e
-    WHERE e.id = ?2
-      AND e.feed_id = ?1
-)
-ORDER BY released_at DESC
+func (q *Queries) GetAudioSettingsForFeed(ctx context.Context, feedID string) (GetAudioSettingsForFeedRow, error) {
+	row := q.db.QueryRowContext(ctx, getAudioSettingsForFeed, feedID)
+	var i GetAudioSettingsForFeedRow
+	err := row.Scan(
+		&i.SponsorblockRemove,
+		&i.SponsorblockMark,
+		&i.Loudnorm,
+		&i.Mono,
+		&i.TrimSilence,
+		&i.BitrateKbps,
+		&i.Speed,
+	)
+	return i, err
+}
+
+const getCookieJar = `-- name: GetCookieJar :one
+SELECT nonce, ciphertext
+FROM CookieJars
+WHERE feed_id = ?
 `
 
-type GetOlderEpisodesForFeedParams struct {
-	FeedID string
-	ID     []byte
+type GetCookieJarRow struct {
+	Nonce      []byte
+	Ciphertext []byte
 }
 
-func (q *Queries) GetOlderEpisodesForFeed(ctx context.Context, arg GetOlderEpisodesForFeedParams) ([]Episode, error) {
-	rows, err := q.db.QueryContext(ctx, getOlderEpisodesForFeed, arg.FeedID, arg.ID)
+func (q *Queries) GetCookieJar(ctx context.Context, feedID string) (GetCookieJarRow, error) {
+	row := q.db.QueryRowContext(ctx, getCookieJar, feedID)
+	var i GetCookieJarRow
+	err := row.Scan(&i.Nonce, &i.Ciphertext)
+	return i, err
+}
+
+const getCookieJarFeedForVideo = `-- name: GetCookieJarFeedForVideo :one
+SELECT Episodes.feed_id
+FROM Episodes
+JOIN CookieJars ON CookieJars.feed_id = Episodes.feed_id
+WHERE Episodes.video_id = ?
+ORDER BY Episodes.feed_id
+LIMIT 1
+`
+
+func (q *Queries) GetCookieJarFeedForVideo(ctx context.Context, videoID string) (string, error) {
+	row := q.db.QueryRowContext(ctx, getCookieJarFeedForVideo, videoID)
+	var feed_id string
+	err := row.Scan(&feed_id)
+	return feed_id, err
+}
+
+const getDueFeedIds = `-- name: GetDueFeedIds :many
+SELECT f.id
+FROM Feeds AS f
+LEFT JOIN FeedSettings AS s ON s.feed_id = CAST(f.id AS TEXT)
+WHERE s.next_refresh_at IS NULL
+   OR s.next_refresh_at <= ?
+`
+
+func (q *Queries) GetDueFeedIds(ctx context.Context, nextRefreshAt sql.NullTime) ([][]byte, error) {
+	rows, err := q.db.QueryContext(ctx, getDueFeedIds, nextRefreshAt)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []Episode
+	var items [][]byte
 	for rows.Next() {
-		var i Episode
-		if err := rows.Scan(
-			&i.ID,
-			&i.AudioUrl,
-			&i.AudioLengthBytes,
-			&i.Description,
-			&i.Duration,
-			&i.FeedID,
-			&i.ReleasedAt,
-			&i.Thumbnail,
-			&i.Title,
-			&i.VideoUrl,
-		); err != nil {
+		var id []byte
+		if err := rows.Scan(&id); err != nil {
 			return nil, err
 		}
-		items = append(items, i)
+		items = append(items, id)
 	}
 	if err := rows.Close(); err != nil {
 		return nil, err
@@ -215,10 +623,1131 @@ func (q *Queries) GetOlderEpisodesForFeed(ctx context.Context, arg GetOlderEpiso
 	return items, nil
 }
 
-const upsertEpisode = `-- name: UpsertEpisode :exec
-INSERT OR REPLACE INTO Episodes (
-    id,
-    audio_url,
+const getEpisodeReleases = `-- name: GetEpisodeReleases :many
+SELECT feed_id, video_url, released_at
+FROM Episodes
+`
+
+type GetEpisodeReleasesRow struct {
+	FeedID     string
+	VideoUrl   sql.NullString
+	ReleasedAt sql.NullTime
+}
+
+func (q *Queries) GetEpisodeReleases(ctx context.Context) ([]GetEpisodeReleasesRow, error) {
+	rows, err := q.db.QueryContext(ctx, getEpisodeReleases)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetEpisodeReleasesRow
+	for rows.Next() {
+		var i GetEpisodeReleasesRow
+		if err := rows.Scan(&i.FeedID, &i.VideoUrl, &i.ReleasedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getEpisodesForFeed = `-- name: GetEpisodesForFeed :many
+SELECT id,
+  audio_url,
+  audio_length_bytes,
+  description,
+  duration,
+  feed_id,
+  released_at,
+  thumbnail,
+  title,
+  video_url,
+  video_enclosure_url,
+  video_enclosure_length_bytes,
+  video_id
+FROM Episodes
+WHERE feed_id = ?
+`
+
+func (q *Queries) GetEpisodesForFeed(ctx context.Context, feedID string) ([]Episode, error) {
+	rows, err := q.db.QueryContext(ctx, getEpisodesForFeed, feedID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Episode
+	for rows.Next() {
+		var i Episode
+		if err := rows.Scan(
+			&i.ID,
+			&i.AudioUrl,
+			&i.AudioLengthBytes,
+			&i.Description,
+			&i.Duration,
+			&i.FeedID,
+			&i.ReleasedAt,
+			&i.Thumbnail,
+			&i.Title,
+			&i.VideoUrl,
+			&i.VideoEnclosureUrl,
+			&i.VideoEnclosureLengthBytes,
+			&i.VideoID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getEpisodesForVideo = `-- name: GetEpisodesForVideo :many
+SELECT
+    feed_id,
+    audio_url,
+    audio_length_bytes,
+    duration,
+    video_enclosure_url,
+    video_enclosure_length_bytes
+FROM Episodes
+WHERE video_id = ?
+ORDER BY feed_id
+`
+
+type GetEpisodesForVideoRow struct {
+	FeedID                    string
+	AudioUrl                  string
+	AudioLengthBytes          int64
+	Duration                  sql.NullInt64
+	VideoEnclosureUrl         sql.NullString
+	VideoEnclosureLengthBytes sql.NullInt64
+}
+
+func (q *Queries) GetEpisodesForVideo(ctx context.Context, videoID string) ([]GetEpisodesForVideoRow, error) {
+	rows, err := q.db.QueryContext(ctx, getEpisodesForVideo, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetEpisodesForVideoRow
+	for rows.Next() {
+		var i GetEpisodesForVideoRow
+		if err := rows.Scan(
+			&i.FeedID,
+			&i.AudioUrl,
+			&i.AudioLengthBytes,
+			&i.Duration,
+			&i.VideoEnclosureUrl,
+			&i.VideoEnclosureLengthBytes,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getFeedIdsNeedingWebSub = `-- name: GetFeedIdsNeedingWebSub :many
+SELECT f.id
+FROM Feeds AS f
+LEFT JOIN WebSubSubscriptions AS w ON w.feed_id = CAST(f.id AS TEXT)
+WHERE w.lease_expires_at IS NULL
+   OR w.lease_expires_at <= ?
+`
+
+func (q *Queries) GetFeedIdsNeedingWebSub(ctx context.Context, leaseExpiresAt sql.NullTime) ([][]byte, error) {
+	rows, err := q.db.QueryContext(ctx, getFeedIdsNeedingWebSub, leaseExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items [][]byte
+	for rows.Next() {
+		var id []byte
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getFeedMedia = `-- name: GetFeedMedia :one
+SELECT media
+FROM FeedSettings
+WHERE feed_id = ?
+`
+
+func (q *Queries) GetFeedMedia(ctx context.Context, feedID string) (string, error) {
+	row := q.db.QueryRowContext(ctx, getFeedMedia, feedID)
+	var media string
+	err := row.Scan(&media)
+	return media, err
+}
+
+const getFeedPrefetchCount = `-- name: GetFeedPrefetchCount :one
+SELECT prefetch_count
+FROM FeedSettings
+WHERE feed_id = ?
+`
+
+func (q *Queries) GetFeedPrefetchCount(ctx context.Context, feedID string) (int64, error) {
+	row := q.db.QueryRowContext(ctx, getFeedPrefetchCount, feedID)
+	var prefetch_count int64
+	err := row.Scan(&prefetch_count)
+	return prefetch_count, err
+}
+
+const getFeedPrivate = `-- name: GetFeedPrivate :one
+SELECT private
+FROM FeedSettings
+WHERE feed_id = ?
+`
+
+func (q *Queries) GetFeedPrivate(ctx context.Context, feedID string) (bool, error) {
+	row := q.db.QueryRowContext(ctx, getFeedPrivate, feedID)
+	var private bool
+	err := row.Scan(&private)
+	return private, err
+}
+
+const getFeedSchedule = `-- name: GetFeedSchedule :one
+SELECT refresh_mode, refresh_interval_seconds
+FROM FeedSettings
+WHERE feed_id = ?
+`
+
+type GetFeedScheduleRow struct {
+	RefreshMode            string
+	RefreshIntervalSeconds sql.NullInt64
+}
+
+func (q *Queries) GetFeedSchedule(ctx context.Context, feedID string) (GetFeedScheduleRow, error) {
+	row := q.db.QueryRowContext(ctx, getFeedSchedule, feedID)
+	var i GetFeedScheduleRow
+	err := row.Scan(&i.RefreshMode, &i.RefreshIntervalSeconds)
+	return i, err
+}
+
+const getFeedTokenByHash = `-- name: GetFeedTokenByHash :one
+SELECT id, feed_id
+FROM FeedTokens
+WHERE token_hash = ?
+  AND revoked_at IS NULL
+`
+
+type GetFeedTokenByHashRow struct {
+	ID     int64
+	FeedID string
+}
+
+func (q *Queries) GetFeedTokenByHash(ctx context.Context, tokenHash string) (GetFeedTokenByHashRow, error) {
+	row := q.db.QueryRowContext(ctx, getFeedTokenByHash, tokenHash)
+	var i GetFeedTokenByHashRow
+	err := row.Scan(&i.ID, &i.FeedID)
+	return i, err
+}
+
+const getFeedTokens = `-- name: GetFeedTokens :many
+SELECT id, feed_id, name, token_hash, created_at, revoked_at, created_by
+FROM FeedTokens
+WHERE feed_id = ?1
+  AND revoked_at IS NULL
+  AND (?2 IS NULL OR created_by = ?2)
+ORDER BY id
+`
+
+type GetFeedTokensParams struct {
+	FeedID    string
+	CreatedBy sql.NullInt64
+}
+
+func (q *Queries) GetFeedTokens(ctx context.Context, arg GetFeedTokensParams) ([]FeedToken, error) {
+	rows, err := q.db.QueryContext(ctx, getFeedTokens, arg.FeedID, arg.CreatedBy)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []FeedToken
+	for rows.Next() {
+		var i FeedToken
+		if err := rows.Scan(
+			&i.ID,
+			&i.FeedID,
+			&i.Name,
+			&i.TokenHash,
+			&i.CreatedAt,
+			&i.RevokedAt,
+			&i.CreatedBy,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getFeedXML = `-- name: GetFeedXML :one
+SELECT xml FROM Feeds WHERE id = ?
+`
+
+func (q *Queries) GetFeedXML(ctx context.Context, id []byte) (string, error) {
+	row := q.db.QueryRowContext(ctx, getFeedXML, id)
+	var xml string
+	err := row.Scan(&xml)
+	return xml, err
+}
+
+const getFeedsForUser = `-- name: GetFeedsForUser :many
+WITH FeedData AS (
+    SELECT f.id, f.created_at, f.description, f.title, f.updated_at, f.link, f.xml
+    FROM Feeds AS f
+    JOIN UserFeeds AS uf ON uf.feed_id = CAST(f.id AS TEXT)
+    WHERE uf.user_id = ?1
+    ORDER BY uf.added_at, f.title
+    LIMIT ?2
+    OFFSET (?3 - 1) * ?2
+),
+TotalCount AS (
+    SELECT COUNT(*) AS total_rows
+    FROM UserFeeds
+    WHERE user_id = ?1
+)
+SELECT fd.id, fd.created_at, fd.description, fd.title, fd.updated_at, fd.link, fd.xml,
+       (SELECT total_rows > (?3 * ?2) FROM TotalCount) AS has_more
+FROM FeedData fd
+`
+
+type GetFeedsForUserParams struct {
+	UserID   int64
+	PageSize int64
+	PageNum  int64
+}
+
+type GetFeedsForUserRow struct {
+	ID          []byte
+	CreatedAt   sql.NullTime
+	Description sql.NullString
+	Title       string
+	UpdatedAt   sql.NullTime
+	Link        string
+	Xml         string
+	HasMore     bool
+}
+
+func (q *Queries) GetFeedsForUser(ctx context.Context, arg GetFeedsForUserParams) ([]GetFeedsForUserRow, error) {
+	rows, err := q.db.QueryContext(ctx, getFeedsForUser, arg.UserID, arg.PageSize, arg.PageNum)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetFeedsForUserRow
+	for rows.Next() {
+		var i GetFeedsForUserRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.Description,
+			&i.Title,
+			&i.UpdatedAt,
+			&i.Link,
+			&i.Xml,
+			&i.HasMore,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getMeasuredEnclosuresForFeed = `-- name: GetMeasuredEnclosuresForFeed :many
+SELECT MeasuredEnclosures.url, MeasuredEnclosures.length_bytes, MeasuredEnclosures.duration_seconds
+FROM MeasuredEnclosures
+JOIN Episodes ON Episodes.audio_url = MeasuredEnclosures.url
+    OR Episodes.video_enclosure_url = MeasuredEnclosures.url
+WHERE Episodes.feed_id = ?
+`
+
+type GetMeasuredEnclosuresForFeedRow struct {
+	Url             string
+	LengthBytes     int64
+	DurationSeconds sql.NullInt64
+}
+
+func (q *Queries) GetMeasuredEnclosuresForFeed(ctx context.Context, feedID string) ([]GetMeasuredEnclosuresForFeedRow, error) {
+	rows, err := q.db.QueryContext(ctx, getMeasuredEnclosuresForFeed, feedID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetMeasuredEnclosuresForFeedRow
+	for rows.Next() {
+		var i GetMeasuredEnclosuresForFeedRow
+		if err := rows.Scan(&i.Url, &i.LengthBytes, &i.DurationSeconds); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getNewestEpisodesForFeed = `-- name: GetNewestEpisodesForFeed :many
+SELECT id, audio_url, audio_length_bytes, description, duration, feed_id, released_at, thumbnail, title, video_url, video_enclosure_url, video_enclosure_length_bytes, video_id
+FROM Episodes
+WHERE feed_id = ?
+ORDER BY released_at DESC
+LIMIT ?
+`
+
+type GetNewestEpisodesForFeedParams struct {
+	FeedID string
+	Limit  int64
+}
+
+func (q *Queries) GetNewestEpisodesForFeed(ctx context.Context, arg GetNewestEpisodesForFeedParams) ([]Episode, error) {
+	rows, err := q.db.QueryContext(ctx, getNewestEpisodesForFeed, arg.FeedID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Episode
+	for rows.Next() {
+		var i Episode
+		if err := rows.Scan(
+			&i.ID,
+			&i.AudioUrl,
+			&i.AudioLengthBytes,
+			&i.Description,
+			&i.Duration,
+			&i.FeedID,
+			&i.ReleasedAt,
+			&i.Thumbnail,
+			&i.Title,
+			&i.VideoUrl,
+			&i.VideoEnclosureUrl,
+			&i.VideoEnclosureLengthBytes,
+			&i.VideoID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getOlderEpisodesForFeed = `-- name: GetOlderEpisodesForFeed :many
+SELECT id, audio_url, audio_length_bytes, description, duration, feed_id, released_at, thumbnail, title, video_url, video_enclosure_url, video_enclosure_length_bytes, video_id
+FROM Episodes as e
+WHERE e.feed_id = ?
+  AND e.released_at < ?
+ORDER BY released_at DESC
+`
+
+type GetOlderEpisodesForFeedParams struct {
+	FeedID     string
+	ReleasedAt sql.NullTime
+}
+
+func (q *Queries) GetOlderEpisodesForFeed(ctx context.Context, arg GetOlderEpisodesForFeedParams) ([]Episode, error) {
+	rows, err := q.db.QueryContext(ctx, getOlderEpisodesForFeed, arg.FeedID, arg.ReleasedAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Episode
+	for rows.Next() {
+		var i Episode
+		if err := rows.Scan(
+			&i.ID,
+			&i.AudioUrl,
+			&i.AudioLengthBytes,
+			&i.Description,
+			&i.Duration,
+			&i.FeedID,
+			&i.ReleasedAt,
+			&i.Thumbnail,
+			&i.Title,
+			&i.VideoUrl,
+			&i.VideoEnclosureUrl,
+			&i.VideoEnclosureLengthBytes,
+			&i.VideoID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getOldestDueRefresh = `-- name: GetOldestDueRefresh :one
+SELECT next_refresh_at
+FROM FeedSettings
+WHERE next_refresh_at <= ?
+ORDER BY next_refresh_at
+LIMIT 1
+`
+
+func (q *Queries) GetOldestDueRefresh(ctx context.Context, nextRefreshAt sql.NullTime) (sql.NullTime, error) {
+	row := q.db.QueryRowContext(ctx, getOldestDueRefresh, nextRefreshAt)
+	var next_refresh_at sql.NullTime
+	err := row.Scan(&next_refresh_at)
+	return next_refresh_at, err
+}
+
+const getPinnedVideoIds = `-- name: GetPinnedVideoIds :many
+SELECT video_id
+FROM EpisodePins
+`
+
+func (q *Queries) GetPinnedVideoIds(ctx context.Context) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, getPinnedVideoIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var video_id string
+		if err := rows.Scan(&video_id); err != nil {
+			return nil, err
+		}
+		items = append(items, video_id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRecentReleaseTimes = `-- name: GetRecentReleaseTimes :many
+SELECT released_at
+FROM Episodes
+WHERE feed_id = ?
+  AND released_at IS NOT NULL
+ORDER BY released_at DESC
+LIMIT ?
+`
+
+type GetRecentReleaseTimesParams struct {
+	FeedID string
+	Limit  int64
+}
+
+func (q *Queries) GetRecentReleaseTimes(ctx context.Context, arg GetRecentReleaseTimesParams) ([]sql.NullTime, error) {
+	rows, err := q.db.QueryContext(ctx, getRecentReleaseTimes, arg.FeedID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []sql.NullTime
+	for rows.Next() {
+		var released_at sql.NullTime
+		if err := rows.Scan(&released_at); err != nil {
+			return nil, err
+		}
+		items = append(items, released_at)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getSession = `-- name: GetSession :one
+SELECT user_id, csrf_token, expires_at
+FROM Sessions
+WHERE id_hash = ?
+`
+
+type GetSessionRow struct {
+	UserID    int64
+	CsrfToken string
+	ExpiresAt time.Time
+}
+
+func (q *Queries) GetSession(ctx context.Context, idHash string) (GetSessionRow, error) {
+	row := q.db.QueryRowContext(ctx, getSession, idHash)
+	var i GetSessionRow
+	err := row.Scan(&i.UserID, &i.CsrfToken, &i.ExpiresAt)
+	return i, err
+}
+
+const getUser = `-- name: GetUser :one
+SELECT id, username, password_hash, is_admin, created_at, is_external
+FROM Users
+WHERE id = ?
+`
+
+func (q *Queries) GetUser(ctx context.Context, id int64) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUser, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.PasswordHash,
+		&i.IsAdmin,
+		&i.CreatedAt,
+		&i.IsExternal,
+	)
+	return i, err
+}
+
+const getUserByUsername = `-- name: GetUserByUsername :one
+SELECT id, username, password_hash, is_admin, created_at, is_external
+FROM Users
+WHERE username = ?
+`
+
+func (q *Queries) GetUserByUsername(ctx context.Context, username string) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByUsername, username)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.PasswordHash,
+		&i.IsAdmin,
+		&i.CreatedAt,
+		&i.IsExternal,
+	)
+	return i, err
+}
+
+const getUserIdentity = `-- name: GetUserIdentity :one
+SELECT user_id
+FROM UserIdentities
+WHERE issuer = ?
+  AND subject = ?
+`
+
+type GetUserIdentityParams struct {
+	Issuer  string
+	Subject string
+}
+
+func (q *Queries) GetUserIdentity(ctx context.Context, arg GetUserIdentityParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, getUserIdentity, arg.Issuer, arg.Subject)
+	var user_id int64
+	err := row.Scan(&user_id)
+	return user_id, err
+}
+
+const getUsers = `-- name: GetUsers :many
+SELECT id, username, password_hash, is_admin, created_at, is_external
+FROM Users
+ORDER BY username
+`
+
+func (q *Queries) GetUsers(ctx context.Context) ([]User, error) {
+	rows, err := q.db.QueryContext(ctx, getUsers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.Username,
+			&i.PasswordHash,
+			&i.IsAdmin,
+			&i.CreatedAt,
+			&i.IsExternal,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getWebSubSubscription = `-- name: GetWebSubSubscription :one
+SELECT feed_id, topic, secret, lease_expires_at, pending_mode
+FROM WebSubSubscriptions
+WHERE feed_id = ?
+`
+
+func (q *Queries) GetWebSubSubscription(ctx context.Context, feedID string) (WebSubSubscription, error) {
+	row := q.db.QueryRowContext(ctx, getWebSubSubscription, feedID)
+	var i WebSubSubscription
+	err := row.Scan(
+		&i.FeedID,
+		&i.Topic,
+		&i.Secret,
+		&i.LeaseExpiresAt,
+		&i.PendingMode,
+	)
+	return i, err
+}
+
+const pinEpisode = `-- name: PinEpisode :exec
+INSERT OR IGNORE INTO EpisodePins (
+    video_id
+) VALUES (
+    ?
+)
+`
+
+func (q *Queries) PinEpisode(ctx context.Context, videoID string) error {
+	_, err := q.db.ExecContext(ctx, pinEpisode, videoID)
+	return err
+}
+
+const removeUserFeed = `-- name: RemoveUserFeed :exec
+DELETE FROM UserFeeds
+WHERE user_id = ?
+  AND feed_id = ?
+`
+
+type RemoveUserFeedParams struct {
+	UserID int64
+	FeedID string
+}
+
+func (q *Queries) RemoveUserFeed(ctx context.Context, arg RemoveUserFeedParams) error {
+	_, err := q.db.ExecContext(ctx, removeUserFeed, arg.UserID, arg.FeedID)
+	return err
+}
+
+const revokeFeedToken = `-- name: RevokeFeedToken :one
+UPDATE FeedTokens
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE id = ?1
+  AND feed_id = ?2
+  AND revoked_at IS NULL
+  AND (?3 IS NULL OR created_by = ?3)
+RETURNING name, created_by
+`
+
+type RevokeFeedTokenParams struct {
+	ID        int64
+	FeedID    string
+	CreatedBy sql.NullInt64
+}
+
+type RevokeFeedTokenRow struct {
+	Name      string
+	CreatedBy sql.NullInt64
+}
+
+func (q *Queries) RevokeFeedToken(ctx context.Context, arg RevokeFeedTokenParams) (RevokeFeedTokenRow, error) {
+	row := q.db.QueryRowContext(ctx, revokeFeedToken, arg.ID, arg.FeedID, arg.CreatedBy)
+	var i RevokeFeedTokenRow
+	err := row.Scan(&i.Name, &i.CreatedBy)
+	return i, err
+}
+
+const setEpisodeAudioMeasurements = `-- name: SetEpisodeAudioMeasurements :exec
+UPDATE Episodes
+SET audio_length_bytes = ?1,
+    duration = COALESCE(?2, duration)
+WHERE audio_url = ?3
+`
+
+type SetEpisodeAudioMeasurementsParams struct {
+	LengthBytes int64
+	Duration    sql.NullInt64
+	Url         string
+}
+
+func (q *Queries) SetEpisodeAudioMeasurements(ctx context.Context, arg SetEpisodeAudioMeasurementsParams) error {
+	_, err := q.db.ExecContext(ctx, setEpisodeAudioMeasurements, arg.LengthBytes, arg.Duration, arg.Url)
+	return err
+}
+
+const setEpisodeVideoMeasurements = `-- name: SetEpisodeVideoMeasurements :exec
+UPDATE Episodes
+SET video_enclosure_length_bytes = ?1,
+    duration = COALESCE(?2, duration)
+WHERE video_enclosure_url = ?3
+`
+
+type SetEpisodeVideoMeasurementsParams struct {
+	LengthBytes sql.NullInt64
+	Duration    sql.NullInt64
+	Url         sql.NullString
+}
+
+func (q *Queries) SetEpisodeVideoMeasurements(ctx context.Context, arg SetEpisodeVideoMeasurementsParams) error {
+	_, err := q.db.ExecContext(ctx, setEpisodeVideoMeasurements, arg.LengthBytes, arg.Duration, arg.Url)
+	return err
+}
+
+const setFeedMedia = `-- name: SetFeedMedia :exec
+INSERT INTO FeedSettings (
+    feed_id,
+    media
+) VALUES (
+    ?,
+    ?
+)
+ON CONFLICT (feed_id) DO UPDATE SET
+    media = excluded.media
+`
+
+type SetFeedMediaParams struct {
+	FeedID string
+	Media  string
+}
+
+func (q *Queries) SetFeedMedia(ctx context.Context, arg SetFeedMediaParams) error {
+	_, err := q.db.ExecContext(ctx, setFeedMedia, arg.FeedID, arg.Media)
+	return err
+}
+
+const setFeedPostProcess = `-- name: SetFeedPostProcess :exec
+INSERT INTO FeedSettings (
+    feed_id,
+    loudnorm,
+    mono,
+    trim_silence,
+    bitrate_kbps,
+    speed
+) VALUES (
+    ?,
+    ?,
+    ?,
+    ?,
+    ?,
+    ?
+)
+ON CONFLICT (feed_id) DO UPDATE SET
+    loudnorm = excluded.loudnorm,
+    mono = excluded.mono,
+    trim_silence = excluded.trim_silence,
+    bitrate_kbps = excluded.bitrate_kbps,
+    speed = excluded.speed
+`
+
+type SetFeedPostProcessParams struct {
+	FeedID      string
+	Loudnorm    bool
+	Mono        bool
+	TrimSilence bool
+	BitrateKbps int64
+	Speed       float64
+}
+
+func (q *Queries) SetFeedPostProcess(ctx context.Context, arg SetFeedPostProcessParams) error {
+	_, err := q.db.ExecContext(ctx, setFeedPostProcess,
+		arg.FeedID,
+		arg.Loudnorm,
+		arg.Mono,
+		arg.TrimSilence,
+		arg.BitrateKbps,
+		arg.Speed,
+	)
+	return err
+}
+
+const setFeedPrefetchCount = `-- name: SetFeedPrefetchCount :exec
+INSERT INTO FeedSettings (
+    feed_id,
+    prefetch_count
+) VALUES (
+    ?,
+    ?
+)
+ON CONFLICT (feed_id) DO UPDATE SET
+    prefetch_count = excluded.prefetch_count
+`
+
+type SetFeedPrefetchCountParams struct {
+	FeedID        string
+	PrefetchCount int64
+}
+
+func (q *Queries) SetFeedPrefetchCount(ctx context.Context, arg SetFeedPrefetchCountParams) error {
+	_, err := q.db.ExecContext(ctx, setFeedPrefetchCount, arg.FeedID, arg.PrefetchCount)
+	return err
+}
+
+const setFeedPrivate = `-- name: SetFeedPrivate :exec
+INSERT INTO FeedSettings (
+    feed_id,
+    private
+) VALUES (
+    ?,
+    ?
+)
+ON CONFLICT (feed_id) DO UPDATE SET
+    private = excluded.private
+`
+
+type SetFeedPrivateParams struct {
+	FeedID  string
+	Private bool
+}
+
+func (q *Queries) SetFeedPrivate(ctx context.Context, arg SetFeedPrivateParams) error {
+	_, err := q.db.ExecContext(ctx, setFeedPrivate, arg.FeedID, arg.Private)
+	return err
+}
+
+const setFeedRefreshed = `-- name: SetFeedRefreshed :exec
+INSERT INTO FeedSettings (
+    feed_id,
+    last_refreshed_at,
+    next_refresh_at
+) VALUES (
+    ?,
+    ?,
+    ?
+)
+ON CONFLICT (feed_id) DO UPDATE SET
+    last_refreshed_at = excluded.last_refreshed_at,
+    next_refresh_at = excluded.next_refresh_at
+`
+
+type SetFeedRefreshedParams struct {
+	FeedID          string
+	LastRefreshedAt sql.NullTime
+	NextRefreshAt   sql.NullTime
+}
+
+func (q *Queries) SetFeedRefreshed(ctx context.Context, arg SetFeedRefreshedParams) error {
+	_, err := q.db.ExecContext(ctx, setFeedRefreshed, arg.FeedID, arg.LastRefreshedAt, arg.NextRefreshAt)
+	return err
+}
+
+const setFeedSponsorBlock = `-- name: SetFeedSponsorBlock :exec
+INSERT INTO FeedSettings (
+    feed_id,
+    sponsorblock_remove,
+    sponsorblock_mark
+) VALUES (
+    ?,
+    ?,
+    ?
+)
+ON CONFLICT (feed_id) DO UPDATE SET
+    sponsorblock_remove = excluded.sponsorblock_remove,
+    sponsorblock_mark = excluded.sponsorblock_mark
+`
+
+type SetFeedSponsorBlockParams struct {
+	FeedID             string
+	SponsorblockRemove sql.NullString
+	SponsorblockMark   sql.NullString
+}
+
+func (q *Queries) SetFeedSponsorBlock(ctx context.Context, arg SetFeedSponsorBlockParams) error {
+	_, err := q.db.ExecContext(ctx, setFeedSponsorBlock, arg.FeedID, arg.SponsorblockRemove, arg.SponsorblockMark)
+	return err
+}
+
+const setUserAdmin = `-- name: SetUserAdmin :exec
+UPDATE Users
+SET is_admin = ?
+WHERE id = ?
+`
+
+type SetUserAdminParams struct {
+	IsAdmin bool
+	ID      int64
+}
+
+func (q *Queries) SetUserAdmin(ctx context.Context, arg SetUserAdminParams) error {
+	_, err := q.db.ExecContext(ctx, setUserAdmin, arg.IsAdmin, arg.ID)
+	return err
+}
+
+const setUserPassword = `-- name: SetUserPassword :exec
+UPDATE Users
+SET password_hash = ?
+WHERE id = ?
+`
+
+type SetUserPasswordParams struct {
+	PasswordHash string
+	ID           int64
+}
+
+func (q *Queries) SetUserPassword(ctx context.Context, arg SetUserPasswordParams) error {
+	_, err := q.db.ExecContext(ctx, setUserPassword, arg.PasswordHash, arg.ID)
+	return err
+}
+
+const setWebSubLease = `-- name: SetWebSubLease :exec
+UPDATE WebSubSubscriptions
+SET lease_expires_at = ?,
+    pending_mode = NULL
+WHERE feed_id = ?
+`
+
+type SetWebSubLeaseParams struct {
+	LeaseExpiresAt sql.NullTime
+	FeedID         string
+}
+
+func (q *Queries) SetWebSubLease(ctx context.Context, arg SetWebSubLeaseParams) error {
+	_, err := q.db.ExecContext(ctx, setWebSubLease, arg.LeaseExpiresAt, arg.FeedID)
+	return err
+}
+
+const touchAudioFile = `-- name: TouchAudioFile :exec
+UPDATE AudioFiles
+SET last_accessed_at = ?
+WHERE path = ?
+`
+
+type TouchAudioFileParams struct {
+	LastAccessedAt sql.NullTime
+	Path           string
+}
+
+func (q *Queries) TouchAudioFile(ctx context.Context, arg TouchAudioFileParams) error {
+	_, err := q.db.ExecContext(ctx, touchAudioFile, arg.LastAccessedAt, arg.Path)
+	return err
+}
+
+const unpinEpisode = `-- name: UnpinEpisode :exec
+DELETE FROM EpisodePins
+WHERE video_id = ?
+`
+
+func (q *Queries) UnpinEpisode(ctx context.Context, videoID string) error {
+	_, err := q.db.ExecContext(ctx, unpinEpisode, videoID)
+	return err
+}
+
+const upsertAudioFile = `-- name: UpsertAudioFile :exec
+INSERT INTO AudioFiles (
+    path,
+    video_id,
+    format_id,
+    size_bytes,
+    last_accessed_at
+) VALUES (
+    ?,
+    ?,
+    ?,
+    ?,
+    ?
+)
+ON CONFLICT (path) DO UPDATE SET
+    size_bytes = excluded.size_bytes,
+    last_accessed_at = excluded.last_accessed_at
+`
+
+type UpsertAudioFileParams struct {
+	Path           string
+	VideoID        string
+	FormatID       string
+	SizeBytes      int64
+	LastAccessedAt sql.NullTime
+}
+
+func (q *Queries) UpsertAudioFile(ctx context.Context, arg UpsertAudioFileParams) error {
+	_, err := q.db.ExecContext(ctx, upsertAudioFile,
+		arg.Path,
+		arg.VideoID,
+		arg.FormatID,
+		arg.SizeBytes,
+		arg.LastAccessedAt,
+	)
+	return err
+}
+
+const upsertCookieJar = `-- name: UpsertCookieJar :exec
+INSERT INTO CookieJars (
+    feed_id,
+    nonce,
+    ciphertext,
+    updated_at
+) VALUES (
+    ?,
+    ?,
+    ?,
+    CURRENT_TIMESTAMP
+)
+ON CONFLICT (feed_id) DO UPDATE SET
+    nonce = excluded.nonce,
+    ciphertext = excluded.ciphertext,
+    updated_at = excluded.updated_at
+`
+
+type UpsertCookieJarParams struct {
+	FeedID     string
+	Nonce      []byte
+	Ciphertext []byte
+}
+
+func (q *Queries) UpsertCookieJar(ctx context.Context, arg UpsertCookieJarParams) error {
+	_, err := q.db.ExecContext(ctx, upsertCookieJar, arg.FeedID, arg.Nonce, arg.Ciphertext)
+	return err
+}
+
+const upsertEpisode = `-- name: UpsertEpisode :exec
+INSERT OR REPLACE INTO Episodes (
+    id,
+    audio_url,
     audio_length_bytes,
     description,
     duration,
@@ -226,7 +1755,10 @@ INSERT OR REPLACE INTO Episodes (
     released_at,
     thumbnail,
     title,
-    video_url
+    video_url,
+    video_enclosure_url,
+    video_enclosure_length_bytes,
+    video_id
 ) VALUES (
     ?,
     ?,
@@ -237,21 +1769,27 @@ INSERT OR REPLACE INTO Episodes (
     ?,
     ?,
     ?,
+    ?,
+    ?,
+    ?,
     ?
 )
 `
 
 type UpsertEpisodeParams struct {
-	ID               []byte
-	AudioUrl         string
-	AudioLengthBytes int64
-	Description      sql.NullString
-	Duration         sql.NullInt64
-	FeedID           string
-	ReleasedAt       sql.NullTime
-	Thumbnail        sql.NullString
-	Title            string
-	VideoUrl         sql.NullString
+	ID                        []byte
+	AudioUrl                  string
+	AudioLengthBytes          int64
+	Description               sql.NullString
+	Duration                  sql.NullInt64
+	FeedID                    string
+	ReleasedAt                sql.NullTime
+	Thumbnail                 sql.NullString
+	Title                     string
+	VideoUrl                  sql.NullString
+	VideoEnclosureUrl         sql.NullString
+	VideoEnclosureLengthBytes sql.NullInt64
+	VideoID                   string
 }
 
 func (q *Queries) UpsertEpisode(ctx context.Context, arg UpsertEpisodeParams) error {
@@ -266,6 +1804,9 @@ func (q *Queries) UpsertEpisode(ctx context.Context, arg UpsertEpisodeParams) er
 		arg.Thumbnail,
 		arg.Title,
 		arg.VideoUrl,
+		arg.VideoEnclosureUrl,
+		arg.VideoEnclosureLengthBytes,
+		arg.VideoID,
 	)
 	return err
 }
@@ -310,3 +1851,139 @@ func (q *Queries) UpsertFeed(ctx context.Context, arg UpsertFeedParams) error {
 	)
 	return err
 }
+
+const upsertFeedSchedule = `-- name: UpsertFeedSchedule :exec
+INSERT INTO FeedSettings (
+    feed_id,
+    refresh_mode,
+    refresh_interval_seconds,
+    next_refresh_at
+) VALUES (
+    ?,
+    ?,
+    ?,
+    NULL
+)
+ON CONFLICT (feed_id) DO UPDATE SET
+    refresh_mode = excluded.refresh_mode,
+    refresh_interval_seconds = excluded.refresh_interval_seconds,
+    next_refresh_at = NULL
+`
+
+type UpsertFeedScheduleParams struct {
+	FeedID                 string
+	RefreshMode            string
+	RefreshIntervalSeconds sql.NullInt64
+}
+
+func (q *Queries) UpsertFeedSchedule(ctx context.Context, arg UpsertFeedScheduleParams) error {
+	_, err := q.db.ExecContext(ctx, upsertFeedSchedule, arg.FeedID, arg.RefreshMode, arg.RefreshIntervalSeconds)
+	return err
+}
+
+const upsertMeasuredEnclosure = `-- name: UpsertMeasuredEnclosure :exec
+INSERT INTO MeasuredEnclosures (
+    url,
+    length_bytes,
+    duration_seconds,
+    measured_at
+) VALUES (
+    ?,
+    ?,
+    ?,
+    CURRENT_TIMESTAMP
+)
+ON CONFLICT (url) DO UPDATE SET
+    length_bytes = excluded.length_bytes,
+    duration_seconds = excluded.duration_seconds,
+    measured_at = excluded.measured_at
+`
+
+type UpsertMeasuredEnclosureParams struct {
+	Url             string
+	LengthBytes     int64
+	DurationSeconds sql.NullInt64
+}
+
+func (q *Queries) UpsertMeasuredEnclosure(ctx context.Context, arg UpsertMeasuredEnclosureParams) error {
+	_, err := q.db.ExecContext(ctx, upsertMeasuredEnclosure, arg.Url, arg.LengthBytes, arg.DurationSeconds)
+	return err
+}
+
+const upsertWebSubSubscription = `-- name: UpsertWebSubSubscription :exec
+INSERT INTO WebSubSubscriptions (
+    feed_id,
+    topic,
+    secret,
+    pending_mode
+) VALUES (
+    ?,
+    ?,
+    ?,
+    ?
+)
+ON CONFLICT (feed_id) DO UPDATE SET
+    topic = excluded.topic,
+    secret = excluded.secret,
+    pending_mode = excluded.pending_mode
+`
+
+type UpsertWebSubSubscriptionParams struct {
+	FeedID      string
+	Topic       string
+	Secret      string
+	PendingMode sql.NullString
+}
+
+func (q *Queries) UpsertWebSubSubscription(ctx context.Context, arg UpsertWebSubSubscriptionParams) error {
+	_, err := q.db.ExecContext(ctx, upsertWebSubSubscription,
+		arg.FeedID,
+		arg.Topic,
+		arg.Secret,
+		arg.PendingMode,
+	)
+	return err
+}
+
+const userHasFeed = `-- name: UserHasFeed :one
+SELECT EXISTS (
+    SELECT 1
+    FROM UserFeeds
+    WHERE user_id = ?
+      AND feed_id = ?
+)
+`
+
+type UserHasFeedParams struct {
+	UserID int64
+	FeedID string
+}
+
+func (q *Queries) UserHasFeed(ctx context.Context, arg UserHasFeedParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, userHasFeed, arg.UserID, arg.FeedID)
+	var column_1 int64
+	err := row.Scan(&column_1)
+	return column_1, err
+}
+
+const userHasVideo = `-- name: UserHasVideo :one
+SELECT EXISTS (
+    SELECT 1
+    FROM UserFeeds
+    JOIN Episodes ON Episodes.feed_id = UserFeeds.feed_id
+    WHERE UserFeeds.user_id = ?
+      AND Episodes.video_id = ?
+)
+`
+
+type UserHasVideoParams struct {
+	UserID  int64
+	VideoID string
+}
+
+func (q *Queries) UserHasVideo(ctx context.Context, arg UserHasVideoParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, userHasVideo, arg.UserID, arg.VideoID)
+	var column_1 int64
+	err := row.Scan(&column_1)
+	return column_1, err
+}
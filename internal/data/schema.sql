@@ -23,3 +23,30 @@ CREATE TABLE IF NOT EXISTS Episodes (
     video_url TEXT,
     FOREIGN KEY (feed_id) REFERENCES Feeds(id)
 );
+CREATE TABLE IF NOT EXISTS FeedSettings (
+    feed_id TEXT PRIMARY KEY NOT NULL UNIQUE,
+    refresh_mode TEXT NOT NULL DEFAULT 'fixed',
+    refresh_interval_seconds INTEGER,
+    last_refreshed_at TIMESTAMP,
+    next_refresh_at TIMESTAMP,
+    FOREIGN KEY (feed_id) REFERENCES Feeds(id)
+);
+CREATE TABLE IF NOT EXISTS WebSubSubscriptions (
+    feed_id TEXT PRIMARY KEY NOT NULL UNIQUE,
+    topic TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    lease_expires_at TIMESTAMP,
+    FOREIGN KEY (feed_id) REFERENCES Feeds(id)
+);
+CREATE TABLE IF NOT EXISTS AudioFiles (
+    path TEXT PRIMARY KEY NOT NULL UNIQUE,
+    video_id TEXT NOT NULL,
+    format_id TEXT NOT NULL,
+    size_bytes INTEGER NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_accessed_at TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS EpisodePins (
+    video_id TEXT PRIMARY KEY NOT NULL UNIQUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -1,85 +1,82 @@
 package handlers
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"log/slog"
 	"mime"
 	"net/http"
-	"os"
-	"os/exec"
-	"strings"
+	"vpod/internal/audio"
 	"vpod/internal/podcast"
+	"vpod/internal/signing"
+	"vpod/internal/tokens"
 )
 
-type AudioMetadata struct {
-	FormatId string
-	VideoId  string
-}
-
-func Audio() http.HandlerFunc {
+// Audio serves the audio of episodes, downloading it first if it isn't
+// cached. Only episodes of feeds the request may read are served.
+func Audio(downloader *audio.Downloader, access *tokens.Store, signer *signing.Signer) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		logger := r.Context().Value("logger").(*slog.Logger)
-		audioPart := strings.TrimPrefix(r.URL.Path, "/audio/")
-		audioParts := strings.Split(audioPart, "/") // TODO: look into SplitSeq for performance
-		m := AudioMetadata{
-			FormatId: audioParts[1],
-			VideoId:  audioParts[0],
+		ctx := r.Context()
+		logger := ctx.Value("logger").(*slog.Logger)
+		m, err := audio.NewMetadata(r.PathValue("videoID"), r.PathValue("formatID"), false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
 		logger = logger.With(slog.String("audio_metadata", fmt.Sprintf("%+v", m)))
-		audioFilename, err := getAudio(m, logger)
+		feedID, ok := canServe(w, r, access, signer, "audio", m, logger)
+		if !ok {
+			return
+		}
+		m.FeedId = feedID
+		audioFilename, err := downloader.Get(ctx, m, logger)
 		if err != nil {
 			logger.Error("Failed to get audio")
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		} else {
 			mime.AddExtensionType(".m4a", podcast.M4A.String())
-			http.ServeFile(w, r, *audioFilename)
+			http.ServeFile(w, r, audioFilename)
 		}
 	}
 }
 
-func getAudio(m AudioMetadata, logger *slog.Logger) (*string, error) {
-	// Serve up video quickly if it already exists
-	// TODO: make configurable? This could fetch old video versions sometimes
-	filename := fmt.Sprintf("%s.m4a", m.VideoId)
-	fileInfo, err := os.Stat(filename)
-	if err == nil {
-		isNonEmpty := fileInfo.Size() != 0
-		isAFile := !fileInfo.IsDir()
-		if isNonEmpty && isAFile {
-			return &filename, nil
+// canServe checks the request may download a video before anything is
+// fetched from YouTube, writing an error response if not, and returns the
+// feed it's served for. That's the token's feed, or the one in the feed
+// query parameter. Requests through a feed token are authorized by it,
+// others need a signed URL when signing is on. Only the format in the
+// episode's enclosure is served, never another stream or a selector like
+// bestvideo.
+func canServe(
+	w http.ResponseWriter,
+	r *http.Request,
+	access *tokens.Store,
+	signer *signing.Signer,
+	kind string,
+	m audio.Metadata,
+	logger *slog.Logger,
+) (string, bool) {
+	if _, ok := tokens.FromContext(r.Context()); !ok {
+		err := signer.Verify(kind, m.VideoId, m.FormatId, r.URL.Query())
+		if errors.Is(err, signing.ErrExpired) {
+			http.Error(w, err.Error(), http.StatusGone)
+			return "", false
+		} else if err != nil {
+			logger.With(slog.String("err", err.Error())).Warn("Rejected enclosure url")
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return "", false
 		}
 	}
 
-	youtubeUrl := fmt.Sprintf("https://www.youtube.com/watch?v=%s", m.VideoId)
-	logger = logger.With(slog.String("video_url", youtubeUrl))
-
-	cmd := exec.Command(
-		"yt-dlp",
-		fmt.Sprintf("--format=%s", m.FormatId),
-		"--embed-metadata",
-		"--embed-thumbnail",
-		"--sponsorblock-remove=sponsor",
-		"--output=%(id)s.m4a",
-		youtubeUrl,
-	)
-	var errb bytes.Buffer
-	cmd.Stderr = &errb
-	logger = logger.With(slog.String("yt_dlp_command", fmt.Sprintf("%v", cmd.Args)))
-
-	logger.Info("getting audio")
-	err = cmd.Run()
+	feedID, ok, err := access.EnclosureFeed(r.Context(), kind, m.VideoId, m.FormatId, r.URL.Query().Get("feed"))
 	if err != nil {
-		var exitError *exec.ExitError
-		if errors.As(err, &exitError) {
-			logger = logger.With(slog.String("stderr", errb.String()))
-		}
-		logger.Error("failed to download audio from youtube",
-			slog.String("err", err.Error()),
-		)
-		return nil, err
+		logger.With(slog.String("err", err.Error())).Error("Could not check episode access")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return "", false
 	}
-
-	return &filename, nil
+	if !ok {
+		http.Error(w, "Episode not found", http.StatusNotFound)
+		return "", false
+	}
+	return feedID, true
 }
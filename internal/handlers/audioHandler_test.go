@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"vpod/internal/audio"
+	"vpod/internal/data"
+	"vpod/internal/tokens"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func FuzzAudio(f *testing.F) {
+	for _, seed := range []string{
+		"dQw4w9WgXcQ/140",
+		"dQw4w9WgXcQ/251-drc",
+		"foo",
+		"dQw4w9WgXcQ/",
+		"dQw4w9WgXcQ/bestaudio%2Fbest",
+		"dQw4w9WgXcQ/137+140",
+		"..%2F..%2Fetc/passwd",
+		"dQw4w9WgXcQ/140/extra",
+		"%00%00%00%00%00%00%00%00%00%00%00/140",
+	} {
+		f.Add(seed)
+	}
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		f.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	f.Cleanup(func() { db.Close() })
+	if err := data.Migrate(context.Background(), db); err != nil {
+		f.Fatal(err)
+	}
+	queries := data.New(db)
+
+	logger := slog.New(slog.DiscardHandler)
+	downloader, err := audio.New(logger, queries, audio.Config{
+		Dir:                    f.TempDir(),
+		MaxConcurrentDownloads: 1,
+	})
+	if err != nil {
+		f.Fatal(err)
+	}
+	baseURL, _ := url.Parse("https://vpod.local")
+	// No feed has any episodes, so nothing valid is ever downloaded
+	access := tokens.New(db, queries, *baseURL)
+
+	var videoID, formatID string
+	var matched bool
+	audioHandler := Audio(downloader, access, nil)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /audio/{videoID}/{formatID}", func(w http.ResponseWriter, r *http.Request) {
+		matched = true
+		videoID, formatID = r.PathValue("videoID"), r.PathValue("formatID")
+		audioHandler(w, r.WithContext(context.WithValue(r.Context(), "logger", logger)))
+	})
+
+	f.Fuzz(func(t *testing.T, path string) {
+		matched = false
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.URL.Path = "/audio/" + path
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+
+		if !matched {
+			return
+		}
+		_, err := audio.NewMetadata(videoID, formatID, false)
+		if err != nil && w.Code != http.StatusBadRequest {
+			t.Errorf("ids %q %q are invalid but got %d, want %d", videoID, formatID, w.Code, http.StatusBadRequest)
+		}
+		if err == nil && w.Code != http.StatusNotFound {
+			t.Errorf("ids %q %q are valid but got %d, want %d for an unknown episode", videoID, formatID, w.Code, http.StatusNotFound)
+		}
+	})
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"vpod/internal/accounts"
+	"vpod/internal/data"
+	"vpod/internal/scheduledjobs"
+)
+
+// CacheReport shows which audio files the culler would remove if it ran now.
+func CacheReport(culler *scheduledjobs.Culler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := ctx.Value("logger").(*slog.Logger)
+
+		report, err := culler.Plan(ctx)
+		if err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Could not plan audio culling")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// PinEpisode protects an episode's audio from being culled, or removes that
+// protection when pinned is false. Users can only pin episodes of feeds in
+// their library.
+func PinEpisode(queries *data.Queries, users *accounts.Store, pinned bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := ctx.Value("logger").(*slog.Logger)
+
+		videoID := r.PathValue("videoID")
+		logger = logger.With(slog.String("video_id", videoID), slog.Bool("pinned", pinned))
+
+		u, ok := accounts.FromContext(ctx)
+		if !ok {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if !u.IsAdmin {
+			has, err := users.HasVideo(ctx, u.ID, videoID)
+			if err != nil {
+				logger.With(slog.String("err", err.Error())).Error("Could not check user's episodes")
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			if !has {
+				http.Error(w, "Episode not found", http.StatusNotFound)
+				return
+			}
+		}
+
+		var err error
+		if pinned {
+			err = queries.PinEpisode(ctx, videoID)
+		} else {
+			err = queries.UnpinEpisode(ctx, videoID)
+		}
+		if err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Could not update episode pin")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("updated episode pin")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
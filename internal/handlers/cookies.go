@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"vpod/internal/cookies"
+)
+
+// maxCookieJarBytes is far more than any browser's cookies for YouTube.
+const maxCookieJarBytes = 1 << 20
+
+// readCookieJar gets a cookies.txt file from either a "cookies" file in a
+// multipart form, for the UI, or the request body, for scripts.
+func readCookieJar(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxCookieJarBytes)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return io.ReadAll(r.Body)
+	}
+
+	f, _, err := r.FormFile("cookies")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// PutCookies stores a cookie jar for the feed in the path, or the global jar
+// used by feeds without one when there is no feed in the path.
+func PutCookies(jars *cookies.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := ctx.Value("logger").(*slog.Logger)
+
+		if jars == nil {
+			http.Error(w, "Cookies are disabled, set a cookie key to enable them", http.StatusNotImplemented)
+			return
+		}
+
+		feedID := r.PathValue("feedID")
+		logger = logger.With(slog.String("feed_id", feedID))
+
+		jar, err := readCookieJar(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = jars.Put(ctx, feedID, jar)
+		if errors.Is(err, cookies.ErrInvalidJar) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Could not save cookie jar")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		// Never log the jar itself
+		logger.Info("updated cookie jar")
+		w.Write([]byte("Cookies saved"))
+	}
+}
+
+// DeleteCookies removes the cookie jar for the feed in the path, or the
+// global jar.
+func DeleteCookies(jars *cookies.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := ctx.Value("logger").(*slog.Logger)
+
+		if jars == nil {
+			http.Error(w, "Cookies are disabled, set a cookie key to enable them", http.StatusNotImplemented)
+			return
+		}
+
+		feedID := r.PathValue("feedID")
+		logger = logger.With(slog.String("feed_id", feedID))
+
+		if err := jars.Delete(ctx, feedID); err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Could not delete cookie jar")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("deleted cookie jar")
+		w.Write([]byte("Cookies deleted"))
+	}
+}
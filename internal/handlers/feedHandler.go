@@ -7,9 +7,14 @@ import (
 	"net/http"
 	"strings"
 	"vpod/internal/data"
+	"vpod/internal/signing"
+	"vpod/internal/tokens"
 )
 
-func Feed(queries *data.Queries) http.HandlerFunc {
+// Feed serves a stored feed. Private feeds are only served through a
+// subscriber's token, with the enclosures rewritten to carry it. Enclosures
+// are signed afresh on every request.
+func Feed(queries *data.Queries, access *tokens.Store, signer *signing.Signer) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		logger := ctx.Value("logger").(*slog.Logger)
@@ -17,6 +22,18 @@ func Feed(queries *data.Queries) http.HandlerFunc {
 		feedId := strings.TrimPrefix(r.URL.Path, "/feed/")
 		logger = logger.With(slog.String("feed_id", feedId))
 
+		ok, err := access.CanRead(ctx, feedId)
+		if err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Could not check feed access")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			// Private feeds look the same as missing ones without a token
+			http.Error(w, "Feed not found, please generate it.", http.StatusNotFound)
+			return
+		}
+
 		logger.Info("Getting feed from DB")
 		xml, err := queries.GetFeedXML(ctx, []byte(feedId))
 
@@ -29,7 +46,7 @@ func Feed(queries *data.Queries) http.HandlerFunc {
 		} else {
 			logger.Debug("Feed found in DB")
 			w.Header().Set("Content-Type", "application/xml")
-			w.Write([]byte(xml))
+			w.Write([]byte(signer.SignFeed(access.Rewrite(ctx, feedId, xml))))
 		}
 	}
 }
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"vpod/internal/data"
+	"vpod/internal/podcast"
+)
+
+// SetFeedMedia sets whether a feed's items use their audio or their video
+// as the main enclosure. The form takes a "media" of either "audio" or
+// "video". The feed is rebuilt straight away so apps see the change.
+func SetFeedMedia(queries *data.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := ctx.Value("logger").(*slog.Logger)
+
+		feedID := r.PathValue("feedID")
+		logger = logger.With(slog.String("feed_id", feedID))
+
+		if err := r.ParseForm(); err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Could not parse form data")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		media, err := podcast.ParseMedia(r.FormValue("media"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		_, err = queries.GetFeedXML(ctx, []byte(feedID))
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Feed not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Something went wrong when fetching feed.")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		err = queries.SetFeedMedia(ctx, data.SetFeedMediaParams{
+			FeedID: feedID,
+			Media:  string(media),
+		})
+		if err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Could not save feed media")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		p, err := podcast.Rebuild(ctx, queries, feedID, podcast.WithMedia(media))
+		if err == nil {
+			err = podcast.UpsertPodcast(queries, *p, ctx)
+		}
+		if err != nil {
+			// The setting is saved, the next refresh will pick it up
+			logger.With(slog.String("err", err.Error())).Error("Could not rebuild feed")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("updated feed media", slog.String("media", string(media)))
+		w.Write([]byte("Feed media updated"))
+	}
+}
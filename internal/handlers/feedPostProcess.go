@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"vpod/internal/audio"
+	"vpod/internal/data"
+)
+
+// SetFeedPostProcess sets how ffmpeg processes a feed's audio after it is
+// downloaded. The form takes "loudnorm", "mono" and "trim_silence" as
+// checkboxes, an optional "bitrate" in kbps, and an optional "speed" such
+// as "1.5". Leaving everything out turns post-processing off.
+func SetFeedPostProcess(queries *data.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := ctx.Value("logger").(*slog.Logger)
+
+		feedID := r.PathValue("feedID")
+		logger = logger.With(slog.String("feed_id", feedID))
+
+		if err := r.ParseForm(); err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Could not parse form data")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		pp := audio.PostProcess{Speed: 1}
+		for name, v := range map[string]*bool{
+			"loudnorm":     &pp.Loudnorm,
+			"mono":         &pp.Mono,
+			"trim_silence": &pp.TrimSilence,
+		} {
+			*v = r.FormValue(name) != ""
+		}
+		if v := r.FormValue("bitrate"); v != "" {
+			bitrate, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid bitrate %q: must be a number of kbps", v), http.StatusBadRequest)
+				return
+			}
+			pp.BitrateKbps = bitrate
+		}
+		if v := r.FormValue("speed"); v != "" {
+			speed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid speed %q: must be a number", v), http.StatusBadRequest)
+				return
+			}
+			pp.Speed = speed
+		}
+		if err := pp.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		_, err := queries.GetFeedXML(ctx, []byte(feedID))
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Feed not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Something went wrong when fetching feed.")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if err := queries.SetFeedPostProcess(ctx, pp.Params(feedID)); err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Could not save feed post-processing settings")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("updated feed post-processing settings", slog.String("settings", fmt.Sprintf("%+v", pp)))
+		w.Write([]byte("Post-processing settings updated"))
+	}
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"vpod/internal/data"
+)
+
+// SetFeedPrefetch sets how many of a feed's newest episodes are downloaded
+// as soon as they are found. The form takes a "count", where 0 turns
+// prefetching off.
+func SetFeedPrefetch(queries *data.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := ctx.Value("logger").(*slog.Logger)
+
+		feedID := r.PathValue("feedID")
+		logger = logger.With(slog.String("feed_id", feedID))
+
+		if err := r.ParseForm(); err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Could not parse form data")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		count, err := strconv.ParseUint(r.FormValue("count"), 10, 8)
+		if err != nil {
+			http.Error(w, "count must be a number between 0 and 255", http.StatusBadRequest)
+			return
+		}
+
+		_, err = queries.GetFeedXML(ctx, []byte(feedID))
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Feed not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Something went wrong when fetching feed.")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		err = queries.SetFeedPrefetchCount(ctx, data.SetFeedPrefetchCountParams{
+			FeedID:        feedID,
+			PrefetchCount: int64(count),
+		})
+		if err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Could not save feed prefetch count")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("updated feed prefetch count", slog.Uint64("count", count))
+		w.Write([]byte("Prefetch updated"))
+	}
+}
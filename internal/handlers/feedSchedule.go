@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+	"vpod/internal/data"
+	"vpod/internal/scheduledjobs"
+)
+
+// SetFeedSchedule sets how often a feed is refreshed. The form takes a
+// "mode" of either "fixed" or "adaptive", and an optional "interval" such as
+// "30m". An empty interval falls back to the server-wide default.
+func SetFeedSchedule(queries *data.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := ctx.Value("logger").(*slog.Logger)
+
+		feedID := r.PathValue("feedID")
+		logger = logger.With(slog.String("feed_id", feedID))
+
+		if err := r.ParseForm(); err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Could not parse form data")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		mode, err := scheduledjobs.ParseRefreshMode(r.FormValue("mode"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var interval sql.NullInt64
+		if v := r.FormValue("interval"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < time.Minute {
+				http.Error(w, fmt.Sprintf("invalid interval %q: must be a duration of at least 1m", v), http.StatusBadRequest)
+				return
+			}
+			interval = sql.NullInt64{Int64: int64(d.Seconds()), Valid: true}
+		}
+
+		_, err = queries.GetFeedXML(ctx, []byte(feedID))
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Feed not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Something went wrong when fetching feed.")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		err = queries.UpsertFeedSchedule(ctx, data.UpsertFeedScheduleParams{
+			FeedID:                 feedID,
+			RefreshMode:            string(mode),
+			RefreshIntervalSeconds: interval,
+		})
+		if err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Could not save feed schedule")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("updated feed schedule", slog.String("mode", string(mode)))
+		w.Write([]byte("Refresh schedule updated"))
+	}
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"vpod/internal/audio"
+	"vpod/internal/data"
+)
+
+// SetFeedSponsorBlock sets what happens to each category of SponsorBlock
+// segments in a feed's audio. The form takes a field per category, such as
+// "intro", set to "remove", "mark" or "ignore". Categories left out are
+// ignored, so an empty form turns SponsorBlock off for the feed. Fields that
+// aren't categories are skipped.
+func SetFeedSponsorBlock(queries *data.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := ctx.Value("logger").(*slog.Logger)
+
+		feedID := r.PathValue("feedID")
+		logger = logger.With(slog.String("feed_id", feedID))
+
+		if err := r.ParseForm(); err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Could not parse form data")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		actions := make(map[string]audio.SponsorBlockAction)
+		// Other fields, like the CSRF token, aren't categories
+		for _, category := range audio.SponsorBlockCategories {
+			action, err := audio.ParseSponsorBlockAction(r.PostForm.Get(category))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			actions[category] = action
+		}
+		sb, err := audio.NewSponsorBlock(actions)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		_, err = queries.GetFeedXML(ctx, []byte(feedID))
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Feed not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Something went wrong when fetching feed.")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if err := queries.SetFeedSponsorBlock(ctx, sb.Params(feedID)); err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Could not save feed sponsorblock settings")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("updated feed sponsorblock settings",
+			slog.Any("remove", sb.Remove),
+			slog.Any("mark", sb.Mark),
+		)
+		w.Write([]byte("SponsorBlock settings updated"))
+	}
+}
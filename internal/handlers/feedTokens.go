@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+	"vpod/internal/accounts"
+	"vpod/internal/data"
+	"vpod/internal/tokens"
+)
+
+// SetFeedPrivate sets whether a feed can only be read with a subscriber
+// token. The form takes "private" as a checkbox.
+func SetFeedPrivate(queries *data.Queries) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := ctx.Value("logger").(*slog.Logger)
+
+		feedID := r.PathValue("feedID")
+		logger = logger.With(slog.String("feed_id", feedID))
+
+		if err := r.ParseForm(); err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Could not parse form data")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		private := r.FormValue("private") != ""
+
+		_, err := queries.GetFeedXML(ctx, []byte(feedID))
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Feed not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Something went wrong when fetching feed.")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		err = queries.SetFeedPrivate(ctx, data.SetFeedPrivateParams{
+			FeedID:  feedID,
+			Private: private,
+		})
+		if err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Could not save feed privacy")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("updated feed privacy", slog.Bool("private", private))
+		w.Write([]byte("Feed privacy updated"))
+	}
+}
+
+// tokenScope is whose tokens a user can manage: their own, or any for
+// admins.
+func tokenScope(u data.User) int64 {
+	if u.IsAdmin {
+		return 0
+	}
+	return u.ID
+}
+
+type FeedTokenEntry struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	// URL is only set when the token is issued, it can't be shown again
+	URL string `json:"url,omitempty"`
+}
+
+// GetFeedTokens lists the subscriber tokens of a feed that haven't been
+// revoked. Users only see the ones they issued, admins see them all.
+func GetFeedTokens(access *tokens.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := ctx.Value("logger").(*slog.Logger)
+
+		u, ok := accounts.FromContext(ctx)
+		if !ok {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		feedID := r.PathValue("feedID")
+		logger = logger.With(slog.String("feed_id", feedID))
+
+		rows, err := access.List(ctx, feedID, tokenScope(u))
+		if err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Could not get feed tokens")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		entries := make([]FeedTokenEntry, 0, len(rows))
+		for _, row := range rows {
+			entries = append(entries, FeedTokenEntry{
+				ID:        row.ID,
+				Name:      row.Name,
+				CreatedAt: row.CreatedAt.Time,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	}
+}
+
+// CreateFeedToken issues a subscriber token for a feed. The form takes a
+// "name" to tell subscribers apart. The response has the subscriber's
+// secret feed URL, which is only shown this once.
+func CreateFeedToken(queries *data.Queries, access *tokens.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := ctx.Value("logger").(*slog.Logger)
+
+		u, ok := accounts.FromContext(ctx)
+		if !ok {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		feedID := r.PathValue("feedID")
+		logger = logger.With(slog.String("feed_id", feedID))
+
+		if err := r.ParseForm(); err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Could not parse form data")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		name := r.FormValue("name")
+		if name == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+
+		_, err := queries.GetFeedXML(ctx, []byte(feedID))
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Feed not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Something went wrong when fetching feed.")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		g, err := access.Create(ctx, feedID, name, u.ID)
+		if err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Could not create feed token")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("created feed token", slog.Int64("token_id", g.ID))
+		writeGrant(w, access, g)
+	}
+}
+
+// RotateFeedToken replaces a subscriber's token with a new one, for when
+// their URL has leaked. Users can only rotate the tokens they issued.
+func RotateFeedToken(access *tokens.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := ctx.Value("logger").(*slog.Logger)
+
+		u, ok := accounts.FromContext(ctx)
+		if !ok {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		feedID := r.PathValue("feedID")
+		logger = logger.With(slog.String("feed_id", feedID))
+
+		id, err := strconv.ParseInt(r.PathValue("tokenID"), 10, 64)
+		if err != nil {
+			http.Error(w, "Token not found", http.StatusNotFound)
+			return
+		}
+
+		g, err := access.Rotate(ctx, feedID, id, tokenScope(u))
+		if errors.Is(err, tokens.ErrNotFound) {
+			http.Error(w, "Token not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Could not rotate feed token")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("rotated feed token", slog.Int64("old_token_id", id), slog.Int64("token_id", g.ID))
+		writeGrant(w, access, g)
+	}
+}
+
+// RevokeFeedToken stops a subscriber's token from working. Users can only
+// revoke the tokens they issued.
+func RevokeFeedToken(access *tokens.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := ctx.Value("logger").(*slog.Logger)
+
+		u, ok := accounts.FromContext(ctx)
+		if !ok {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		feedID := r.PathValue("feedID")
+		logger = logger.With(slog.String("feed_id", feedID))
+
+		id, err := strconv.ParseInt(r.PathValue("tokenID"), 10, 64)
+		if err != nil {
+			http.Error(w, "Token not found", http.StatusNotFound)
+			return
+		}
+
+		err = access.Revoke(ctx, feedID, id, tokenScope(u))
+		if errors.Is(err, tokens.ErrNotFound) {
+			http.Error(w, "Token not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Could not revoke feed token")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("revoked feed token", slog.Int64("token_id", id))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func writeGrant(w http.ResponseWriter, access *tokens.Store, g tokens.Grant) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(FeedTokenEntry{
+		ID:        g.ID,
+		Name:      g.Name,
+		CreatedAt: time.Now().UTC(),
+		URL:       access.URL(g),
+	})
+}
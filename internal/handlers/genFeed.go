@@ -1,51 +1,20 @@
 package handlers
 
 import (
-	"context"
 	"errors"
 	"html/template"
 	"log/slog"
 	"net/http"
 	"net/url"
+	"vpod/internal/accounts"
+	"vpod/internal/cookies"
 	"vpod/internal/data"
 	"vpod/internal/podcast"
-	"vpod/internal/youtube"
 
 	"github.com/urfave/cli/v2"
 )
 
-func gen(
-	ctx context.Context,
-	channelURL string,
-	baseURL *url.URL,
-	logger *slog.Logger,
-	queries *data.Queries,
-) (*podcast.Podcast, error) {
-	logger.Info("generating feed")
-	ytURL, err := url.Parse(channelURL)
-	if err != nil {
-		return nil, err
-	}
-
-	c, err := youtube.FetchChannel(ytURL, youtube.WithNItems(20))
-	if err != nil {
-		return nil, err
-	}
-
-	p, err := podcast.FromChannel(*c, *baseURL) // TODO: decide what to do about PubDate
-	if err != nil {
-		return nil, err
-	}
-
-	err = podcast.UpsertPodcast(queries, *p, ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	return p, nil
-}
-
-func GenFeed(cCtx *cli.Context, queries *data.Queries) http.HandlerFunc {
+func GenFeed(cCtx *cli.Context, queries *data.Queries, jars *cookies.Store) http.HandlerFunc {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		logger := ctx.Value("logger").(*slog.Logger)
@@ -69,7 +38,7 @@ func GenFeed(cCtx *cli.Context, queries *data.Queries) http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 
-		p, err := gen(ctx, channelURL, baseURL, logger, queries)
+		p, err := podcast.Generate(ctx, channelURL, baseURL, logger, queries, jars)
 		if err != nil {
 			logger.With(slog.String("err", err.Error())).Error("Something went wrong when generating feed.")
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -77,6 +46,15 @@ func GenFeed(cCtx *cli.Context, queries *data.Queries) http.HandlerFunc {
 		}
 		logger.Debug("Feed successfully generated")
 
+		if u, ok := accounts.FromContext(ctx); ok {
+			err = queries.AddUserFeed(ctx, data.AddUserFeedParams{UserID: u.ID, FeedID: p.Id})
+			if err != nil {
+				logger.With(slog.String("err", err.Error())).Error("Could not add feed to library")
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+		}
+
 		u := baseURL.JoinPath("feed", p.Id)
 		data := FeedPageData{
 			Image:          p.Image.URL,
@@ -8,17 +8,20 @@ import (
 	"net/url"
 	"strconv"
 	"time"
+	"vpod/internal/accounts"
+	"vpod/internal/cookies"
 	"vpod/internal/data"
 
 	"github.com/urfave/cli/v2"
 )
 
-func getFeedPage(q *data.Queries, ctx context.Context, pageSize uint, pageNumber uint64) ([]data.GetAllFeedsRow, error) {
-	params := data.GetAllFeedsParams{
-		Column1: int64(pageSize),
-		Column2: pageNumber,
+func getFeedPage(q *data.Queries, ctx context.Context, userID int64, pageSize uint, pageNumber uint64) ([]data.GetFeedsForUserRow, error) {
+	params := data.GetFeedsForUserParams{
+		UserID:   userID,
+		PageSize: int64(pageSize),
+		PageNum:  int64(pageNumber),
 	}
-	return q.GetAllFeeds(ctx, params)
+	return q.GetFeedsForUser(ctx, params)
 }
 
 // TODO unit test babyyyyy
@@ -41,13 +44,14 @@ func getFeedListEntries(
 	baseURL *url.URL,
 	logger *slog.Logger,
 	queries *data.Queries,
+	userID int64,
 	pageSize uint,
 	pageNum uint64,
 ) (*[]FeedListEntry, uint64, error) {
 	logger.Info("Getting Feeds")
 
 	nextPage := uint64(0)
-	rows, err := getFeedPage(queries, ctx, pageSize, pageNum)
+	rows, err := getFeedPage(queries, ctx, userID, pageSize, pageNum)
 	if err != nil {
 		return nil, nextPage, err
 	}
@@ -56,6 +60,7 @@ func getFeedListEntries(
 	if len(rows) > 0 {
 		for _, row := range rows {
 			feedListEntries = append(feedListEntries, FeedListEntry{
+				ID:          string(row.ID),
 				ChannelURL:  row.Link,
 				Description: row.Description.String,
 				LastUpdated: row.UpdatedAt.Time,
@@ -71,7 +76,7 @@ func getFeedListEntries(
 	return &feedListEntries, nextPage, nil
 }
 
-func GetFeeds(cCtx *cli.Context, queries *data.Queries) http.HandlerFunc {
+func GetFeeds(cCtx *cli.Context, queries *data.Queries, jars *cookies.Store) http.HandlerFunc {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		logger := ctx.Value("logger").(*slog.Logger)
@@ -90,8 +95,14 @@ func GetFeeds(cCtx *cli.Context, queries *data.Queries) http.HandlerFunc {
 			return
 		}
 
+		u, ok := accounts.FromContext(ctx)
+		if !ok {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
 		pageSize := uint(10)
-		entries, nextPage, err := getFeedListEntries(ctx, baseURL, logger, queries, pageSize, page)
+		entries, nextPage, err := getFeedListEntries(ctx, baseURL, logger, queries, u.ID, pageSize, page)
 		if err != nil {
 			logger.With(slog.String("err", err.Error())).Error("Something went wrong when getting all the feeds.")
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -101,6 +112,7 @@ func GetFeeds(cCtx *cli.Context, queries *data.Queries) http.HandlerFunc {
 		data := FeedListData{
 			Entries:  *entries,
 			NextPage: nextPage,
+			Cookies:  jars != nil && u.IsAdmin,
 		}
 		// Path is relative to where command runs
 		tmpl := template.Must(template.ParseFiles("internal/views/feedList.html"))
@@ -117,9 +129,11 @@ func GetFeeds(cCtx *cli.Context, queries *data.Queries) http.HandlerFunc {
 type FeedListData struct {
 	Entries  []FeedListEntry
 	NextPage uint64 // 0 means no next page
+	Cookies  bool   // whether the user can upload cookie jars
 }
 
 type FeedListEntry struct {
+	ID          string
 	ChannelURL  string
 	Description string
 	LastUpdated time.Time
@@ -127,3 +141,28 @@ type FeedListEntry struct {
 	Title       string
 	URL         string
 }
+
+// RemoveFeed takes a feed out of the user's library. The feed itself is
+// kept, other users may have it too.
+func RemoveFeed(users *accounts.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := ctx.Value("logger").(*slog.Logger)
+
+		feedID := r.PathValue("feedID")
+		logger = logger.With(slog.String("feed_id", feedID))
+
+		u, ok := accounts.FromContext(ctx)
+		if !ok {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if err := users.RemoveFeed(ctx, u.ID, feedID); err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Could not remove feed from library")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("removed feed from library")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
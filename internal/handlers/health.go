@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"time"
+	"vpod/internal/health"
+
+	"github.com/go-co-op/gocron/v2"
+)
+
+// readyTimeout bounds how long the readiness checks may take, so a stuck
+// dependency fails the probe instead of hanging it.
+const readyTimeout = 5 * time.Second
+
+// Healthz answers as long as the process is serving requests.
+func Healthz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("ok\n"))
+	}
+}
+
+type readyResponse struct {
+	Status string          `json:"status"`
+	Checks []health.Result `json:"checks"`
+}
+
+// Readyz runs the readiness checks, answering 503 when any of them fail.
+func Readyz(checker *health.Checker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+		defer cancel()
+
+		results, ok := checker.Run(ctx)
+		resp := readyResponse{Status: "ok", Checks: results}
+		status := http.StatusOK
+		if !ok {
+			resp.Status = "fail"
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// Setting is a configuration value shown on the status page.
+type Setting struct {
+	Name  string
+	Value string
+}
+
+type StatusData struct {
+	Build   health.Build
+	Started time.Time
+	Uptime  time.Duration
+	Ready   bool
+	Checks  []health.Result
+	Jobs    []health.JobStatus
+	Config  []Setting
+	Pprof   bool
+}
+
+// DebugStatus shows admins what vpod is running: its version, the readiness
+// checks, the scheduled jobs and the configuration it was started with.
+func DebugStatus(checker *health.Checker, scheduler gocron.Scheduler, config []Setting, started time.Time, pprof bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := r.Context().Value("logger").(*slog.Logger)
+		ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+		defer cancel()
+
+		results, ok := checker.Run(ctx)
+		data := StatusData{
+			Build:   health.BuildInfo(),
+			Started: started,
+			Uptime:  time.Since(started).Round(time.Second),
+			Ready:   ok,
+			Checks:  results,
+			Jobs:    health.Jobs(scheduler),
+			Config:  config,
+			Pprof:   pprof,
+		}
+
+		// Path is relative to where command runs
+		tmpl := template.Must(template.ParseFiles("internal/views/status.html"))
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := tmpl.Execute(w, data); err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Failed to execute status template")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
@@ -1,12 +1,41 @@
 package handlers
 
 import (
+	"html/template"
+	"log/slog"
 	"net/http"
+	"vpod/internal/accounts"
+	"vpod/internal/cookies"
+	"vpod/internal/sessions"
 )
 
-func Index() http.HandlerFunc {
+type IndexData struct {
+	Username  string
+	CSRFToken string // empty unless signed in with a session
+	Cookies   bool   // whether the user can upload cookie jars
+}
+
+func Index(jars *cookies.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "internal/views/index.html")
+		ctx := r.Context()
+		logger := ctx.Value("logger").(*slog.Logger)
+
+		var data IndexData
+		if u, ok := accounts.FromContext(ctx); ok {
+			data.Username = u.Username
+			data.Cookies = jars != nil && u.IsAdmin
+		}
+		if sess, ok := sessions.FromContext(ctx); ok {
+			data.CSRFToken = sess.CSRFToken
+		}
+
+		// Path is relative to where command runs
+		tmpl := template.Must(template.ParseFiles("internal/views/index.html"))
+		if err := tmpl.Execute(w, data); err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Failed to execute index template")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
 	}
 }
 
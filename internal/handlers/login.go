@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+	"vpod/internal/accounts"
+	"vpod/internal/data"
+	"vpod/internal/loginguard"
+	"vpod/internal/ratelimit"
+	"vpod/internal/sessions"
+	"vpod/internal/sso"
+)
+
+type LoginData struct {
+	Username string
+	Next     string
+	Error    string
+	SSO      bool
+}
+
+// loginNext only allows redirects back into the frontend or the debug
+// pages, so the login page can't be used to send people elsewhere.
+func loginNext(next string) string {
+	if !strings.HasPrefix(next, "/ui/") && !strings.HasPrefix(next, "/debug/") {
+		return "/ui/"
+	}
+	if strings.HasPrefix(next, "/ui//") || strings.HasPrefix(next, "/debug//") || strings.Contains(next, `\`) {
+		return "/ui/"
+	}
+	return next
+}
+
+func renderLogin(w http.ResponseWriter, status int, data LoginData, logger *slog.Logger) {
+	// Path is relative to where command runs
+	tmpl := template.Must(template.ParseFiles("internal/views/login.html"))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := tmpl.Execute(w, data); err != nil {
+		logger.With(slog.String("err", err.Error())).Error("Failed to execute login template")
+	}
+}
+
+// sessionCookie sets the session cookie, or removes it when sess is empty.
+// It is only sent over https when vpod is served over https, and covers the
+// whole site, as the debug pages are outside of /ui.
+func sessionCookie(w http.ResponseWriter, sess sessions.Session, secure bool) {
+	c := &http.Cookie{
+		Name:     sessions.CookieName,
+		Value:    sess.Token,
+		Path:     "/",
+		Expires:  sess.ExpiresAt,
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if sess.Token == "" {
+		c.Expires = time.Unix(0, 0)
+		c.MaxAge = -1
+	}
+	http.SetCookie(w, c)
+}
+
+// LoginPage shows the login form, with a link to sign in through the OIDC
+// provider when there is one.
+func LoginPage(provider *sso.Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := r.Context().Value("logger").(*slog.Logger)
+		data := LoginData{
+			Next: loginNext(r.URL.Query().Get("next")),
+			SSO:  provider != nil,
+		}
+		renderLogin(w, http.StatusOK, data, logger)
+	}
+}
+
+// Login starts a session for the user in the form and sends them on to
+// "next". The form takes a "username" and a "password".
+// Too many failures from the same IP or for the same username lock them out
+// for a while.
+func Login(users *accounts.Store, store *sessions.Store, guard *loginguard.Guard, provider *sso.Provider, secure bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := ctx.Value("logger").(*slog.Logger)
+
+		if err := r.ParseForm(); err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Could not parse form data")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data := LoginData{
+			Username: r.PostFormValue("username"),
+			Next:     loginNext(r.PostFormValue("next")),
+			SSO:      provider != nil,
+		}
+
+		if wait := guard.Check(r, data.Username); wait > 0 {
+			w.Header().Set("Retry-After", ratelimit.RetryAfter(wait))
+			data.Error = fmt.Sprintf("Too many failed logins, try again in %v", wait.Round(time.Second))
+			renderLogin(w, http.StatusTooManyRequests, data, logger)
+			return
+		}
+		u, err := users.Authenticate(ctx, data.Username, r.PostFormValue("password"))
+		if errors.Is(err, accounts.ErrInvalidCredentials) {
+			guard.Failed(r, data.Username, "password", err.Error())
+			data.Error = "Invalid username or password"
+			renderLogin(w, http.StatusUnauthorized, data, logger)
+			return
+		} else if err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Could not authenticate user")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		guard.Succeeded(data.Username)
+
+		startSession(w, r, store, u, data.Next, secure)
+	}
+}
+
+// startSession signs a user in and sends them on to next.
+func startSession(w http.ResponseWriter, r *http.Request, store *sessions.Store, u data.User, next string, secure bool) {
+	ctx := r.Context()
+	logger := ctx.Value("logger").(*slog.Logger)
+
+	sess, err := store.Create(ctx, u.ID)
+	if err != nil {
+		logger.With(slog.String("err", err.Error())).Error("Could not create session")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	logger.Info("user logged in", slog.Int64("user_id", u.ID))
+
+	sessionCookie(w, sess, secure)
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// Logout ends the request's session.
+func Logout(store *sessions.Store, secure bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := ctx.Value("logger").(*slog.Logger)
+
+		if sess, ok := sessions.FromContext(ctx); ok {
+			if err := store.Delete(ctx, sess.Token); err != nil {
+				logger.With(slog.String("err", err.Error())).Error("Could not delete session")
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+		}
+		sessionCookie(w, sessions.Session{}, secure)
+		http.Redirect(w, r, "/ui/login", http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"vpod/internal/sessions"
+	"vpod/internal/sso"
+)
+
+const ssoCookieName = "vpod_oidc"
+
+// ssoState is kept in a short lived cookie while the user is away at the
+// provider.
+type ssoState struct {
+	Flow sso.Flow
+	Next string
+}
+
+func ssoCookie(w http.ResponseWriter, value string, maxAge int, secure bool) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     ssoCookieName,
+		Value:    value,
+		Path:     "/ui/oidc",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   secure,
+		// Lax, so the cookie comes back with the provider's redirect
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// SSOLogin sends the user to the OIDC provider to sign in.
+func SSOLogin(provider *sso.Provider, secure bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := ctx.Value("logger").(*slog.Logger)
+
+		flow, authURL, err := provider.Start()
+		if err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Could not start oidc login")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		state, err := json.Marshal(ssoState{Flow: flow, Next: loginNext(r.URL.Query().Get("next"))})
+		if err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Could not encode oidc state")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		ssoCookie(w, base64.RawURLEncoding.EncodeToString(state), 10*60, secure)
+		http.Redirect(w, r, authURL, http.StatusFound)
+	}
+}
+
+// SSOCallback is where the OIDC provider sends the user back to. It signs
+// them in to the vpod user linked to their identity.
+func SSOCallback(provider *sso.Provider, store *sessions.Store, secure bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := ctx.Value("logger").(*slog.Logger)
+		failed := func(status int, msg string) {
+			renderLogin(w, status, LoginData{Next: "/ui/", Error: msg, SSO: true}, logger)
+		}
+
+		var state ssoState
+		c, err := r.Cookie(ssoCookieName)
+		if err == nil {
+			var raw []byte
+			raw, err = base64.RawURLEncoding.DecodeString(c.Value)
+			if err == nil {
+				err = json.Unmarshal(raw, &state)
+			}
+		}
+		// The state is single use
+		ssoCookie(w, "", -1, secure)
+		if err != nil {
+			failed(http.StatusBadRequest, "Your login expired, please try again")
+			return
+		}
+		q := r.URL.Query()
+		if e := q.Get("error"); e != "" {
+			logger.Warn("oidc provider returned an error", slog.String("error", e), slog.String("description", q.Get("error_description")))
+			failed(http.StatusUnauthorized, "The identity provider did not sign you in")
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(q.Get("state")), []byte(state.Flow.State)) != 1 {
+			failed(http.StatusBadRequest, "Your login expired, please try again")
+			return
+		}
+
+		id, err := provider.Exchange(ctx, state.Flow, q.Get("code"))
+		if err != nil {
+			logger.With(slog.String("err", err.Error())).Warn("Could not complete oidc login")
+			failed(http.StatusUnauthorized, "The identity provider did not sign you in")
+			return
+		}
+		logger = logger.With(slog.String("issuer", id.Issuer), slog.String("subject", id.Subject))
+
+		u, err := provider.User(ctx, id)
+		if errors.Is(err, sso.ErrNotAllowed) {
+			logger.Warn("login failed",
+				slog.String("event", "login_failed"),
+				slog.String("method", "oidc"),
+				slog.String("email", id.Email),
+				slog.String("reason", err.Error()),
+			)
+			failed(http.StatusForbidden, err.Error())
+			return
+		} else if err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Could not get user for oidc identity")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		startSession(w, r, store, u, state.Next, secure)
+	}
+}
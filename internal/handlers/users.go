@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+	"vpod/internal/accounts"
+	"vpod/internal/data"
+	"vpod/internal/sessions"
+)
+
+type UserEntry struct {
+	ID        int64     `json:"id"`
+	Username  string    `json:"username"`
+	Admin     bool      `json:"admin"`
+	External  bool      `json:"external"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func userEntry(u data.User) UserEntry {
+	return UserEntry{
+		ID:        u.ID,
+		Username:  u.Username,
+		Admin:     u.IsAdmin,
+		External:  u.IsExternal,
+		CreatedAt: u.CreatedAt.Time,
+	}
+}
+
+// userError writes the response for errors from the accounts store.
+func userError(w http.ResponseWriter, err error, logger *slog.Logger) {
+	switch {
+	case errors.Is(err, accounts.ErrUserNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, accounts.ErrUserExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, accounts.ErrInvalidUsername), errors.Is(err, accounts.ErrInvalidPassword),
+		errors.Is(err, accounts.ErrExternalUser):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		logger.With(slog.String("err", err.Error())).Error("Something went wrong when managing users")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// GetUsers lists every user.
+func GetUsers(users *accounts.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := ctx.Value("logger").(*slog.Logger)
+
+		rows, err := users.List(ctx)
+		if err != nil {
+			userError(w, err, logger)
+			return
+		}
+		entries := make([]UserEntry, 0, len(rows))
+		for _, u := range rows {
+			entries = append(entries, userEntry(u))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	}
+}
+
+// CreateUser adds a user. The form takes a "username", a "password" and
+// "admin" as a checkbox.
+func CreateUser(users *accounts.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := ctx.Value("logger").(*slog.Logger)
+
+		if err := r.ParseForm(); err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Could not parse form data")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		u, err := users.Create(ctx, r.FormValue("username"), r.FormValue("password"), r.FormValue("admin") != "")
+		if err != nil {
+			userError(w, err, logger)
+			return
+		}
+		logger.Info("created user", slog.Int64("user_id", u.ID), slog.Bool("admin", u.IsAdmin))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(userEntry(u))
+	}
+}
+
+// DeleteUser removes a user, their library and their sessions. Admins can't
+// remove themselves, so there is always someone left to manage users.
+func DeleteUser(users *accounts.Store, store *sessions.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := ctx.Value("logger").(*slog.Logger)
+
+		id, err := strconv.ParseInt(r.PathValue("userID"), 10, 64)
+		if err != nil {
+			http.Error(w, accounts.ErrUserNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		logger = logger.With(slog.Int64("user_id", id))
+
+		if me, ok := accounts.FromContext(ctx); ok && me.ID == id {
+			http.Error(w, "You can't delete yourself", http.StatusBadRequest)
+			return
+		}
+		if err := users.Delete(ctx, id); err != nil {
+			userError(w, err, logger)
+			return
+		}
+		if err := store.DeleteForUser(ctx, id, ""); err != nil {
+			userError(w, err, logger)
+			return
+		}
+		logger.Info("deleted user")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// SetUserPassword changes the password of the user in the path, or of the
+// signed in user when there is none. The form takes a "password". The user's
+// other sessions are ended, the one making the change stays signed in.
+func SetUserPassword(users *accounts.Store, store *sessions.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := ctx.Value("logger").(*slog.Logger)
+
+		var id int64
+		if r.PathValue("userID") != "" {
+			var err error
+			id, err = strconv.ParseInt(r.PathValue("userID"), 10, 64)
+			if err != nil {
+				http.Error(w, accounts.ErrUserNotFound.Error(), http.StatusNotFound)
+				return
+			}
+		} else {
+			me, ok := accounts.FromContext(ctx)
+			if !ok {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			id = me.ID
+		}
+		logger = logger.With(slog.Int64("user_id", id))
+
+		if err := r.ParseForm(); err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Could not parse form data")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := users.SetPassword(ctx, id, r.FormValue("password")); err != nil {
+			userError(w, err, logger)
+			return
+		}
+		var current string
+		if sess, ok := sessions.FromContext(ctx); ok {
+			current = sess.Token
+		}
+		if err := store.DeleteForUser(ctx, id, current); err != nil {
+			userError(w, err, logger)
+			return
+		}
+		logger.Info("changed user password")
+		w.Write([]byte("Password updated"))
+	}
+}
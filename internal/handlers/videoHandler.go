@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"vpod/internal/audio"
+	"vpod/internal/signing"
+	"vpod/internal/tokens"
+)
+
+// Video serves the muxed video enclosures of video feeds, downloading and
+// caching them the same way as audio.
+func Video(downloader *audio.Downloader, access *tokens.Store, signer *signing.Signer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := ctx.Value("logger").(*slog.Logger)
+		m, err := audio.NewMetadata(r.PathValue("videoID"), r.PathValue("formatID"), true)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger = logger.With(slog.String("video_metadata", fmt.Sprintf("%+v", m)))
+		feedID, ok := canServe(w, r, access, signer, "video", m, logger)
+		if !ok {
+			return
+		}
+		m.FeedId = feedID
+		videoFilename, err := downloader.Get(ctx, m, logger)
+		if err != nil {
+			logger.Error("Failed to get video")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.ServeFile(w, r, videoFilename)
+	}
+}
@@ -0,0 +1,207 @@
+// Package health checks whether vpod can do its job: that the DB answers,
+// the tools it shells out to are installed, audio can be written and the
+// scheduler is running.
+package health
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-co-op/gocron/v2"
+)
+
+// Check is one thing that has to work for vpod to be ready. Run returns
+// a short description of what it found, like a version.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) (string, error)
+}
+
+type Result struct {
+	Name       string `json:"name"`
+	OK         bool   `json:"ok"`
+	Detail     string `json:"detail,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+type Checker struct {
+	checks []Check
+}
+
+func New(checks ...Check) *Checker {
+	return &Checker{checks: checks}
+}
+
+// Run runs every check at once and reports whether they all passed.
+func (c *Checker) Run(ctx context.Context) ([]Result, bool) {
+	results := make([]Result, len(c.checks))
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			detail, err := check.Run(ctx)
+			results[i] = Result{
+				Name:       check.Name,
+				OK:         err == nil,
+				Detail:     detail,
+				DurationMs: time.Since(start).Milliseconds(),
+			}
+			if err != nil {
+				results[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+
+	ok := true
+	for _, r := range results {
+		ok = ok && r.OK
+	}
+	return results, ok
+}
+
+// DB checks that the database answers.
+func DB(db *sql.DB) Check {
+	return Check{
+		Name: "database",
+		Run: func(ctx context.Context) (string, error) {
+			return "", db.PingContext(ctx)
+		},
+	}
+}
+
+// versionTTL is how long a binary's version is remembered, so frequent
+// probes don't start a process each time.
+const versionTTL = 5 * time.Minute
+
+// Binary checks that a program is installed, and reports the first line
+// of what it prints when run with args, which should be its version.
+func Binary(name string, args ...string) Check {
+	var (
+		mu      sync.Mutex
+		path    string
+		version string
+		checked time.Time
+	)
+	return Check{
+		Name: name,
+		Run: func(ctx context.Context) (string, error) {
+			found, err := exec.LookPath(name)
+			if err != nil {
+				return "", err
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if found == path && time.Since(checked) < versionTTL {
+				return version, nil
+			}
+
+			var out bytes.Buffer
+			cmd := exec.CommandContext(ctx, found, args...)
+			cmd.Stdout = &out
+			if err := cmd.Run(); err != nil {
+				return "", fmt.Errorf("could not run %s: %w", found, err)
+			}
+			first, _, _ := strings.Cut(strings.TrimSpace(out.String()), "\n")
+			path, version, checked = found, strings.TrimSpace(first), time.Now()
+			return version, nil
+		},
+	}
+}
+
+// Writable checks that files can be created in dir.
+func Writable(name, dir string) Check {
+	return Check{
+		Name: name,
+		Run: func(ctx context.Context) (string, error) {
+			f, err := os.CreateTemp(dir, ".vpod-ready-*")
+			if err != nil {
+				return "", err
+			}
+			f.Close()
+			return dir, os.Remove(f.Name())
+		},
+	}
+}
+
+// Scheduler checks that every job of s is scheduled to run again.
+func Scheduler(s gocron.Scheduler) Check {
+	return Check{
+		Name: "scheduler",
+		Run: func(ctx context.Context) (string, error) {
+			jobs := s.Jobs()
+			if len(jobs) == 0 {
+				return "", errors.New("no jobs are scheduled")
+			}
+			for _, j := range jobs {
+				next, err := j.NextRun()
+				if err != nil {
+					return "", fmt.Errorf("job %s: %w", j.Name(), err)
+				}
+				if next.IsZero() {
+					return "", fmt.Errorf("job %s is not scheduled", j.Name())
+				}
+			}
+			return fmt.Sprintf("%d jobs", len(jobs)), nil
+		},
+	}
+}
+
+type JobStatus struct {
+	Name    string
+	LastRun time.Time
+	NextRun time.Time
+}
+
+// Jobs lists the jobs of s with when they last ran and will run next. The
+// times are zero when unknown.
+func Jobs(s gocron.Scheduler) []JobStatus {
+	var statuses []JobStatus
+	for _, j := range s.Jobs() {
+		last, _ := j.LastRun()
+		next, _ := j.NextRun()
+		statuses = append(statuses, JobStatus{Name: j.Name(), LastRun: last, NextRun: next})
+	}
+	return statuses
+}
+
+type Build struct {
+	Version   string
+	Revision  string
+	GoVersion string
+}
+
+// BuildInfo is what vpod was built from, as far as the binary knows.
+func BuildInfo() Build {
+	b := Build{Version: "unknown", GoVersion: runtime.Version()}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	b.Version = info.Main.Version
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			b.Revision = s.Value
+		case "vcs.modified":
+			if s.Value == "true" {
+				b.Revision += " (modified)"
+			}
+		}
+	}
+	return b
+}
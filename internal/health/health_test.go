@@ -0,0 +1,116 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-co-op/gocron/v2"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestCheckerRun(t *testing.T) {
+	pass := Check{Name: "pass", Run: func(context.Context) (string, error) { return "v1", nil }}
+	fail := Check{Name: "fail", Run: func(context.Context) (string, error) { return "", errors.New("broken") }}
+
+	results, ok := New(pass).Run(context.Background())
+	if !ok || len(results) != 1 || results[0].Detail != "v1" {
+		t.Errorf("passing checks = %+v, %v", results, ok)
+	}
+
+	results, ok = New(pass, fail).Run(context.Background())
+	if ok {
+		t.Error("a failed check didn't fail the run")
+	}
+	if results[0].Name != "pass" || results[1].Name != "fail" {
+		t.Errorf("results out of order: %+v", results)
+	}
+	if results[1].OK || results[1].Error != "broken" {
+		t.Errorf("failed check = %+v", results[1])
+	}
+}
+
+func TestDB(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DB(db).Run(context.Background()); err != nil {
+		t.Errorf("open db: %v", err)
+	}
+	db.Close()
+	if _, err := DB(db).Run(context.Background()); err == nil {
+		t.Error("closed db passed")
+	}
+}
+
+func TestBinary(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "fake-tool")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho 'fake-tool 1.2.3'\necho 'more'\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+
+	version, err := Binary("fake-tool", "--version").Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != "fake-tool 1.2.3" {
+		t.Errorf("version = %q, want the first line", version)
+	}
+
+	if _, err := Binary("missing-tool").Run(context.Background()); err == nil {
+		t.Error("missing binary passed")
+	}
+}
+
+func TestWritable(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Writable("audio", dir).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("check left %d files behind", len(entries))
+	}
+	if _, err := Writable("audio", filepath.Join(dir, "missing")).Run(context.Background()); err == nil {
+		t.Error("missing dir passed")
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	s, err := gocron.NewScheduler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := Scheduler(s)
+	if _, err := check.Run(context.Background()); err == nil {
+		t.Error("scheduler without jobs passed")
+	}
+
+	_, err = s.NewJob(gocron.DurationJob(time.Hour), gocron.NewTask(func() {}), gocron.WithName("job"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Start()
+	if _, err := check.Run(context.Background()); err != nil {
+		t.Errorf("running scheduler: %v", err)
+	}
+	jobs := Jobs(s)
+	if len(jobs) != 1 || jobs[0].Name != "job" || jobs[0].NextRun.IsZero() {
+		t.Errorf("jobs = %+v", jobs)
+	}
+
+	s.Shutdown()
+	if _, err := check.Run(context.Background()); err == nil {
+		t.Error("stopped scheduler passed")
+	}
+}
@@ -0,0 +1,142 @@
+// Package loginguard slows down password guessing. Failed logins are
+// counted per client IP and per username, and once either has failed too
+// often it is locked out for a while, longer with every further failure.
+package loginguard
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"vpod/internal/clientip"
+)
+
+// maxEntries bounds how many IPs and usernames are remembered, so a flood
+// of made up usernames can't use up memory.
+const maxEntries = 10000
+
+type Config struct {
+	// MaxFailures is how many failures in a row are let through before
+	// locking out
+	MaxFailures int
+	// Lockout is how long the first lockout lasts. Each failure after it
+	// doubles it, up to MaxLockout.
+	Lockout    time.Duration
+	MaxLockout time.Duration
+}
+
+type entry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+type Guard struct {
+	cfg    Config
+	logger *slog.Logger
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+func New(logger *slog.Logger, cfg Config) *Guard {
+	return &Guard{
+		cfg:     cfg,
+		logger:  logger,
+		now:     time.Now,
+		entries: map[string]*entry{},
+	}
+}
+
+func keys(ip, username string) []string {
+	return []string{"ip:" + ip, "user:" + strings.ToLower(username)}
+}
+
+// forget drops entries that have been quiet for longer than the longest
+// lockout. It's called with the lock held.
+func (g *Guard) forget(now time.Time) {
+	for k, e := range g.entries {
+		if now.Sub(e.lastFailure) > g.cfg.MaxLockout && now.After(e.lockedUntil) {
+			delete(g.entries, k)
+		}
+	}
+}
+
+// Check returns how long to wait before trying again when the IP or the
+// username is locked out, and zero when the login can go ahead.
+func (g *Guard) Check(r *http.Request, username string) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	var wait time.Duration
+	for _, k := range keys(clientip.FromRequest(r), username) {
+		if e, ok := g.entries[k]; ok && e.lockedUntil.After(now) {
+			wait = max(wait, e.lockedUntil.Sub(now))
+		}
+	}
+	return wait
+}
+
+// Failed records a failed login and writes it to the audit log. method is
+// how the user tried to log in, such as "password" or "basic".
+func (g *Guard) Failed(r *http.Request, username, method, reason string) {
+	ip := clientip.FromRequest(r)
+	g.mu.Lock()
+	now := g.now()
+	if len(g.entries) >= maxEntries {
+		g.forget(now)
+	}
+	var lockedUntil time.Time
+	for _, k := range keys(ip, username) {
+		e, ok := g.entries[k]
+		if !ok {
+			if len(g.entries) >= maxEntries {
+				continue
+			}
+			e = &entry{}
+			g.entries[k] = e
+		}
+		// Failures spread far apart are typos, not guessing
+		if now.Sub(e.lastFailure) > g.cfg.MaxLockout {
+			e.failures = 0
+		}
+		e.failures++
+		e.lastFailure = now
+		if over := e.failures - g.cfg.MaxFailures; over > 0 {
+			lockout := g.cfg.Lockout
+			for i := 1; i < over && lockout < g.cfg.MaxLockout; i++ {
+				lockout *= 2
+			}
+			lockout = min(lockout, g.cfg.MaxLockout)
+			e.lockedUntil = now.Add(lockout)
+			if e.lockedUntil.After(lockedUntil) {
+				lockedUntil = e.lockedUntil
+			}
+		}
+	}
+	g.mu.Unlock()
+
+	attrs := []any{
+		slog.String("event", "login_failed"),
+		slog.String("username", username),
+		slog.String("ip", ip),
+		slog.String("method", method),
+		slog.String("reason", reason),
+	}
+	if !lockedUntil.IsZero() {
+		attrs = append(attrs, slog.Time("locked_until", lockedUntil))
+	}
+	g.logger.Warn("login failed", attrs...)
+}
+
+// Succeeded clears the failures of a username after a login went through.
+// The IP's failures stay, or logging in to an account of your own would
+// reset the count while guessing the passwords of others.
+func (g *Guard) Succeeded(username string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.entries, "user:"+strings.ToLower(username))
+}
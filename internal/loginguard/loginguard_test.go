@@ -0,0 +1,102 @@
+package loginguard
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newGuard(now *time.Time) *Guard {
+	g := New(slog.New(slog.NewTextHandler(io.Discard, nil)), Config{
+		MaxFailures: 3,
+		Lockout:     time.Minute,
+		MaxLockout:  10 * time.Minute,
+	})
+	g.now = func() time.Time { return *now }
+	return g
+}
+
+func TestLockoutBacksOff(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	g := newGuard(&now)
+	r := httptest.NewRequest("POST", "/ui/login", nil)
+
+	for range 3 {
+		if wait := g.Check(r, "alice"); wait != 0 {
+			t.Fatalf("Check() = %v before too many failures, want 0", wait)
+		}
+		g.Failed(r, "alice", "password", "invalid credentials")
+	}
+	if wait := g.Check(r, "alice"); wait != 0 {
+		t.Fatalf("Check() = %v after MaxFailures, want 0", wait)
+	}
+
+	want := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 10 * time.Minute, 10 * time.Minute}
+	for _, w := range want {
+		g.Failed(r, "alice", "password", "invalid credentials")
+		if wait := g.Check(r, "alice"); wait != w {
+			t.Errorf("Check() = %v, want %v", wait, w)
+		}
+		now = now.Add(w)
+		if wait := g.Check(r, "alice"); wait != 0 {
+			t.Errorf("Check() after the lockout = %v, want 0", wait)
+		}
+	}
+}
+
+func TestLockoutByIPAndUser(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	g := newGuard(&now)
+	attacker := httptest.NewRequest("POST", "/ui/login", nil)
+	attacker.RemoteAddr = "203.0.113.7:4242"
+	other := httptest.NewRequest("POST", "/ui/login", nil)
+	other.RemoteAddr = "198.51.100.1:4242"
+
+	// Guessing different users from one IP locks the IP
+	for _, u := range []string{"a", "b", "c", "d"} {
+		g.Failed(attacker, u, "basic", "invalid credentials")
+	}
+	if wait := g.Check(attacker, "e"); wait == 0 {
+		t.Error("Check() for the guessing IP = 0, want a lockout")
+	}
+	if wait := g.Check(other, "e"); wait != 0 {
+		t.Errorf("Check() from another IP = %v, want 0", wait)
+	}
+
+	// Guessing one user from many IPs locks the user
+	for i := range 4 {
+		r := httptest.NewRequest("POST", "/ui/login", nil)
+		r.RemoteAddr = fmt.Sprintf("192.0.2.%d:4242", i+1)
+		g.Failed(r, "Alice", "basic", "invalid credentials")
+	}
+	if wait := g.Check(other, "alice"); wait == 0 {
+		t.Error("Check() for the guessed user = 0, want a lockout")
+	}
+
+	// Logging in clears the user, not the IP
+	g.Succeeded("alice")
+	if wait := g.Check(other, "alice"); wait != 0 {
+		t.Errorf("Check() after logging in = %v, want 0", wait)
+	}
+	g.Succeeded("e")
+	if wait := g.Check(attacker, "e"); wait == 0 {
+		t.Error("Check() for the guessing IP after logging in = 0, want a lockout")
+	}
+}
+
+func TestFailuresFarApartDontAddUp(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	g := newGuard(&now)
+	r := httptest.NewRequest("POST", "/ui/login", nil)
+
+	for range 10 {
+		g.Failed(r, "alice", "password", "invalid credentials")
+		now = now.Add(time.Hour)
+	}
+	if wait := g.Check(r, "alice"); wait != 0 {
+		t.Errorf("Check() = %v, want 0", wait)
+	}
+}
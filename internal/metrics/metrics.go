@@ -0,0 +1,168 @@
+// Package metrics holds vpod's Prometheus metrics. They live in their own
+// registry, served by Handler, rather than the global one.
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+	"vpod/internal/data"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "vpod"
+
+// Registry has every vpod metric, and the Go runtime and process ones.
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	HTTPRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by route pattern and status code.",
+	}, []string{"method", "route", "code"})
+
+	HTTPRequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "How long HTTP requests took, by route pattern.",
+		// Audio requests can wait on a whole download
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"method", "route"})
+
+	YtDlpRuns = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ytdlp_runs_total",
+		Help:      "yt-dlp runs, by what they were for and whether they succeeded.",
+	}, []string{"kind", "result"})
+
+	YtDlpDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ytdlp_duration_seconds",
+		Help:      "How long yt-dlp runs took, by what they were for.",
+		Buckets:   []float64{.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+	}, []string{"kind"})
+
+	AudioCacheRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "audio_cache_requests_total",
+		Help:      "Requests for audio, by whether it was already on disk.",
+	}, []string{"result"})
+
+	FeedRefreshes = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "feed_refreshes_total",
+		Help:      "Feed refreshes, by feed and whether they succeeded.",
+	}, []string{"feed_id", "result"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// result is the label for how something went.
+func result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+// ObserveYtDlp records a yt-dlp run of kind that started at start.
+func ObserveYtDlp(kind string, start time.Time, err error) {
+	YtDlpRuns.WithLabelValues(kind, result(err)).Inc()
+	YtDlpDuration.WithLabelValues(kind).Observe(time.Since(start).Seconds())
+}
+
+// ObserveFeedRefresh records a refresh of a feed.
+func ObserveFeedRefresh(feedID string, err error) {
+	FeedRefreshes.WithLabelValues(feedID, result(err)).Inc()
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// dbCollector reports what's in the DB each time metrics are scraped.
+type dbCollector struct {
+	queries *data.Queries
+	now     func() time.Time
+
+	feeds        *prometheus.Desc
+	episodes     *prometheus.Desc
+	audioBytes   *prometheus.Desc
+	schedulerLag *prometheus.Desc
+	errors       *prometheus.Desc
+}
+
+func newDBCollector(queries *data.Queries) *dbCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, nil, nil)
+	}
+	return &dbCollector{
+		queries:      queries,
+		now:          time.Now,
+		feeds:        desc("feeds", "Number of feeds."),
+		episodes:     desc("episodes", "Number of episodes across all feeds."),
+		audioBytes:   desc("audio_cache_bytes", "Bytes of downloaded audio on disk."),
+		schedulerLag: desc("scheduler_lag_seconds", "How long the most overdue feed refresh has been due."),
+		errors:       desc("db_collector_errors", "Number of DB metrics that could not be read on this scrape."),
+	}
+}
+
+// RegisterDB adds the metrics read from the DB: the number of feeds and
+// episodes, how much audio is cached, and how far behind refreshes are.
+func RegisterDB(queries *data.Queries) error {
+	return Registry.Register(newDBCollector(queries))
+}
+
+func (c *dbCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.feeds
+	ch <- c.episodes
+	ch <- c.audioBytes
+	ch <- c.schedulerLag
+	ch <- c.errors
+}
+
+func (c *dbCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var failed int
+	gauge := func(desc *prometheus.Desc, get func(context.Context) (int64, error)) {
+		v, err := get(ctx)
+		if err != nil {
+			failed++
+			return
+		}
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(v))
+	}
+	gauge(c.feeds, c.queries.CountFeeds)
+	gauge(c.episodes, c.queries.CountEpisodes)
+	gauge(c.audioBytes, c.queries.GetAudioCacheSize)
+
+	now := c.now().UTC()
+	oldest, err := c.queries.GetOldestDueRefresh(ctx, sql.NullTime{Time: now, Valid: true})
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		ch <- prometheus.MustNewConstMetric(c.schedulerLag, prometheus.GaugeValue, 0)
+	case err != nil:
+		failed++
+	default:
+		ch <- prometheus.MustNewConstMetric(c.schedulerLag, prometheus.GaugeValue, now.Sub(oldest.Time).Seconds())
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.errors, prometheus.GaugeValue, float64(failed))
+}
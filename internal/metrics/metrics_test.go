@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+	"vpod/internal/data"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func initDb(t *testing.T) *data.Queries {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	if err := data.Migrate(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	return data.New(db)
+}
+
+// gather returns the metrics in a registry by name.
+func gather(t *testing.T, g prometheus.Gatherer) map[string]*dto.MetricFamily {
+	families, err := g.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := map[string]*dto.MetricFamily{}
+	for _, f := range families {
+		byName[f.GetName()] = f
+	}
+	return byName
+}
+
+func TestDBCollector(t *testing.T) {
+	queries := initDb(t)
+	ctx := context.Background()
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, id := range []string{"UCa", "UCb"} {
+		if err := queries.UpsertFeed(ctx, data.UpsertFeedParams{ID: []byte(id), Title: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := queries.UpsertEpisode(ctx, data.UpsertEpisodeParams{
+		ID:       []byte("dQw4w9WgXcQ"),
+		AudioUrl: "https://vpod.local/audio/dQw4w9WgXcQ/140",
+		FeedID:   "UCa",
+		Title:    "Episode",
+		VideoID:  "dQw4w9WgXcQ",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = queries.SetFeedRefreshed(ctx, data.SetFeedRefreshedParams{
+		FeedID:        "UCa",
+		NextRefreshAt: sql.NullTime{Time: now.Add(-90 * time.Second), Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = queries.SetFeedRefreshed(ctx, data.SetFeedRefreshedParams{
+		FeedID:        "UCb",
+		NextRefreshAt: sql.NullTime{Time: now.Add(time.Hour), Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := newDBCollector(queries)
+	c.now = func() time.Time { return now }
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	want := map[string]float64{
+		"vpod_feeds":                 2,
+		"vpod_episodes":              1,
+		"vpod_audio_cache_bytes":     0,
+		"vpod_scheduler_lag_seconds": 90,
+		"vpod_db_collector_errors":   0,
+	}
+	got := gather(t, reg)
+	for name, v := range want {
+		f, ok := got[name]
+		if !ok {
+			t.Errorf("%s is missing", name)
+			continue
+		}
+		if g := f.GetMetric()[0].GetGauge().GetValue(); g != v {
+			t.Errorf("%s = %v, want %v", name, g, v)
+		}
+	}
+}
+
+func TestObserve(t *testing.T) {
+	ObserveFeedRefresh("UCa", nil)
+	ObserveFeedRefresh("UCa", errors.New("yt-dlp failed"))
+	ObserveFeedRefresh("UCa", errors.New("yt-dlp failed"))
+	ObserveYtDlp("audio", time.Now().Add(-time.Second), nil)
+
+	got := gather(t, Registry)
+	counts := map[string]float64{}
+	for _, m := range got["vpod_feed_refreshes_total"].GetMetric() {
+		for _, l := range m.GetLabel() {
+			if l.GetName() == "result" {
+				counts[l.GetValue()] = m.GetCounter().GetValue()
+			}
+		}
+	}
+	if counts["success"] != 1 || counts["failure"] != 2 {
+		t.Errorf("vpod_feed_refreshes_total = %v, want 1 success and 2 failures", counts)
+	}
+	h := got["vpod_ytdlp_duration_seconds"].GetMetric()[0].GetHistogram()
+	if h.GetSampleCount() != 1 || h.GetSampleSum() < 1 {
+		t.Errorf("vpod_ytdlp_duration_seconds = %v, want one run of at least 1s", h)
+	}
+}
@@ -1,24 +1,82 @@
 package middleware
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"vpod/internal/accounts"
 )
 
-func NewBasicAuth(wantedUser *string, wantedPass *string) func(http.Handler) http.Handler {
+// AsUser signs every request in as one user, for running without auth. The
+// user must already exist.
+func AsUser(ctx context.Context, users *accounts.Store, username string) (func(http.Handler) http.Handler, error) {
+	_, err := users.GetByUsername(ctx, username)
+	if errors.Is(err, accounts.ErrUserNotFound) {
+		return nil, fmt.Errorf("there is no user %q to run without auth as", username)
+	} else if err != nil {
+		return nil, err
+	}
+
 	return func(next http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
-			gotUser, gotPass, ok := r.BasicAuth()
-			if !ok || !validateCredentials(gotUser, gotPass, *wantedUser, *wantedPass) {
-				w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			ctx := r.Context()
+			logger := ctx.Value("logger").(*slog.Logger)
+
+			u, err := users.GetByUsername(ctx, username)
+			if err != nil {
+				logger.With(slog.String("err", err.Error())).Error("Could not get user", slog.String("username", username))
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
 				return
 			}
-			next.ServeHTTP(w, r)
+			next.ServeHTTP(w, r.WithContext(accounts.WithUser(ctx, u)))
 		}
 		return http.HandlerFunc(fn)
+	}, nil
+}
+
+// RequireAdmin only lets admins through. It goes after the middleware that
+// signs users in.
+func RequireAdmin(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		u, ok := accounts.FromContext(r.Context())
+		if !ok || !u.IsAdmin {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
 	}
+	return http.HandlerFunc(fn)
 }
 
-func validateCredentials(gotUser string, gotPass string, wantedUser string, wantedPass string) bool {
-	return gotUser == wantedUser && gotPass == wantedPass
+// RequireFeed only lets users change the feed in the path if it is in their
+// library. Admins can change any feed.
+func RequireFeed(users *accounts.Store) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			logger := ctx.Value("logger").(*slog.Logger)
+
+			u, ok := accounts.FromContext(ctx)
+			if !ok {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			if !u.IsAdmin {
+				has, err := users.HasFeed(ctx, u.ID, r.PathValue("feedID"))
+				if err != nil {
+					logger.With(slog.String("err", err.Error())).Error("Could not check user's feeds")
+					http.Error(w, "Internal server error", http.StatusInternalServerError)
+					return
+				}
+				if !has {
+					http.Error(w, "Feed not found", http.StatusNotFound)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
 }
@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"vpod/internal/accounts"
+	"vpod/internal/data"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func initDb(t *testing.T) *data.Queries {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	if err := data.Migrate(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	return data.New(db)
+}
+
+func createUser(t *testing.T, users *accounts.Store, username string, admin bool) data.User {
+	t.Helper()
+	u, err := users.Create(context.Background(), username, "correct horse", admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+// serve sends a request through h, signed in as u unless it's nil.
+func serve(h http.Handler, method, target string, u *data.User) int {
+	ctx := context.WithValue(context.Background(), "logger", slog.New(slog.DiscardHandler))
+	if u != nil {
+		ctx = accounts.WithUser(ctx, *u)
+	}
+	r := httptest.NewRequest(method, target, nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code
+}
+
+var ok = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+func TestRequireAdmin(t *testing.T) {
+	users := accounts.New(initDb(t))
+	admin := createUser(t, users, "admin", true)
+	alice := createUser(t, users, "alice", false)
+
+	tests := []struct {
+		name string
+		user *data.User
+		want int
+	}{
+		{"admin", &admin, http.StatusOK},
+		{"user", &alice, http.StatusForbidden},
+		{"signed out", nil, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := serve(RequireAdmin(ok), "GET", "/ui/users", tt.user); got != tt.want {
+				t.Errorf("status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireFeed(t *testing.T) {
+	users := accounts.New(initDb(t))
+	admin := createUser(t, users, "admin", true)
+	alice := createUser(t, users, "alice", false)
+	if err := users.AddFeed(context.Background(), alice.ID, "UCalice"); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /feeds/{feedID}/tokens", RequireFeed(users)(ok))
+
+	tests := []struct {
+		name   string
+		user   *data.User
+		feedID string
+		want   int
+	}{
+		{"feed in library", &alice, "UCalice", http.StatusOK},
+		{"feed not in library", &alice, "UCother", http.StatusNotFound},
+		{"admin", &admin, "UCother", http.StatusOK},
+		{"signed out", nil, "UCalice", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := serve(mux, "POST", "/feeds/"+tt.feedID+"/tokens", tt.user); got != tt.want {
+				t.Errorf("status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAsUser(t *testing.T) {
+	users := accounts.New(initDb(t))
+	alice := createUser(t, users, "alice", false)
+	ctx := context.Background()
+
+	if _, err := AsUser(ctx, users, "bob"); err == nil {
+		t.Error("AsUser() of a missing user didn't fail")
+	}
+
+	asAlice, err := AsUser(ctx, users, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got data.User
+	h := asAlice(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = accounts.FromContext(r.Context())
+	}))
+	if code := serve(h, "GET", "/ui/", nil); code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", code)
+	}
+	if got.ID != alice.ID {
+		t.Errorf("request was signed in as %+v, want alice", got)
+	}
+
+	// Users deleted while running stop being signed in
+	if err := users.Delete(ctx, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if code := serve(h, "GET", "/ui/", nil); code == http.StatusOK {
+		t.Error("request was signed in as a deleted user")
+	}
+}
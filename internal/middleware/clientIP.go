@@ -0,0 +1,18 @@
+package middleware
+
+import (
+	"net/http"
+	"vpod/internal/clientip"
+)
+
+// ClientIP resolves the address of the client once, so logging and rate
+// limiting all see the same one. It goes before everything that uses it.
+func ClientIP(res *clientip.Resolver) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ctx := clientip.WithClientIP(r.Context(), res.Resolve(r))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
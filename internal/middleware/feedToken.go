@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"vpod/internal/tokens"
+)
+
+// FeedToken checks the token in /f/{token}/ routes and strips it from the
+// path, so the usual feed and audio handlers can serve them. Handlers check
+// the token's grant against the feed they serve.
+func FeedToken(store *tokens.Store) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			logger := ctx.Value("logger").(*slog.Logger)
+
+			token := r.PathValue("token")
+			g, err := store.Lookup(ctx, token)
+			if errors.Is(err, tokens.ErrNotFound) {
+				// The same as an unknown feed, so tokens can't be probed
+				http.NotFound(w, r)
+				return
+			} else if err != nil {
+				logger.With(slog.String("err", err.Error())).Error("Could not look up feed token")
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+
+			r2 := r.Clone(tokens.WithGrant(ctx, g))
+			r2.URL.Path = strings.TrimPrefix(r.URL.Path, "/f/"+token)
+			r2.URL.RawPath = ""
+			next.ServeHTTP(w, r2)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
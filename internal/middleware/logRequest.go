@@ -5,11 +5,18 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
+	"vpod/internal/clientip"
+	"vpod/internal/metrics"
 
 	"github.com/felixge/httpsnoop"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func LogRequest(logger *slog.Logger) func(http.Handler) http.Handler {
@@ -33,26 +40,49 @@ func LogRequest(logger *slog.Logger) func(http.Handler) http.Handler {
 				slog.Group("request",
 					slog.String("id", requestID),
 					slog.String("method", r.Method),
-					slog.String("uri", r.URL.String()),
+					slog.String("uri", redactURI(r.URL)),
 				),
 			)
+			// Lets log lines be found from a trace, and the other way round
+			span := trace.SpanFromContext(r.Context())
+			if sc := span.SpanContext(); sc.IsValid() {
+				requestLogger = requestLogger.With(
+					slog.String("trace_id", sc.TraceID().String()),
+					slog.String("span_id", sc.SpanID().String()),
+				)
+			}
 			ctx := context.WithValue(r.Context(), "logger", requestLogger)
 			ctx = context.WithValue(ctx, "request_id", requestID)
 
+			// The mux fills in the pattern it matched on this request
+			r = r.WithContext(ctx)
 			respMetrics := httpsnoop.CaptureMetricsFn(w, func(w http.ResponseWriter) {
-				next.ServeHTTP(w, r.WithContext(ctx))
+				next.ServeHTTP(w, r)
 			})
 
+			route := routeOf(r)
+			span.SetName(r.Method + " " + route)
+			span.SetAttributes(
+				attribute.String("http.route", route),
+				attribute.Int("http.response.status_code", respMetrics.Code),
+			)
+			if respMetrics.Code >= 500 {
+				span.SetStatus(codes.Error, http.StatusText(respMetrics.Code))
+			}
+			metrics.HTTPRequests.WithLabelValues(r.Method, route, strconv.Itoa(respMetrics.Code)).Inc()
+			metrics.HTTPRequestDuration.WithLabelValues(r.Method, route).Observe(respMetrics.Duration.Seconds())
+
 			requestLogger.Info("handled request",
 				slog.Group("request",
 					slog.Int("code", respMetrics.Code),
 					slog.Int64("duration_ms", respMetrics.Duration.Milliseconds()),
 					slog.Int64("size", respMetrics.Written),
 					slog.String("id", requestID),
-					slog.String("ip", requestGetRemoteAddress(r)),
+					slog.String("ip", clientip.FromRequest(r)),
 					slog.String("method", r.Method),
 					slog.String("referer", r.Header.Get("Referer")),
-					slog.String("uri", r.URL.String()),
+					slog.String("route", route),
+					slog.String("uri", redactURI(r.URL)),
 					slog.String("user_agent", r.Header.Get("User-Agent")),
 				),
 			)
@@ -61,26 +91,31 @@ func LogRequest(logger *slog.Logger) func(http.Handler) http.Handler {
 	}
 }
 
-// requestGetRemoteAddress returns ip address of the client making the request,
-// taking into account http proxies
-func requestGetRemoteAddress(r *http.Request) string {
-	hdr := r.Header
-	hdrRealIP := hdr.Get("X-Real-Ip")
-	hdrForwardedFor := hdr.Get("X-Forwarded-For")
-	if hdrRealIP == "" && hdrForwardedFor == "" {
-		if colonLoc := strings.LastIndex(r.RemoteAddr, ":"); colonLoc == -1 {
-			return r.RemoteAddr
-		} else {
-			return r.RemoteAddr[:colonLoc]
-		}
+// routeOf is the route pattern a request matched, without its method, so
+// metrics have one series per route rather than per URL.
+func routeOf(r *http.Request) string {
+	if r.Pattern == "" {
+		return "unmatched"
 	}
-	if hdrForwardedFor != "" {
-		parts := strings.Split(hdrForwardedFor, ",")
-		for i, p := range parts {
-			parts[i] = strings.TrimSpace(p)
-		}
-		// TODO: should return first non-local address
-		return parts[0]
+	if _, path, ok := strings.Cut(r.Pattern, " "); ok {
+		return path
+	}
+	return r.Pattern
+}
+
+// redactURI hides feed tokens, which would give anyone reading the logs
+// access to private feeds.
+func redactURI(u *url.URL) string {
+	rest, ok := strings.CutPrefix(u.Path, "/f/")
+	if !ok {
+		return u.String()
+	}
+	redacted := *u
+	redacted.RawPath = ""
+	if _, after, found := strings.Cut(rest, "/"); found {
+		redacted.Path = "/f/REDACTED/" + after
+	} else {
+		redacted.Path = "/f/REDACTED"
 	}
-	return hdrRealIP
+	return redacted.String()
 }
@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"vpod/internal/ratelimit"
+)
+
+// RateLimit answers 429 Too Many Requests once the client, as told apart by
+// key, has used up its limit. A nil limiter lets everything through.
+func RateLimit(limiter *ratelimit.Limiter, key func(*http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			k := key(r)
+			if ok, wait := limiter.Allow(k); !ok {
+				logger := r.Context().Value("logger").(*slog.Logger)
+				logger.Warn("rate limited", slog.String("key", k), slog.Duration("retry_after", wait))
+
+				w.Header().Set("Retry-After", ratelimit.RetryAfter(wait))
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
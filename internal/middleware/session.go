@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"vpod/internal/accounts"
+	"vpod/internal/loginguard"
+	"vpod/internal/ratelimit"
+	"vpod/internal/sessions"
+)
+
+// NewSessionAuth signs requests in with the session in their cookie, and
+// falls back to Basic auth for scripts. Requests signed in with a session
+// have to carry its CSRF token unless they are safe. Browsers without
+// either are sent to the login page. Basic auth failures count towards the
+// guard's lockouts like those on the login page.
+//
+// Basic auth is never challenged for, so browsers don't remember the
+// credentials and send them along with cross-site requests that would skip
+// the CSRF check.
+func NewSessionAuth(users *accounts.Store, store *sessions.Store, guard *loginguard.Guard, loginPath string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			logger := ctx.Value("logger").(*slog.Logger)
+
+			if c, err := r.Cookie(sessions.CookieName); err == nil {
+				sess, err := store.Lookup(ctx, c.Value)
+				if err != nil && !errors.Is(err, sessions.ErrNotFound) {
+					logger.With(slog.String("err", err.Error())).Error("Could not look up session")
+					http.Error(w, "Internal server error", http.StatusInternalServerError)
+					return
+				}
+				if err == nil {
+					u, err := users.Get(ctx, sess.UserID)
+					if err != nil && !errors.Is(err, accounts.ErrUserNotFound) {
+						logger.With(slog.String("err", err.Error())).Error("Could not get session's user")
+						http.Error(w, "Internal server error", http.StatusInternalServerError)
+						return
+					}
+					if err == nil {
+						if !isSafe(r.Method) && !sess.CheckCSRF(r) {
+							http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+							return
+						}
+						ctx = sessions.WithSession(accounts.WithUser(ctx, u), sess)
+						next.ServeHTTP(w, r.WithContext(ctx))
+						return
+					}
+				}
+			}
+
+			if gotUser, gotPass, ok := r.BasicAuth(); ok {
+				if wait := guard.Check(r, gotUser); wait > 0 {
+					w.Header().Set("Retry-After", ratelimit.RetryAfter(wait))
+					http.Error(w, "Too many failed logins", http.StatusTooManyRequests)
+					return
+				}
+				u, err := users.Authenticate(ctx, gotUser, gotPass)
+				if errors.Is(err, accounts.ErrInvalidCredentials) {
+					guard.Failed(r, gotUser, "basic", err.Error())
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				} else if err != nil {
+					logger.With(slog.String("err", err.Error())).Error("Could not authenticate user")
+					http.Error(w, "Internal server error", http.StatusInternalServerError)
+					return
+				}
+				guard.Succeeded(gotUser)
+				next.ServeHTTP(w, r.WithContext(accounts.WithUser(ctx, u)))
+				return
+			}
+
+			login := loginPath + "?" + url.Values{"next": {r.URL.RequestURI()}}.Encode()
+			switch {
+			case r.Header.Get("HX-Request") != "":
+				// htmx won't follow a redirect for the whole page
+				w.Header().Set("HX-Redirect", login)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			case r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html"):
+				http.Redirect(w, r, login, http.StatusSeeOther)
+			default:
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			}
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+func isSafe(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
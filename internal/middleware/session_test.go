@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"vpod/internal/accounts"
+	"vpod/internal/loginguard"
+	"vpod/internal/sessions"
+)
+
+func TestSessionAuthNeverChallenges(t *testing.T) {
+	queries := initDb(t)
+	users := accounts.New(queries)
+	createUser(t, users, "alice", false)
+	guard := loginguard.New(slog.New(slog.DiscardHandler), loginguard.Config{
+		MaxFailures: 10,
+		Lockout:     time.Minute,
+		MaxLockout:  time.Hour,
+	})
+	h := NewSessionAuth(users, sessions.New(queries, time.Hour), guard, "/ui/login")(ok)
+
+	tests := []struct {
+		name     string
+		method   string
+		accept   string
+		password string // Basic auth is left out when empty
+		want     int
+	}{
+		{"browser", "GET", "text/html", "", http.StatusSeeOther},
+		{"script", "POST", "", "", http.StatusUnauthorized},
+		{"wrong password", "POST", "", "guess", http.StatusUnauthorized},
+		{"right password", "POST", "", "correct horse", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), "logger", slog.New(slog.DiscardHandler))
+			r := httptest.NewRequest(tt.method, "/ui/gen", nil).WithContext(ctx)
+			r.Header.Set("Accept", tt.accept)
+			if tt.password != "" {
+				r.SetBasicAuth("alice", tt.password)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			if got := w.Header().Get("WWW-Authenticate"); got != "" {
+				t.Errorf("WWW-Authenticate = %q, want none so browsers don't keep the credentials", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"vpod/internal/clientip"
+	"vpod/internal/tracing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Trace starts a server span for each request, continuing any trace the
+// caller sent in its traceparent header. LogRequest names the span after
+// the route once it's known, so it goes before LogRequest.
+func Trace() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			ctx, span := tracing.Tracer().Start(ctx, r.Method,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					attribute.String("http.request.method", r.Method),
+					attribute.String("url.path", redactURI(&url.URL{Path: r.URL.Path})),
+					attribute.String("client.address", clientip.FromRequest(r)),
+					attribute.String("user_agent.original", r.Header.Get("User-Agent")),
+				),
+			)
+			defer span.End()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
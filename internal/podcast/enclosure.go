@@ -0,0 +1,71 @@
+package podcast
+
+import (
+	"context"
+	"html"
+	"regexp"
+	"strconv"
+	"vpod/internal/data"
+	"vpod/internal/youtube"
+)
+
+// formatLength is yt-dlp's idea of how big a format is. Filesize is often
+// missing, in which case it only has an estimate.
+func formatLength(f youtube.VideoFormat) int64 {
+	if f.Filesize > 0 {
+		return f.Filesize
+	}
+	return f.FilesizeApprox
+}
+
+// applyMeasurements replaces the lengths and durations yt-dlp reported with
+// those of files we have actually served.
+func (p *Podcast) applyMeasurements(ctx context.Context, queries *data.Queries) error {
+	measured, err := queries.GetMeasuredEnclosuresForFeed(ctx, p.Id)
+	if err != nil {
+		return err
+	}
+	if len(measured) == 0 {
+		return nil
+	}
+
+	byURL := make(map[string]data.GetMeasuredEnclosuresForFeedRow, len(measured))
+	for _, m := range measured {
+		byURL[m.Url] = m
+	}
+	for _, i := range p.Items {
+		if i.Enclosure == nil {
+			continue
+		}
+		if m, ok := byURL[i.Enclosure.URL]; ok {
+			// Items are formatted as they're added, so set both
+			i.Enclosure.Length = m.LengthBytes
+			i.Enclosure.LengthFormatted = strconv.FormatInt(m.LengthBytes, 10)
+			if m.DurationSeconds.Valid {
+				i.AddDuration(m.DurationSeconds.Int64)
+			}
+		}
+		if v, ok := p.videos[i.GUID]; ok {
+			if m, ok := byURL[v.URL]; ok {
+				v.Length = m.LengthBytes
+				p.videos[i.GUID] = v
+			}
+		}
+	}
+	return nil
+}
+
+// enclosureAttr matches the attributes enclosure URLs are written in, the
+// url of enclosures and the uri of alternate enclosure sources.
+var enclosureAttr = regexp.MustCompile(`(<(?:enclosure|podcast:source)\s[^>]*?\b(?:url|uri)=")([^"]*)(")`)
+
+// RewriteEnclosures replaces each enclosure URL in an encoded feed with
+// fn's result. Only enclosures are touched, guids that happen to be the
+// same URL stay put so apps don't see episodes as new.
+func RewriteEnclosures(feedXML string, fn func(string) string) string {
+	return enclosureAttr.ReplaceAllStringFunc(feedXML, func(m string) string {
+		parts := enclosureAttr.FindStringSubmatch(m)
+		u := fn(html.UnescapeString(parts[2]))
+		return parts[1] + html.EscapeString(u) + parts[3]
+	})
+}
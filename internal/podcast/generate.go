@@ -0,0 +1,134 @@
+package podcast
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/url"
+	"strings"
+	"vpod/internal/cookies"
+	"vpod/internal/data"
+	"vpod/internal/youtube"
+)
+
+// Generate creates the feed for a YouTube channel, or rebuilds it if it
+// already exists.
+func Generate(
+	ctx context.Context,
+	channelURL string,
+	baseURL *url.URL,
+	logger *slog.Logger,
+	queries *data.Queries,
+	jars *cookies.Store,
+) (*Podcast, error) {
+	logger.Info("generating feed")
+	ytURL, err := url.Parse(channelURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Feeds are shared between users, so a channel someone already added
+	// isn't fetched again
+	if feedID, ok := channelID(ytURL); ok {
+		_, err := queries.GetFeedXML(ctx, []byte(feedID))
+		if err == nil {
+			logger.Info("feed already exists", slog.String("feed_id", feedID))
+			feedOpts, err := FeedOptions(ctx, queries, feedID)
+			if err != nil {
+				return nil, err
+			}
+			return Rebuild(ctx, queries, feedID, feedOpts...)
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+	}
+
+	// The feed doesn't exist yet, so it can only have the global jar
+	cookiesPath, saveCookies, err := jars.File(ctx, cookies.GlobalJar)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := saveCookies(ctx); err != nil {
+			logger.Warn("could not save cookie jar", slog.String("err", err.Error()))
+		}
+	}()
+	opts := []youtube.FetchChannelOption{youtube.WithNItems(20)}
+	if cookiesPath != "" {
+		opts = append(opts, youtube.WithCookies(cookiesPath))
+	}
+
+	c, err := youtube.FetchChannel(ctx, ytURL, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Regenerating an existing feed keeps its settings
+	feedOpts, err := FeedOptions(ctx, queries, c.Id)
+	if err != nil {
+		return nil, err
+	}
+	p, err := FromChannel(*c, *baseURL, feedOpts...) // TODO: decide what to do about PubDate
+	if err != nil {
+		return nil, err
+	}
+
+	err = UpsertPodcast(queries, *p, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// channelID gets the id out of channel urls like
+// https://www.youtube.com/channel/UC.../videos. Handles and custom urls
+// can't be resolved without asking YouTube.
+func channelID(u *url.URL) (string, bool) {
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "channel" || !strings.HasPrefix(parts[1], "UC") {
+		return "", false
+	}
+	return parts[1], true
+}
+
+var ErrFeedNotFound = errors.New("feed not found")
+
+// Delete removes a feed, its episodes and everything else kept for it, all
+// at once. Pins go too, unless another feed has the video. Downloaded audio
+// stays until it is culled.
+func Delete(ctx context.Context, db *sql.DB, queries *data.Queries, feedID string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	queries = queries.WithTx(tx)
+
+	if _, err := queries.GetFeedXML(ctx, []byte(feedID)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrFeedNotFound
+		}
+		return err
+	}
+	deletes := []func(context.Context, string) error{
+		// Before the episodes, which say which videos are the feed's
+		queries.DeleteFeedEpisodePins,
+		queries.DeleteFeedEpisodes,
+		queries.DeleteFeedSettings,
+		queries.DeleteFeedTokens,
+		queries.DeleteFeedUsers,
+		queries.DeleteWebSubSubscription,
+		queries.DeleteCookieJar,
+	}
+	for _, del := range deletes {
+		if err := del(ctx, feedID); err != nil {
+			return err
+		}
+	}
+	if err := queries.DeleteFeed(ctx, []byte(feedID)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,89 @@
+//go:build !integration
+
+package podcast
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestDelete(t *testing.T) {
+	db, queries, err := initDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	setup := []string{
+		`INSERT INTO Feeds (id, title, link, xml) VALUES (CAST('delete-me' AS BLOB), 't', 'l', '<rss/>')`,
+		`INSERT INTO Episodes (id, audio_url, audio_length_bytes, feed_id, title, video_id) VALUES (CAST('vid' AS BLOB), 'u', 0, 'delete-me', 't', 'vid')`,
+		`INSERT INTO FeedTokens (feed_id, name, token_hash) VALUES ('delete-me', 'phone', 'hash')`,
+		`INSERT INTO UserFeeds (user_id, feed_id) VALUES (1, 'delete-me')`,
+		`INSERT INTO Episodes (id, audio_url, audio_length_bytes, feed_id, title, video_id) VALUES (CAST('shared' AS BLOB), 'u', 0, 'delete-me', 't', 'shared')`,
+		`INSERT INTO Episodes (id, audio_url, audio_length_bytes, feed_id, title, video_id) VALUES (CAST('shared' AS BLOB), 'u', 0, 'keep-me', 't', 'shared')`,
+		`INSERT INTO EpisodePins (video_id) VALUES ('vid'), ('shared')`,
+	}
+	for _, stmt := range setup {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := Delete(ctx, db, queries, "delete-me"); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"Episodes", "FeedTokens", "UserFeeds"} {
+		var n int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM ` + table + ` WHERE feed_id = 'delete-me'`).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Errorf("%d rows left in %s", n, table)
+		}
+	}
+
+	// The other feed still has the shared video, so its pin stays
+	pins, err := queries.GetPinnedVideoIds(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pins) != 1 || pins[0] != "shared" {
+		t.Errorf("pins left = %v, want [shared]", pins)
+	}
+
+	if err := Delete(ctx, db, queries, "delete-me"); !errors.Is(err, ErrFeedNotFound) {
+		t.Errorf("deleting a missing feed = %v, want ErrFeedNotFound", err)
+	}
+}
+
+func TestDeleteIsAtomic(t *testing.T) {
+	db, queries, err := initDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	setup := []string{
+		`INSERT INTO Feeds (id, title, link, xml) VALUES (CAST('stuck' AS BLOB), 't', 'l', '<rss/>')`,
+		`INSERT INTO Episodes (id, audio_url, audio_length_bytes, feed_id, title, video_id) VALUES (CAST('vid' AS BLOB), 'u', 0, 'stuck', 't', 'stuckvid')`,
+		// The last delete fails, after everything else was deleted
+		`CREATE TRIGGER stuck_feed BEFORE DELETE ON Feeds WHEN old.id = CAST('stuck' AS BLOB) BEGIN SELECT RAISE(ABORT, 'stuck'); END`,
+	}
+	for _, stmt := range setup {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := Delete(ctx, db, queries, "stuck"); err == nil {
+		t.Fatal("Delete() succeeded despite the trigger")
+	}
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM Episodes WHERE feed_id = 'stuck'`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("%d episodes left after a failed delete, want 1", n)
+	}
+}
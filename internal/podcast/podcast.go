@@ -13,6 +13,7 @@ import (
 type options struct {
 	pubDate       *time.Time
 	lastBuildDate *time.Time
+	media         Media
 }
 
 type Option func(options *options) error
@@ -27,6 +28,10 @@ func WithPubDate(p time.Time) Option {
 type Podcast struct {
 	*podcast.Podcast
 	Id string
+
+	media Media
+	// videos are the video variants of items, keyed by guid
+	videos map[string]Variant
 }
 
 func New(
@@ -81,9 +86,15 @@ func New(
 		&lastBuildDate,
 	)
 
+	media := options.media
+	if media == "" {
+		media = MediaAudio
+	}
+
 	return &Podcast{
 		Id:      id,
 		Podcast: &p,
+		media:   media,
 	}, nil
 }
 
@@ -126,7 +137,7 @@ func FromChannel(c youtube.Channel, baseURL url.URL, opts ...Option) (*Podcast,
 			if is_english && audio_only && correct_ext && no_drm && no_dynamic_range_compression {
 				acceptable_file_found = true
 				enclosureUrl = baseURL.JoinPath("audio", v.Id, f.Id).String()
-				enclosureLengthBytes = f.Filesize
+				enclosureLengthBytes = formatLength(f)
 				break
 			}
 		}
@@ -156,6 +167,15 @@ func FromChannel(c youtube.Channel, baseURL url.URL, opts ...Option) (*Podcast,
 		if _, err := p.AddItem(item); err != nil {
 			return nil, err
 		}
+
+		if f, ok := videoFormat(v); ok {
+			// The library uses the enclosure url as the guid
+			p.setVideo(enclosureUrl, Variant{
+				URL:    videoURL(baseURL, v.Id, f.Id),
+				Length: formatLength(f),
+				Height: f.Height,
+			})
+		}
 	}
 
 	return p, nil
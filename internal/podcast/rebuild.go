@@ -0,0 +1,79 @@
+package podcast
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net/url"
+	"time"
+	"vpod/internal/data"
+)
+
+// storedChannel is the channel level data of a feed we've already encoded,
+// which isn't kept anywhere else.
+type storedChannel struct {
+	Channel struct {
+		Title       string `xml:"title"`
+		Link        string `xml:"link"`
+		Description string `xml:"description"`
+		PubDate     string `xml:"pubDate"`
+		Author      string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd author"`
+		Summary     string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd summary"`
+		Image       struct {
+			HREF string `xml:"href,attr"`
+		} `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+	} `xml:"channel"`
+}
+
+// Rebuild regenerates a feed from what is in the DB, without asking YouTube
+// for anything. It's for changes that don't come from the channel, such as a
+// feed's settings or what we learn from downloading its episodes.
+func Rebuild(ctx context.Context, queries *data.Queries, feedID string, opts ...Option) (*Podcast, error) {
+	feedXML, err := queries.GetFeedXML(ctx, []byte(feedID))
+	if err != nil {
+		return nil, err
+	}
+	var stored storedChannel
+	if err := xml.Unmarshal([]byte(feedXML), &stored); err != nil {
+		return nil, err
+	}
+	c := stored.Channel
+
+	link, err := url.Parse(c.Link)
+	if err != nil {
+		return nil, err
+	}
+	if pubDate, err := time.Parse(time.RFC1123Z, c.PubDate); err == nil {
+		opts = append([]Option{WithPubDate(pubDate)}, opts...)
+	}
+	p, err := New(feedID, c.Title, *link, c.Description, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Already formatted, so set rather than added
+	p.ManagingEditor = c.Author
+	p.IAuthor = c.Author
+	p.AddImage(c.Image.HREF)
+	p.AddSummary(c.Summary)
+	p.IExplicit = "no"
+	p.IBlock = "Yes"
+	p.Generator = "vpod"
+
+	eps, err := queries.GetNewestEpisodesForFeed(ctx, data.GetNewestEpisodesForFeedParams{
+		FeedID: feedID,
+		Limit:  -1, // no limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(eps) == 0 {
+		return nil, errors.New("feed has no episodes to rebuild from")
+	}
+	for _, ep := range eps {
+		if err := p.addEpisode(ep); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
@@ -2,45 +2,71 @@ package podcast
 
 import (
 	"context"
+	"database/sql"
 	"errors"
+	"time"
 	"vpod/internal/data"
 
 	"github.com/eduncan911/podcast"
 )
 
+// addEpisode adds an episode from the DB as an item.
+func (p *Podcast) addEpisode(ep data.Episode) error {
+	item := podcast.Item{
+		Title:       ep.Title,
+		Description: ep.Description.String,
+		Link:        ep.VideoUrl.String,
+	}
+	item.AddPubDate(&ep.ReleasedAt.Time)
+	item.AddDuration(ep.Duration.Int64)
+	item.AddImage(ep.Thumbnail.String)
+	item.AddEnclosure(ep.AudioUrl, podcast.M4A, ep.AudioLengthBytes)
+
+	if _, err := p.AddItem(item); err != nil {
+		return err
+	}
+	if ep.VideoEnclosureUrl.Valid {
+		p.setVideo(ep.AudioUrl, Variant{
+			URL:    ep.VideoEnclosureUrl.String,
+			Length: ep.VideoEnclosureLengthBytes.Int64,
+		})
+	}
+	return nil
+}
+
 func (p Podcast) AppendOldEps(ctx context.Context) (*Podcast, error) {
 	queries, ok := ctx.Value("queries").(*data.Queries)
 	if !ok {
 		return nil, errors.New("could not get queries from ctx")
 	}
 
-	numItems := len(p.Items)
-	if numItems < 1 {
-		return nil, errors.New("detected 0 episodes in feed")
+	// A fetch can come back empty when all that changed are videos that
+	// aren't episodes, like upcoming premieres. Every stored episode is old
+	// then.
+	cutoff := time.Now().UTC()
+	if numItems := len(p.Items); numItems > 0 {
+		latestEp := p.Items[numItems-1]
+		if latestEp.PubDate == nil {
+			return nil, errors.New("oldest episode in feed has no PubDate")
+		}
+		cutoff = *latestEp.PubDate
 	}
 
-	latestEp := p.Items[numItems-1]
-
+	// Compare on release time rather than looking the episode up, since it
+	// may not be in the DB yet
 	oldEps, err := queries.GetOlderEpisodesForFeed(ctx, data.GetOlderEpisodesForFeedParams{
 		FeedID: p.Id,
-		ID:     []byte(latestEp.GUID),
+		ReleasedAt: sql.NullTime{
+			Time:  cutoff,
+			Valid: true,
+		},
 	})
 	if err != nil {
 		return nil, err
 	}
 
 	for _, ep := range oldEps {
-		item := podcast.Item{
-			Title:       ep.Title,
-			Description: ep.Description.String,
-			Link:        ep.VideoUrl.String,
-		}
-		item.AddPubDate(&ep.ReleasedAt.Time)
-		item.AddDuration(ep.Duration.Int64)
-		item.AddImage(ep.Thumbnail.String)
-		item.AddEnclosure(ep.AudioUrl, podcast.M4A, ep.AudioLengthBytes)
-
-		if _, err := p.AddItem(item); err != nil {
+		if err := p.addEpisode(ep); err != nil {
 			return nil, err
 		}
 	}
@@ -6,20 +6,34 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 	"vpod/internal/data"
+	"vpod/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
-func UpsertPodcast(queries *data.Queries, p Podcast, ctx context.Context) error {
+func UpsertPodcast(queries *data.Queries, p Podcast, ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "upsert podcast",
+		attribute.String("feed_id", p.Id),
+		attribute.Int("items", len(p.Items)),
+	)
+	defer func() { tracing.End(span, err) }()
+
 	pubDate, err := time.Parse(time.RFC1123Z, p.PubDate)
 	if err != nil {
 		return errors.New("could not parse podcast PubDate as RFC1123Z")
 	}
 
+	if err := p.applyMeasurements(ctx, queries); err != nil {
+		return err
+	}
+
 	for _, i := range p.Items {
-		err = upsertEpisode(i, &p.Id, queries, ctx)
+		err = p.upsertEpisode(i, queries, ctx)
 		if err != nil {
 			return err
 		}
@@ -44,9 +58,8 @@ func UpsertPodcast(queries *data.Queries, p Podcast, ctx context.Context) error
 	})
 }
 
-func upsertEpisode(
+func (p *Podcast) upsertEpisode(
 	ep *Item,
-	feedID *string,
 	queries *data.Queries,
 	ctx context.Context,
 ) error {
@@ -55,6 +68,20 @@ func upsertEpisode(
 		return err
 	}
 
+	videoID, err := enclosureVideoID(ep.Enclosure.URL)
+	if err != nil {
+		return err
+	}
+
+	var (
+		videoURL    sql.NullString
+		videoLength sql.NullInt64
+	)
+	if v, ok := p.Video(ep.GUID); ok {
+		videoURL = sql.NullString{String: v.URL, Valid: true}
+		videoLength = sql.NullInt64{Int64: v.Length, Valid: true}
+	}
+
 	err = queries.UpsertEpisode(ctx, data.UpsertEpisodeParams{
 		ID:               []byte(ep.GUID), // TODO: make sure this is set to videoid
 		AudioUrl:         ep.Enclosure.URL,
@@ -64,7 +91,7 @@ func upsertEpisode(
 			Int64: duration,
 			Valid: true,
 		},
-		FeedID: *feedID,
+		FeedID: p.Id,
 		ReleasedAt: sql.NullTime{
 			Time:  *ep.PubDate,
 			Valid: true,
@@ -78,10 +105,27 @@ func upsertEpisode(
 			String: ep.Link,
 			Valid:  true,
 		},
+		VideoEnclosureUrl:         videoURL,
+		VideoEnclosureLengthBytes: videoLength,
+		VideoID:                   videoID,
 	})
 	return err
 }
 
+// enclosureVideoID gets the video id out of an enclosure url, which ends in
+// /audio/<video id>/<format id>.
+func enclosureVideoID(enclosureURL string) (string, error) {
+	u, err := url.Parse(enclosureURL)
+	if err != nil {
+		return "", err
+	}
+	parts := strings.Split(u.Path, "/")
+	if len(parts) < 3 || parts[len(parts)-3] != "audio" {
+		return "", fmt.Errorf("enclosure %s is not a vpod audio url", enclosureURL)
+	}
+	return parts[len(parts)-2], nil
+}
+
 func durationStrToInt(d string) (int64, error) {
 	var h, m, s int64
 	var err error
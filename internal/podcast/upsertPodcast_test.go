@@ -26,7 +26,7 @@ func initDb() (*sql.DB, *data.Queries, error) {
 	db.SetConnMaxLifetime(5 * time.Minute)
 
 	// create tables
-	if _, err := db.ExecContext(context.Background(), data.DDL); err != nil {
+	if err := data.Migrate(context.Background(), db); err != nil {
 		return nil, nil, err
 	}
 
@@ -124,3 +124,38 @@ func Test_upsertPodcast(t *testing.T) {
 		})
 	}
 }
+
+func TestAppendOldEpsWithoutNewItems(t *testing.T) {
+	_, queries, err := initDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), "queries", queries)
+
+	released := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	err = queries.UpsertEpisode(ctx, data.UpsertEpisodeParams{
+		ID:          []byte("https://vpod.local/audio/dQw4w9WgXcQ/140"),
+		AudioUrl:    "https://vpod.local/audio/dQw4w9WgXcQ/140",
+		Description: sql.NullString{String: "An episode", Valid: true},
+		FeedID:      "UCpremieres",
+		ReleasedAt:  sql.NullTime{Time: released, Valid: true},
+		Title:       "Stored",
+		VideoID:     "dQw4w9WgXcQ",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A fetch of nothing but upcoming premieres has no items
+	p, err := New("UCpremieres", "Premieres", url.URL{Scheme: "https", Host: "youtube.com"}, "desc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := p.AppendOldEps(ctx)
+	if err != nil {
+		t.Fatalf("AppendOldEps() of an empty fetch err = %v", err)
+	}
+	if len(got.Items) != 1 || got.Items[0].Title != "Stored" {
+		t.Errorf("AppendOldEps() items = %+v, want the stored episode", got.Items)
+	}
+}
@@ -0,0 +1,209 @@
+package podcast
+
+import (
+	"context"
+	"database/sql"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"vpod/internal/data"
+	"vpod/internal/youtube"
+
+	"github.com/eduncan911/podcast"
+)
+
+// podcastNS is the Podcasting 2.0 namespace, for alternateEnclosure.
+const podcastNS = "https://podcastindex.org/namespace/1.0"
+
+// Media is what a feed uses as its items' main enclosure.
+type Media string
+
+const (
+	MediaAudio Media = "audio"
+	MediaVideo Media = "video"
+)
+
+func ParseMedia(s string) (Media, error) {
+	switch m := Media(s); m {
+	case MediaAudio, MediaVideo:
+		return m, nil
+	}
+	return "", fmt.Errorf("invalid media %q: must be one of audio or video", s)
+}
+
+// WithMedia sets what the feed uses as its items' main enclosure. Items
+// without a video format fall back to audio.
+func WithMedia(m Media) Option {
+	return func(options *options) error {
+		options.media = m
+		return nil
+	}
+}
+
+// FeedOptions returns the options a feed has been configured with.
+func FeedOptions(ctx context.Context, queries *data.Queries, feedID string) ([]Option, error) {
+	media, err := queries.GetFeedMedia(ctx, feedID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	m, err := ParseMedia(media)
+	if err != nil {
+		return nil, err
+	}
+	return []Option{WithMedia(m)}, nil
+}
+
+// Variant is a video version of an item, offered alongside its audio.
+type Variant struct {
+	URL    string
+	Length int64
+	Height int
+}
+
+func (v Variant) enclosure() *podcast.Enclosure {
+	return &podcast.Enclosure{
+		URL:             v.URL,
+		Length:          v.Length,
+		LengthFormatted: strconv.FormatInt(v.Length, 10),
+		Type:            podcast.MP4,
+		TypeFormatted:   podcast.MP4.String(),
+	}
+}
+
+// videoFormat picks the best muxed mp4 a podcast app can play straight
+// from /video, or returns false if there isn't one.
+func videoFormat(v youtube.Video) (youtube.VideoFormat, bool) {
+	var best youtube.VideoFormat
+	found := false
+	for _, f := range v.Formats {
+		muxed := f.VideoCodec != "" && f.VideoCodec != "none" &&
+			f.AudioCodec != "" && f.AudioCodec != "none"
+		// Manifests can't be served as a single file
+		direct := f.Protocol == "https"
+		if muxed && direct && f.Ext == "mp4" && !f.Drm && (!found || f.Height > best.Height) {
+			best = f
+			found = true
+		}
+	}
+	return best, found
+}
+
+// setVideo records the video variant of the item with the given guid.
+func (p *Podcast) setVideo(guid string, v Variant) {
+	if p.videos == nil {
+		p.videos = make(map[string]Variant)
+	}
+	p.videos[guid] = v
+}
+
+// Video returns the video variant of the item with the given guid.
+func (p *Podcast) Video(guid string) (Variant, bool) {
+	v, ok := p.videos[guid]
+	return v, ok
+}
+
+type alternateEnclosure struct {
+	XMLName xml.Name `xml:"podcast:alternateEnclosure"`
+	Type    string   `xml:"type,attr"`
+	Length  int64    `xml:"length,attr,omitempty"`
+	Height  int      `xml:"height,attr,omitempty"`
+	Title   string   `xml:"title,attr"`
+	Default bool     `xml:"default,attr,omitempty"`
+	Source  struct {
+		URI string `xml:"uri,attr"`
+	} `xml:"podcast:source"`
+}
+
+// rss, channel and item wrap the library's types to add the Podcasting 2.0
+// namespace and alternate enclosures, which it has no fields for. Items
+// shadows the channel's own items.
+type rss struct {
+	XMLName   xml.Name `xml:"rss"`
+	Version   string   `xml:"version,attr"`
+	AtomNS    string   `xml:"xmlns:atom,attr,omitempty"`
+	ITunesNS  string   `xml:"xmlns:itunes,attr"`
+	PodcastNS string   `xml:"xmlns:podcast,attr"`
+	Channel   channel
+}
+
+type channel struct {
+	*podcast.Podcast
+	Items []item
+}
+
+type item struct {
+	*podcast.Item
+	Alternates []alternateEnclosure
+}
+
+// alternates returns the alternateEnclosure elements for an item, marking
+// whichever is its main enclosure as the default.
+func (p *Podcast) alternates(audio *podcast.Enclosure, video Variant) []alternateEnclosure {
+	a := alternateEnclosure{
+		Type:    audio.TypeFormatted,
+		Length:  audio.Length,
+		Title:   "Audio",
+		Default: p.media != MediaVideo,
+	}
+	a.Source.URI = audio.URL
+	v := alternateEnclosure{
+		Type:    podcast.MP4.String(),
+		Length:  video.Length,
+		Height:  video.Height,
+		Title:   "Video",
+		Default: p.media == MediaVideo,
+	}
+	v.Source.URI = video.URL
+	return []alternateEnclosure{a, v}
+}
+
+// Encode writes the feed as RSS. Items with a video variant offer both it
+// and their audio as Podcasting 2.0 alternate enclosures, and use the video
+// as their main enclosure in video feeds.
+func (p *Podcast) Encode(w io.Writer) error {
+	if len(p.videos) == 0 {
+		return p.Podcast.Encode(w)
+	}
+
+	c := channel{Podcast: p.Podcast}
+	for _, i := range p.Items {
+		it := item{Item: i}
+		if video, ok := p.videos[i.GUID]; ok && i.Enclosure != nil {
+			it.Alternates = p.alternates(i.Enclosure, video)
+			if p.media == MediaVideo {
+				// A copy, items keep their audio for the DB
+				swapped := *i
+				swapped.Enclosure = video.enclosure()
+				it.Item = &swapped
+			}
+		}
+		c.Items = append(c.Items, it)
+	}
+
+	// The same document the library writes, plus the namespace
+	feed := rss{
+		Version:   "2.0",
+		ITunesNS:  "http://www.itunes.com/dtds/podcast-1.0.dtd",
+		PodcastNS: podcastNS,
+		Channel:   c,
+	}
+	if p.AtomLink != nil {
+		feed.AtomNS = "http://www.w3.org/2005/Atom"
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(feed)
+}
+
+// videoURL returns where vpod serves the given format of a video.
+func videoURL(baseURL url.URL, videoID, formatID string) string {
+	return baseURL.JoinPath("video", videoID, formatID).String()
+}
@@ -0,0 +1,169 @@
+//go:build !integration
+
+package podcast
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"net/url"
+	"testing"
+	"time"
+	"vpod/internal/youtube"
+)
+
+// encodedFeed is the part of an encoded feed the video tests look at.
+type encodedFeed struct {
+	Channel struct {
+		Items []struct {
+			GUID      string `xml:"guid"`
+			Enclosure struct {
+				URL  string `xml:"url,attr"`
+				Type string `xml:"type,attr"`
+			} `xml:"enclosure"`
+			Alternates []struct {
+				Type    string `xml:"type,attr"`
+				Default bool   `xml:"default,attr"`
+				Source  struct {
+					URI string `xml:"uri,attr"`
+				} `xml:"https://podcastindex.org/namespace/1.0 source"`
+			} `xml:"https://podcastindex.org/namespace/1.0 alternateEnclosure"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
+
+func videoChannel(id string) youtube.Channel {
+	released := time.Date(2023, 5, 15, 12, 0, 0, 0, time.UTC)
+	return youtube.Channel{
+		Id:     id,
+		Title:  "Talks - Videos",
+		Author: "Talks",
+		URL:    url.URL{Scheme: "https", Host: "youtube.com", Path: "/channel/" + id},
+		Videos: []youtube.Video{
+			{
+				Id:               "talk1",
+				Title:            "Talk 1",
+				Url:              "https://youtube.com/watch?v=talk1",
+				Duration:         300,
+				ReleaseTimestamp: youtube.UnixTime{Time: released},
+				Formats: []youtube.VideoFormat{
+					{Id: "140", Resolution: "audio only", AudioExt: "m4a", Language: "en", Filesize: 5000},
+					{Id: "18", Ext: "mp4", Protocol: "https", AudioCodec: "mp4a.40.2", VideoCodec: "avc1.42001E", Height: 360, Filesize: 20000},
+					{Id: "22", Ext: "mp4", Protocol: "https", AudioCodec: "mp4a.40.2", VideoCodec: "avc1.64001F", Height: 720, Filesize: 40000},
+					// Higher, but not something a podcast app can play
+					{Id: "95", Ext: "mp4", Protocol: "m3u8_native", AudioCodec: "mp4a.40.2", VideoCodec: "avc1.4d401f", Height: 1080},
+					{Id: "137", Ext: "mp4", Protocol: "https", AudioCodec: "none", VideoCodec: "avc1.640028", Height: 1080},
+				},
+			},
+			{
+				Id:               "short1",
+				Title:            "Audio only",
+				Url:              "https://youtube.com/watch?v=short1",
+				Duration:         60,
+				ReleaseTimestamp: youtube.UnixTime{Time: released.Add(-time.Hour)},
+				Formats: []youtube.VideoFormat{
+					{Id: "140", Resolution: "audio only", AudioExt: "m4a", Language: "en", Filesize: 1000},
+				},
+			},
+		},
+	}
+}
+
+func decodeFeed(t *testing.T, p *Podcast) encodedFeed {
+	t.Helper()
+	var b bytes.Buffer
+	if err := p.Encode(&b); err != nil {
+		t.Fatal(err)
+	}
+	var feed encodedFeed
+	if err := xml.Unmarshal(b.Bytes(), &feed); err != nil {
+		t.Fatalf("could not decode feed: %v\n%s", err, b.String())
+	}
+	if len(feed.Channel.Items) != 2 {
+		t.Fatalf("expected 2 items, got %d", len(feed.Channel.Items))
+	}
+	return feed
+}
+
+func TestVideoEnclosures(t *testing.T) {
+	baseURL := url.URL{Scheme: "https", Host: "vpod.example.com"}
+	const (
+		audioURL = "https://vpod.example.com/audio/talk1/140"
+		videoURL = "https://vpod.example.com/video/talk1/22"
+	)
+
+	tests := []struct {
+		name          string
+		opts          []Option
+		wantEnclosure string
+		wantType      string
+	}{
+		{"audio feed", nil, audioURL, "audio/x-m4a"},
+		{"video feed", []Option{WithMedia(MediaVideo)}, videoURL, "video/mp4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := FromChannel(videoChannel("UCtalks"), baseURL, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			feed := decodeFeed(t, p)
+
+			talk := feed.Channel.Items[0]
+			if talk.GUID != audioURL {
+				t.Errorf("guid should stay the audio url, got %s", talk.GUID)
+			}
+			if talk.Enclosure.URL != tt.wantEnclosure || talk.Enclosure.Type != tt.wantType {
+				t.Errorf("enclosure = %+v, want %s (%s)", talk.Enclosure, tt.wantEnclosure, tt.wantType)
+			}
+			if len(talk.Alternates) != 2 {
+				t.Fatalf("expected audio and video alternates, got %+v", talk.Alternates)
+			}
+			for _, a := range talk.Alternates {
+				if a.Default != (a.Source.URI == tt.wantEnclosure) {
+					t.Errorf("only the main enclosure should be the default, got %+v", a)
+				}
+			}
+
+			short := feed.Channel.Items[1]
+			if short.Enclosure.Type != "audio/x-m4a" || len(short.Alternates) != 0 {
+				t.Errorf("items without video should only have audio, got %+v", short)
+			}
+
+			// Encoding must not leave the video enclosure behind for the DB
+			if p.Items[0].Enclosure.URL != audioURL {
+				t.Errorf("item enclosure was left as %s", p.Items[0].Enclosure.URL)
+			}
+		})
+	}
+}
+
+func TestRebuildSwitchesMedia(t *testing.T) {
+	_, queries, err := initDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	baseURL := url.URL{Scheme: "https", Host: "vpod.example.com"}
+
+	p, err := FromChannel(videoChannel("UCrebuild"), baseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := UpsertPodcast(queries, *p, ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	rebuilt, err := Rebuild(ctx, queries, "UCrebuild", WithMedia(MediaVideo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rebuilt.Title != p.Title || rebuilt.IAuthor != p.IAuthor || rebuilt.IImage.HREF != p.IImage.HREF {
+		t.Errorf("channel details were not kept: got %q %q %q", rebuilt.Title, rebuilt.IAuthor, rebuilt.IImage.HREF)
+	}
+
+	feed := decodeFeed(t, rebuilt)
+	if got := feed.Channel.Items[0].Enclosure.URL; got != "https://vpod.example.com/video/talk1/22" {
+		t.Errorf("rebuilt feed should use video, got %s", got)
+	}
+}
@@ -0,0 +1,25 @@
+// Package proc runs the programs vpod shells out to, so that stopping one
+// also stops everything it started, like the ffmpeg runs of yt-dlp.
+package proc
+
+import (
+	"context"
+	"os/exec"
+	"time"
+)
+
+// GracePeriod is how long a program has to exit once asked to, before it
+// is killed.
+const GracePeriod = 5 * time.Second
+
+// Command is like exec.CommandContext, except that when ctx is done the
+// program and its children are asked to stop with SIGTERM, and killed if
+// they haven't after GracePeriod.
+func Command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	// Past the group's SIGKILL, so that's what stops it rather than Wait
+	// killing the leader alone
+	cmd.WaitDelay = GracePeriod + time.Second
+	stopGroup(cmd)
+	return cmd
+}
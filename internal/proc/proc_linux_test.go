@@ -0,0 +1,88 @@
+package proc
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommandStopsChildren(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "child.pid")
+	ctx, cancel := context.WithCancel(context.Background())
+	// The shell waits on a child of its own, as yt-dlp does on ffmpeg
+	cmd := Command(ctx, "sh", "-c", `sleep 60 & echo $! > "$1"; wait`, "sh", pidFile)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	child := readPid(t, pidFile)
+
+	start := time.Now()
+	cancel()
+	if err := cmd.Wait(); err == nil {
+		t.Error("cancelled command exited cleanly")
+	}
+	if waited := time.Since(start); waited >= GracePeriod {
+		t.Errorf("took %v to stop, SIGTERM should have been enough", waited)
+	}
+	for deadline := time.Now().Add(time.Second); running(child); {
+		if time.Now().After(deadline) {
+			t.Fatal("child outlived the command")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCommandKillsChildrenIgnoringSIGTERM(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "child.pid")
+	ctx, cancel := context.WithCancel(context.Background())
+	// The shell exits on SIGTERM, its child doesn't
+	cmd := Command(ctx, "sh", "-c", `(trap "" TERM; exec sleep 60) & echo $! > "$1"; wait`, "sh", pidFile)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	child := readPid(t, pidFile)
+
+	start := time.Now()
+	cancel()
+	cmd.Wait()
+	if !running(child) {
+		t.Fatal("child stopped on SIGTERM, it should have ignored it")
+	}
+	for deadline := start.Add(GracePeriod + time.Second); running(child); {
+		if time.Now().After(deadline) {
+			t.Fatal("child outlived the grace period")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// readPid waits for the pid the shell writes to path.
+func readPid(t *testing.T, path string) int {
+	t.Helper()
+	var pid int
+	for deadline := time.Now().Add(5 * time.Second); pid == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("child never started")
+		}
+		b, _ := os.ReadFile(path)
+		pid, _ = strconv.Atoi(strings.TrimSpace(string(b)))
+		time.Sleep(10 * time.Millisecond)
+	}
+	return pid
+}
+
+// running reports whether pid is alive. Zombies aren't, they're only
+// waiting to be reaped by whichever process inherited them.
+func running(pid int) bool {
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return false
+	}
+	_, rest, _ := strings.Cut(string(stat), ") ")
+	return !strings.HasPrefix(rest, "Z")
+}
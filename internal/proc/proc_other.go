@@ -0,0 +1,9 @@
+//go:build !unix
+
+package proc
+
+import "os/exec"
+
+// stopGroup leaves cmd to be killed on its own, as there are no process
+// groups to signal.
+func stopGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,25 @@
+//go:build unix
+
+package proc
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// stopGroup runs cmd in a process group of its own, and signals the whole
+// group when it's cancelled.
+func stopGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		pgid := cmd.Process.Pid
+		// Whether or not the leader is still around, children that ignored
+		// SIGTERM may be. The group's id isn't handed out again while any
+		// of them are, so this only ever reaches the stragglers.
+		time.AfterFunc(GracePeriod, func() {
+			syscall.Kill(-pgid, syscall.SIGKILL)
+		})
+		return syscall.Kill(-pgid, syscall.SIGTERM)
+	}
+}
@@ -0,0 +1,85 @@
+// Package ratelimit gives every client its own token bucket, so one
+// misbehaving app or scraper can't hog the server.
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+	"vpod/internal/clientip"
+
+	"golang.org/x/time/rate"
+)
+
+// sweepInterval is how often buckets that have filled back up are dropped.
+// A full bucket is the same as a new one, so nothing is lost.
+const sweepInterval = time.Minute
+
+type Limiter struct {
+	limit rate.Limit
+	burst int
+	now   func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*rate.Limiter
+	lastSweep time.Time
+}
+
+// New returns a Limiter allowing each client limit requests a second, with
+// bursts of up to burst. A limit of zero or less returns nil, which lets
+// everything through.
+func New(limit rate.Limit, burst int) *Limiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &Limiter{
+		limit:   limit,
+		burst:   max(burst, 1),
+		now:     time.Now,
+		buckets: map[string]*rate.Limiter{},
+	}
+}
+
+// Allow takes a token from the client's bucket. When it's empty it returns
+// false and how long until there is one again.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastSweep) > sweepInterval {
+		for k, b := range l.buckets {
+			if b.TokensAt(now) >= float64(l.burst) {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = rate.NewLimiter(l.limit, l.burst)
+		l.buckets[key] = b
+	}
+	res := b.ReserveN(now, 1)
+	if delay := res.DelayFrom(now); delay > 0 {
+		res.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// ByClientIP keys requests by the resolved address of the client.
+func ByClientIP(r *http.Request) string {
+	return "ip:" + clientip.FromRequest(r)
+}
+
+// RetryAfter formats a wait for the Retry-After header, in whole seconds.
+func RetryAfter(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
+}
@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+	"vpod/internal/clientip"
+)
+
+func TestAllow(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	l := New(1, 3)
+	l.now = func() time.Time { return now }
+
+	for i := range 3 {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("Allow() #%d within the burst = false", i+1)
+		}
+	}
+	ok, wait := l.Allow("a")
+	if ok || wait != time.Second {
+		t.Errorf("Allow() past the burst = %v, %v, want false, 1s", ok, wait)
+	}
+	if ok, _ := l.Allow("b"); !ok {
+		t.Error("Allow() for another client = false, want its own bucket")
+	}
+
+	// A rejected request doesn't use up a token
+	now = now.Add(time.Second)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Error("Allow() after waiting = false")
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Error("Allow() after using the new token = true")
+	}
+}
+
+func TestSweep(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	l := New(1, 3)
+	l.now = func() time.Time { return now }
+	l.Allow("a")
+	l.Allow("b")
+
+	now = now.Add(2 * sweepInterval)
+	l.Allow("c")
+	if _, ok := l.buckets["a"]; ok {
+		t.Error("idle bucket wasn't dropped")
+	}
+	if len(l.buckets) != 1 {
+		t.Errorf("%d buckets left, want 1", len(l.buckets))
+	}
+}
+
+func TestNilLimiter(t *testing.T) {
+	l := New(0, 10)
+	for range 100 {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatal("Allow() on a disabled limiter = false")
+		}
+	}
+}
+
+func TestByClientIP(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/gen", nil)
+	r = r.WithContext(clientip.WithClientIP(r.Context(), "198.51.100.1"))
+	if got := ByClientIP(r); got != "ip:198.51.100.1" {
+		t.Errorf("ByClientIP() = %s, want the client ip", got)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	if got := RetryAfter(1500 * time.Millisecond); got != "2" {
+		t.Errorf("RetryAfter() = %s, want 2", got)
+	}
+}
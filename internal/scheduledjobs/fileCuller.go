@@ -1,14 +1,19 @@
 package scheduledjobs
 
 import (
+	"cmp"
 	"context"
+	"errors"
+	"fmt"
+	"io/fs"
 	"log/slog"
 	"os"
-	"path/filepath"
 	"slices"
 	"strconv"
-	"sync"
+	"strings"
 	"time"
+	"vpod/internal/data"
+	"vpod/internal/youtube"
 )
 
 const (
@@ -17,111 +22,353 @@ const (
 	GB       = MB * 1024
 )
 
-func cullFiles(ctx context.Context, logger *slog.Logger, audioStoragePath string, maxSize int64) error {
-	files, totalSize, err := getFilesWithSize(audioStoragePath, ".m4a")
+// ParseSize parses sizes like "500MB" or "2GB". A bare number is in bytes.
+func ParseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	unit := int64(1)
+	for _, u := range []struct {
+		suffix string
+		size   int64
+	}{
+		{"GB", GB},
+		{"MB", MB},
+		{"KB", KB},
+		{"B", 1},
+	} {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			unit = u.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	return int64(n * float64(unit)), nil
+}
+
+// An EvictionPolicy protects some cached audio files from being culled.
+// Whatever is left unprotected is evicted least recently used first.
+type EvictionPolicy interface {
+	Name() string
+	// Protected returns the paths of files that must not be evicted.
+	Protected(ctx context.Context, files []data.AudioFile) (map[string]bool, error)
+}
+
+// PinnedPolicy never evicts pinned episodes.
+type PinnedPolicy struct {
+	Queries *data.Queries
+}
+
+func (p PinnedPolicy) Name() string { return "pinned" }
+
+func (p PinnedPolicy) Protected(ctx context.Context, files []data.AudioFile) (map[string]bool, error) {
+	ids, err := p.Queries.GetPinnedVideoIds(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if totalSize > maxSize {
-		logger = logger.With(slog.String(
-			"desired_size_bytes",
-			strconv.Itoa(int(maxSize)),
-		))
+	protected := make(map[string]bool)
+	for _, f := range files {
+		if slices.Contains(ids, f.VideoID) {
+			protected[f.Path] = true
+		}
+	}
+	return protected, nil
+}
 
-		logger.Info("audio size bigger than desired -- culling", slog.String(
-			"current_size_bytes",
-			strconv.Itoa(int(totalSize)),
-		))
-		slices.SortFunc(files, func(a file, b file) int {
-			duration := a.modTime.UTC().Sub(b.modTime.UTC())
-			return int(duration)
+// NewestPerFeedPolicy never evicts the N most recently released episodes of
+// each feed.
+type NewestPerFeedPolicy struct {
+	N       int
+	Queries *data.Queries
+}
+
+func (p NewestPerFeedPolicy) Name() string { return "newest_per_feed" }
+
+func (p NewestPerFeedPolicy) Protected(ctx context.Context, files []data.AudioFile) (map[string]bool, error) {
+	rows, err := p.Queries.GetEpisodeReleases(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	byFeed := make(map[string][]data.GetEpisodeReleasesRow)
+	for _, row := range rows {
+		byFeed[row.FeedID] = append(byFeed[row.FeedID], row)
+	}
+
+	newest := make(map[string]bool)
+	for _, eps := range byFeed {
+		slices.SortFunc(eps, func(a, b data.GetEpisodeReleasesRow) int {
+			return b.ReleasedAt.Time.Compare(a.ReleasedAt.Time)
 		})
+		for _, ep := range eps[:min(p.N, len(eps))] {
+			newest[youtube.VideoIdFromURL(ep.VideoUrl.String)] = true
+		}
+	}
 
-		remainingSize := totalSize
-		for _, file := range files {
-			if remainingSize <= maxSize {
-				break
-			}
+	protected := make(map[string]bool)
+	for _, f := range files {
+		if newest[f.VideoID] {
+			protected[f.Path] = true
+		}
+	}
+	return protected, nil
+}
+
+type CullerConfig struct {
+	// MaxBytes is the budget for all cached audio files.
+	MaxBytes int64
+	// Policies protect files from eviction.
+	Policies []EvictionPolicy
+	// DryRun only reports what would be culled.
+	DryRun bool
+}
+
+type Culler struct {
+	dryRun   bool
+	logger   *slog.Logger
+	maxBytes int64
+	policies []EvictionPolicy
+	queries  *data.Queries
+}
+
+func NewCuller(logger *slog.Logger, queries *data.Queries, cfg CullerConfig) (*Culler, error) {
+	if cfg.MaxBytes <= 0 {
+		return nil, errors.New("audio cache budget must be positive")
+	}
+	return &Culler{
+		dryRun:   cfg.DryRun,
+		logger:   logger,
+		maxBytes: cfg.MaxBytes,
+		policies: cfg.Policies,
+		queries:  queries,
+	}, nil
+}
+
+type CulledFile struct {
+	Path           string    `json:"path"`
+	VideoID        string    `json:"video_id"`
+	SizeBytes      int64     `json:"size_bytes"`
+	LastAccessedAt time.Time `json:"last_accessed_at"`
+}
 
-			err = os.Remove(file.path)
-			if err != nil {
-				return err
+type CullReport struct {
+	MaxBytes       int64        `json:"max_bytes"`
+	TotalBytes     int64        `json:"total_bytes"`
+	RemainingBytes int64        `json:"remaining_bytes"`
+	NumFiles       int          `json:"num_files"`
+	NumProtected   int          `json:"num_protected"`
+	Evict          []CulledFile `json:"evict"`
+}
+
+// Plan works out which files would be culled, without removing anything.
+func (c *Culler) Plan(ctx context.Context) (*CullReport, error) {
+	return c.plan(ctx, false)
+}
+
+// plan works out which files to cull. If prune is set, files that no longer
+// exist on disk are forgotten.
+func (c *Culler) plan(ctx context.Context, prune bool) (*CullReport, error) {
+	files, err := c.queries.GetAudioFiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The DB can drift from the disk if files are removed by hand
+	existing := make([]data.AudioFile, 0, len(files))
+	var totalSize int64
+	for _, f := range files {
+		info, err := os.Stat(f.Path)
+		if errors.Is(err, fs.ErrNotExist) {
+			if prune {
+				if err := c.queries.DeleteAudioFile(ctx, f.Path); err != nil {
+					return nil, err
+				}
 			}
-			remainingSize = remainingSize - file.sizeBytes
-
-			logger.Debug(
-				"removed file",
-				slog.String("path", file.path),
-				slog.String(
-					"current_size_bytes",
-					strconv.Itoa(int(remainingSize)),
-				),
-			)
-		}
-		logger.Info(
-			"culled excess audio files",
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		f.SizeBytes = info.Size()
+		existing = append(existing, f)
+		totalSize += f.SizeBytes
+	}
+
+	protected := make(map[string]bool)
+	for _, p := range c.policies {
+		paths, err := p.Protected(ctx, existing)
+		if err != nil {
+			return nil, fmt.Errorf("eviction policy %s: %w", p.Name(), err)
+		}
+		for path := range paths {
+			protected[path] = true
+		}
+	}
+
+	report := &CullReport{
+		MaxBytes:       c.maxBytes,
+		TotalBytes:     totalSize,
+		RemainingBytes: totalSize,
+		NumFiles:       len(existing),
+		NumProtected:   len(protected),
+		Evict:          []CulledFile{},
+	}
+	if totalSize <= c.maxBytes {
+		return report, nil
+	}
+
+	slices.SortFunc(existing, func(a, b data.AudioFile) int {
+		return cmp.Compare(lastAccess(a).UnixNano(), lastAccess(b).UnixNano())
+	})
+	for _, f := range existing {
+		if report.RemainingBytes <= c.maxBytes {
+			break
+		}
+		if protected[f.Path] {
+			continue
+		}
+		report.Evict = append(report.Evict, CulledFile{
+			Path:           f.Path,
+			VideoID:        f.VideoID,
+			SizeBytes:      f.SizeBytes,
+			LastAccessedAt: lastAccess(f),
+		})
+		report.RemainingBytes -= f.SizeBytes
+	}
+	return report, nil
+}
+
+// Cull removes the least recently used audio files that aren't protected by
+// a policy until the cache fits in its budget. Only files vpod downloaded
+// itself are considered.
+func (c *Culler) Cull(ctx context.Context) error {
+	report, err := c.plan(ctx, !c.dryRun)
+	if err != nil {
+		c.logger.Error("could not plan audio culling", slog.String("err", err.Error()))
+		return err
+	}
+
+	logger := c.logger.With(
+		slog.String("desired_size_bytes", strconv.Itoa(int(c.maxBytes))),
+		slog.Bool("dry_run", c.dryRun),
+	)
+	if report.RemainingBytes > c.maxBytes {
+		logger.Warn("protected audio files alone exceed the budget", slog.Int("num_protected", report.NumProtected))
+	}
+	if len(report.Evict) == 0 {
+		logger.Debug("audio size within budget", slog.String(
+			"current_size_bytes",
+			strconv.Itoa(int(report.TotalBytes)),
+		))
+		return nil
+	}
+
+	logger.Info("audio size bigger than desired -- culling",
+		slog.String("current_size_bytes", strconv.Itoa(int(report.TotalBytes))),
+		slog.Int("num_files", len(report.Evict)),
+	)
+
+	remainingSize := report.TotalBytes
+	for _, f := range report.Evict {
+		if c.dryRun {
+			logger.Info("would remove file", slog.String("path", f.Path))
+			continue
+		}
+
+		err = os.Remove(f.Path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if err := c.queries.DeleteAudioFile(ctx, f.Path); err != nil {
+			return err
+		}
+		remainingSize = remainingSize - f.SizeBytes
+
+		logger.Debug(
+			"removed file",
+			slog.String("path", f.Path),
 			slog.String(
 				"current_size_bytes",
 				strconv.Itoa(int(remainingSize)),
 			),
 		)
 	}
+	if c.dryRun {
+		return nil
+	}
+	logger.Info(
+		"culled excess audio files",
+		slog.String(
+			"current_size_bytes",
+			strconv.Itoa(int(remainingSize)),
+		),
+	)
 	return nil
 }
 
-type file struct {
-	modTime   time.Time
-	path      string
-	sizeBytes int64
-}
-
-func getFilesWithSize(path string, ext string) ([]file, int64, error) {
-	var files []file
-	var mu sync.Mutex
-	var totalSizeBytes int64
-
-	var calculateSize func(string) error
-	calculateSize = func(path string) error {
-		fileInfo, err := os.Lstat(path)
+// Purge removes every downloaded audio file, pinned ones too when
+// includePinned is set. Episodes are downloaded again when next played.
+func (c *Culler) Purge(ctx context.Context, includePinned bool) (*CullReport, error) {
+	files, err := c.queries.GetAudioFiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	protected := map[string]bool{}
+	if !includePinned {
+		protected, err = PinnedPolicy{Queries: c.queries}.Protected(ctx, files)
 		if err != nil {
-			return err
+			return nil, err
 		}
+	}
 
-		// Skip symbolic links to avoid counting them multiple times
-		if fileInfo.Mode()&os.ModeSymlink != 0 {
-			return nil
+	report := &CullReport{
+		MaxBytes:     c.maxBytes,
+		NumFiles:     len(files),
+		NumProtected: len(protected),
+		Evict:        []CulledFile{},
+	}
+	for _, f := range files {
+		report.TotalBytes += f.SizeBytes
+		if protected[f.Path] {
+			report.RemainingBytes += f.SizeBytes
+			continue
 		}
+		report.Evict = append(report.Evict, CulledFile{
+			Path:           f.Path,
+			VideoID:        f.VideoID,
+			SizeBytes:      f.SizeBytes,
+			LastAccessedAt: lastAccess(f),
+		})
+	}
+	if c.dryRun {
+		return report, nil
+	}
 
-		if fileInfo.IsDir() {
-			entries, err := os.ReadDir(path)
-			if err != nil {
-				return err
-			}
-			for _, entry := range entries {
-				if err := calculateSize(filepath.Join(path, entry.Name())); err != nil {
-					return err
-				}
-			}
-		} else {
-			if filepath.Ext(fileInfo.Name()) == ext {
-				files = append(files, file{
-					path:      path,
-					modTime:   fileInfo.ModTime(),
-					sizeBytes: fileInfo.Size(),
-				})
-				mu.Lock()
-				totalSizeBytes += fileInfo.Size()
-				mu.Unlock()
-			}
+	for _, f := range report.Evict {
+		err := os.Remove(f.Path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		if err := c.queries.DeleteAudioFile(ctx, f.Path); err != nil {
+			return nil, err
 		}
-		return nil
 	}
+	c.logger.Info("purged audio files",
+		slog.Int("num_files", len(report.Evict)),
+		slog.Bool("include_pinned", includePinned),
+	)
+	return report, nil
+}
 
-	// Start calculation from the root path
-	if err := calculateSize(path); err != nil {
-		return []file{}, 0, err
+func lastAccess(f data.AudioFile) time.Time {
+	if f.LastAccessedAt.Valid {
+		return f.LastAccessedAt.Time
 	}
-
-	return files, totalSizeBytes, nil
+	if f.CreatedAt.Valid {
+		return f.CreatedAt.Time
+	}
+	return time.Time{}
 }
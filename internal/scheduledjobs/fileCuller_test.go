@@ -2,6 +2,7 @@ package scheduledjobs
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"log/slog"
 	"os"
@@ -9,6 +10,9 @@ import (
 	"strings"
 	"testing"
 	"time"
+	"vpod/internal/data"
+
+	_ "github.com/mattn/go-sqlite3"
 )
 
 func createTestFile(path string, size int64) error {
@@ -32,6 +36,69 @@ type testFileInfo struct {
 	modTime  time.Time
 	isDir    bool
 	children []string
+
+	// untracked files were not downloaded by vpod
+	untracked bool
+	feedID    string
+	released  time.Time
+}
+
+func initDb(t *testing.T) *data.Queries {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	if err := data.Migrate(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	return data.New(db)
+}
+
+func videoID(path string) string {
+	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+}
+
+// recordTestFiles tracks files as if vpod downloaded them, using their
+// modTime as the last time they were played.
+func recordTestFiles(ctx context.Context, queries *data.Queries, tempDir string, files []testFileInfo) error {
+	for _, f := range files {
+		if f.isDir || f.untracked {
+			continue
+		}
+		id := videoID(f.path)
+		err := queries.UpsertAudioFile(ctx, data.UpsertAudioFileParams{
+			Path:           filepath.Join(tempDir, f.path),
+			VideoID:        id,
+			FormatID:       "140",
+			SizeBytes:      f.size,
+			LastAccessedAt: sql.NullTime{Time: f.modTime.UTC(), Valid: true},
+		})
+		if err != nil {
+			return err
+		}
+
+		if f.feedID == "" {
+			continue
+		}
+		err = queries.UpsertEpisode(ctx, data.UpsertEpisodeParams{
+			ID:         []byte(id),
+			FeedID:     f.feedID,
+			Title:      id,
+			VideoID:    id,
+			ReleasedAt: sql.NullTime{Time: f.released.UTC(), Valid: true},
+			VideoUrl: sql.NullString{
+				String: "https://www.youtube.com/watch?v=" + id,
+				Valid:  true,
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func populateTestDir(tempDir string, files []testFileInfo) error {
@@ -68,16 +135,15 @@ func checkFilesExist(dirPath string, fileNames []string) (map[string]bool, error
 		result[fileName] = false
 	}
 
-	files, _, err := getFilesWithSize(dirPath, ".m4a")
+	entries, err := os.ReadDir(dirPath)
 	if err != nil {
 		return nil, err
 	}
 
 	// Check which files exist
-	for _, file := range files {
-		baseName := filepath.Base(file.path)
-		if _, exists := result[baseName]; exists {
-			result[baseName] = true
+	for _, entry := range entries {
+		if _, exists := result[entry.Name()]; exists {
+			result[entry.Name()] = true
 		}
 	}
 
@@ -90,6 +156,9 @@ func Test_cullFiles_TableDriven(t *testing.T) {
 		name              string
 		files             []testFileInfo
 		maxSize           int64
+		pinned            []string
+		keepNewest        int
+		dryRun            bool
 		expectedFileCount int
 		shouldExist       []string
 		shouldNotExist    []string
@@ -200,6 +269,114 @@ func Test_cullFiles_TableDriven(t *testing.T) {
 			shouldExist:       []string{"medium.m4a", "small.m4a"},
 			shouldNotExist:    []string{"big.m4a"},
 		},
+		{
+			name: "Never remove files vpod did not create",
+			files: []testFileInfo{
+				{
+					path:      "foreign.m4a",
+					size:      300,
+					modTime:   time.Now().Add(-3 * time.Hour),
+					untracked: true,
+				},
+				{
+					path:    "ours-old.m4a",
+					size:    200,
+					modTime: time.Now().Add(-2 * time.Hour),
+				},
+				{
+					path:    "ours-new.m4a",
+					size:    200,
+					modTime: time.Now().Add(-1 * time.Hour),
+				},
+			},
+			maxSize:           300,
+			expectedFileCount: 2,
+			shouldExist:       []string{"foreign.m4a", "ours-new.m4a"},
+			shouldNotExist:    []string{"ours-old.m4a"},
+		},
+		{
+			name: "Keep pinned episodes",
+			files: []testFileInfo{
+				{
+					path:    "pinned.m4a",
+					size:    200,
+					modTime: time.Now().Add(-3 * time.Hour),
+				},
+				{
+					path:    "middle.m4a",
+					size:    200,
+					modTime: time.Now().Add(-2 * time.Hour),
+				},
+				{
+					path:    "newest.m4a",
+					size:    200,
+					modTime: time.Now().Add(-1 * time.Hour),
+				},
+			},
+			maxSize:           500,
+			pinned:            []string{"pinned"},
+			expectedFileCount: 2,
+			shouldExist:       []string{"pinned.m4a", "newest.m4a"},
+			shouldNotExist:    []string{"middle.m4a"},
+		},
+		{
+			name: "Keep the newest episodes of each feed",
+			files: []testFileInfo{
+				{
+					path:     "a-new.m4a",
+					size:     200,
+					modTime:  time.Now().Add(-4 * time.Hour),
+					feedID:   "a",
+					released: time.Now().Add(-24 * time.Hour),
+				},
+				{
+					path:     "b-new.m4a",
+					size:     200,
+					modTime:  time.Now().Add(-3 * time.Hour),
+					feedID:   "b",
+					released: time.Now().Add(-24 * time.Hour),
+				},
+				{
+					path:     "a-old.m4a",
+					size:     200,
+					modTime:  time.Now().Add(-2 * time.Hour),
+					feedID:   "a",
+					released: time.Now().Add(-48 * time.Hour),
+				},
+				{
+					path:     "b-old.m4a",
+					size:     200,
+					modTime:  time.Now().Add(-1 * time.Hour),
+					feedID:   "b",
+					released: time.Now().Add(-48 * time.Hour),
+				},
+			},
+			maxSize:           500,
+			keepNewest:        1,
+			expectedFileCount: 2,
+			shouldExist:       []string{"a-new.m4a", "b-new.m4a"},
+			shouldNotExist:    []string{"a-old.m4a", "b-old.m4a"},
+		},
+		{
+			name: "Dry run removes nothing",
+			files: []testFileInfo{
+				{
+					path:    "oldest.m4a",
+					size:    200,
+					modTime: time.Now().Add(-2 * time.Hour),
+				},
+				{
+					path:    "newest.m4a",
+					size:    200,
+					modTime: time.Now().Add(-1 * time.Hour),
+				},
+			},
+			maxSize:           300,
+			dryRun:            true,
+			expectedFileCount: 2,
+			shouldExist:       []string{"oldest.m4a", "newest.m4a"},
+			shouldNotExist:    []string{},
+		},
 		// {
 		// 	name: "Handle directory with nested files",
 		// 	files: []testFileInfo{
@@ -247,27 +424,56 @@ func Test_cullFiles_TableDriven(t *testing.T) {
 				t.Fatalf("Failed to populate the test dir: %v", err)
 			}
 			logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+			ctx := context.Background()
+			queries := initDb(t)
+
+			err = recordTestFiles(ctx, queries, tempDir, tt.files)
+			if err != nil {
+				t.Fatalf("Failed to record the test files: %v", err)
+			}
+			for _, id := range tt.pinned {
+				if err := queries.PinEpisode(ctx, id); err != nil {
+					t.Fatalf("Failed to pin %s: %v", id, err)
+				}
+			}
+
+			policies := []EvictionPolicy{PinnedPolicy{Queries: queries}}
+			if tt.keepNewest > 0 {
+				policies = append(policies, NewestPerFeedPolicy{N: tt.keepNewest, Queries: queries})
+			}
+			culler, err := NewCuller(logger, queries, CullerConfig{
+				MaxBytes: tt.maxSize,
+				Policies: policies,
+				DryRun:   tt.dryRun,
+			})
+			if err != nil {
+				t.Fatalf("NewCuller failed: %v", err)
+			}
 
 			// run
-			err = cullFiles(context.Background(), logger, tempDir, tt.maxSize)
+			err = culler.Cull(ctx)
 			if err != nil {
-				t.Fatalf("cullFiles failed: %v", err)
+				t.Fatalf("Cull failed: %v", err)
 			}
 
-			// Check which files remain
-			remainingFiles, totalSize, err := getFilesWithSize(tempDir, ".m4a")
+			// Check which tracked files remain
+			report, err := culler.Plan(ctx)
 			if err != nil {
-				t.Fatalf("getFilesWithSize failed: %v", err)
+				t.Fatalf("Plan failed: %v", err)
 			}
 
 			// We expect the total size to be no more than maxSize
-			if totalSize > tt.maxSize {
-				t.Errorf("Expected total size to be at most %d, got %d", tt.maxSize, totalSize)
+			if !tt.dryRun && report.TotalBytes > tt.maxSize {
+				t.Errorf("Expected total size to be at most %d, got %d", tt.maxSize, report.TotalBytes)
 			}
 
 			// Check that we have the right number of files remaining
-			if len(remainingFiles) != tt.expectedFileCount {
-				t.Errorf("Expected %d files to remain, got %d", tt.expectedFileCount, len(remainingFiles))
+			entries, err := os.ReadDir(tempDir)
+			if err != nil {
+				t.Fatalf("Failed to read temp dir: %v", err)
+			}
+			if len(entries) != tt.expectedFileCount {
+				t.Errorf("Expected %d files to remain, got %d", tt.expectedFileCount, len(entries))
 			}
 
 			// Check for files that should exist
@@ -298,3 +504,64 @@ func Test_cullFiles_TableDriven(t *testing.T) {
 		})
 	}
 }
+
+func TestPurge(t *testing.T) {
+	for _, includePinned := range []bool{false, true} {
+		t.Run(fmt.Sprintf("includePinned=%v", includePinned), func(t *testing.T) {
+			ctx := context.Background()
+			tempDir := t.TempDir()
+			queries := initDb(t)
+			now := time.Now()
+			files := []testFileInfo{
+				{path: "a.m4a", size: 100, modTime: now},
+				{path: "pinned.m4a", size: 100, modTime: now},
+				{path: "mine.m4a", size: 100, modTime: now, untracked: true},
+			}
+			if err := populateTestDir(tempDir, files); err != nil {
+				t.Fatal(err)
+			}
+			if err := recordTestFiles(ctx, queries, tempDir, files); err != nil {
+				t.Fatal(err)
+			}
+			if err := queries.PinEpisode(ctx, "pinned"); err != nil {
+				t.Fatal(err)
+			}
+
+			culler, err := NewCuller(slog.New(slog.DiscardHandler), queries, CullerConfig{MaxBytes: 1 << 30})
+			if err != nil {
+				t.Fatal(err)
+			}
+			report, err := culler.Purge(ctx, includePinned)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			shouldExist := []string{"mine.m4a"}
+			shouldNotExist := []string{"a.m4a"}
+			wantEvicted := 1
+			if includePinned {
+				shouldNotExist = append(shouldNotExist, "pinned.m4a")
+				wantEvicted = 2
+			} else {
+				shouldExist = append(shouldExist, "pinned.m4a")
+			}
+			if len(report.Evict) != wantEvicted {
+				t.Errorf("evicted %d files, want %d", len(report.Evict), wantEvicted)
+			}
+			exist, err := checkFilesExist(tempDir, append(shouldExist, shouldNotExist...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range shouldExist {
+				if !exist[name] {
+					t.Errorf("%s was removed", name)
+				}
+			}
+			for _, name := range shouldNotExist {
+				if exist[name] {
+					t.Errorf("%s wasn't removed", name)
+				}
+			}
+		})
+	}
+}
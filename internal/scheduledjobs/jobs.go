@@ -1,41 +1,63 @@
 package scheduledjobs
 
 import (
-	"log/slog"
-	"net/url"
+	"context"
 	"time"
-	"vpod/internal/data"
+	"vpod/internal/tracing"
+	"vpod/internal/websub"
 
 	"github.com/go-co-op/gocron/v2"
 )
 
-func CreateUpdateJob(s gocron.Scheduler, logger *slog.Logger, baseURL *url.URL, queries *data.Queries) error {
+// traced runs task in a span of its own, so each run of a job is a trace.
+func traced(name string, task func(context.Context) error) func(context.Context) error {
+	return func(ctx context.Context) (err error) {
+		ctx, span := tracing.Start(ctx, "job "+name)
+		defer func() { tracing.End(span, err) }()
+		return task(ctx)
+	}
+}
+
+func CreateUpdateJob(s gocron.Scheduler, u *Updater) error {
 	_, err := s.NewJob(
 		gocron.DurationJob(
-			1*time.Hour, // TODO
+			1*time.Minute,
 		),
 		gocron.NewTask(
-			updateAll,
-			logger,
-			baseURL,
-			queries,
+			traced("update feeds", u.UpdateDue),
 		),
+		gocron.WithName("update feeds"),
 		gocron.WithSingletonMode(gocron.LimitModeReschedule), // TODO: examine
 	)
 	return err
 }
 
-func CreateFileCullingJob(s gocron.Scheduler, logger *slog.Logger) error {
+func CreateWebSubRenewalJob(s gocron.Scheduler, sub *websub.Subscriber) error {
+	_, err := s.NewJob(
+		gocron.DurationJob(
+			1*time.Hour,
+		),
+		gocron.NewTask(
+			traced("renew websub", sub.RenewAll),
+		),
+		gocron.WithName("renew websub"),
+		gocron.WithSingletonMode(gocron.LimitModeReschedule),
+		gocron.WithStartAt(
+			gocron.WithStartImmediately(),
+		),
+	)
+	return err
+}
+
+func CreateFileCullingJob(s gocron.Scheduler, c *Culler) error {
 	_, err := s.NewJob(
 		gocron.DurationJob(
 			24*time.Hour, // TODO
 		),
 		gocron.NewTask(
-			cullFiles,
-			logger,
-			"./",
-			1*GB,
+			traced("cull audio", c.Cull),
 		),
+		gocron.WithName("cull audio"),
 		gocron.WithStartAt(
 			gocron.WithStartImmediately(),
 		),
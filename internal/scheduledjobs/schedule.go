@@ -0,0 +1,70 @@
+package scheduledjobs
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+type RefreshMode string
+
+const (
+	RefreshModeFixed    RefreshMode = "fixed"
+	RefreshModeAdaptive RefreshMode = "adaptive"
+)
+
+func ParseRefreshMode(s string) (RefreshMode, error) {
+	switch m := RefreshMode(s); m {
+	case RefreshModeFixed, RefreshModeAdaptive:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown refresh mode: %q", s)
+	}
+}
+
+// numReleasesForCadence is how many of a feed's most recent episodes are
+// looked at when learning its upload cadence.
+const numReleasesForCadence = 10
+
+type scheduleConfig struct {
+	defaultInterval time.Duration
+	minInterval     time.Duration
+	maxInterval     time.Duration
+	jitter          float64
+}
+
+// adaptiveInterval guesses how long to wait before checking a feed again
+// based on when its episodes were released. We aim to check about four
+// times per typical gap between uploads. If the channel has gone quiet for
+// longer than its usual gap, we back off in proportion to the silence.
+//
+// releases must be sorted newest first.
+func adaptiveInterval(releases []time.Time, now time.Time, cfg scheduleConfig) time.Duration {
+	if len(releases) < 2 {
+		return cfg.defaultInterval
+	}
+
+	gaps := make([]time.Duration, 0, len(releases)-1)
+	for i := 1; i < len(releases); i++ {
+		gaps = append(gaps, releases[i-1].Sub(releases[i]))
+	}
+	slices.Sort(gaps)
+	cadence := gaps[len(gaps)/2]
+
+	if sinceLast := now.Sub(releases[0]); sinceLast > cadence {
+		cadence = sinceLast
+	}
+
+	interval := cadence / 4
+	return min(max(interval, cfg.minInterval), cfg.maxInterval)
+}
+
+// withJitter spreads refreshes out by moving d up to ±jitter of itself.
+func withJitter(d time.Duration, jitter float64) time.Duration {
+	if jitter <= 0 {
+		return d
+	}
+	spread := float64(d) * jitter
+	return d + time.Duration((rand.Float64()*2-1)*spread)
+}
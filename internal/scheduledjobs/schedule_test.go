@@ -0,0 +1,77 @@
+package scheduledjobs
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_adaptiveInterval(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	cfg := scheduleConfig{
+		defaultInterval: 1 * time.Hour,
+		minInterval:     15 * time.Minute,
+		maxInterval:     24 * time.Hour,
+	}
+
+	releasesEvery := func(gap time.Duration, n int, since time.Duration) []time.Time {
+		releases := make([]time.Time, 0, n)
+		for i := range n {
+			releases = append(releases, now.Add(-since-time.Duration(i)*gap))
+		}
+		return releases
+	}
+
+	tests := []struct {
+		name     string
+		releases []time.Time
+		want     time.Duration
+	}{
+		{
+			name:     "Use default with too few releases",
+			releases: releasesEvery(time.Hour, 1, 0),
+			want:     1 * time.Hour,
+		},
+		{
+			name:     "Daily uploads are checked every six hours",
+			releases: releasesEvery(24*time.Hour, 10, time.Hour),
+			want:     6 * time.Hour,
+		},
+		{
+			name:     "Frequent uploads are clamped to the minimum",
+			releases: releasesEvery(20*time.Minute, 10, 0),
+			want:     15 * time.Minute,
+		},
+		{
+			name:     "Dormant channels back off to the maximum",
+			releases: releasesEvery(24*time.Hour, 10, 30*24*time.Hour),
+			want:     24 * time.Hour,
+		},
+		{
+			name:     "Quiet channels back off in proportion to the silence",
+			releases: releasesEvery(6*time.Hour, 10, 40*time.Hour),
+			want:     10 * time.Hour,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := adaptiveInterval(tt.releases, now, cfg)
+			if got != tt.want {
+				t.Errorf("adaptiveInterval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_withJitter(t *testing.T) {
+	d := 1 * time.Hour
+	for range 100 {
+		got := withJitter(d, 0.1)
+		if got < 54*time.Minute || got > 66*time.Minute {
+			t.Fatalf("withJitter(%v, 0.1) = %v, want within 10%%", d, got)
+		}
+	}
+	if got := withJitter(d, 0); got != d {
+		t.Errorf("withJitter(%v, 0) = %v, want %v", d, got, d)
+	}
+}
@@ -2,83 +2,544 @@ package scheduledjobs
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"log/slog"
+	"net/http"
 	"net/url"
+	"sync"
+	"time"
+	"vpod/internal/audio"
+	"vpod/internal/cookies"
 	"vpod/internal/data"
+	"vpod/internal/metrics"
 	"vpod/internal/podcast"
+	"vpod/internal/tracing"
 	"vpod/internal/youtube"
+
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/time/rate"
 )
 
-func update(
-	ctx context.Context,
-	feedID string,
+type UpdaterConfig struct {
+	// Concurrency is the number of feeds refreshed at the same time.
+	Concurrency int
+	// FeedTimeout bounds a single feed refresh, including the yt-dlp call.
+	FeedTimeout time.Duration
+	// RateLimit is the number of YouTube requests allowed per second.
+	// A zero value means no limit.
+	RateLimit rate.Limit
+	// RateBurst is the number of YouTube requests allowed in a burst.
+	RateBurst int
+	// FeedURL is the channel Atom feed endpoint used to detect new videos
+	// before running yt-dlp.
+	FeedURL *url.URL
+	// Downloader prefetches the audio of new episodes for feeds that want it.
+	Downloader *audio.Downloader
+	// Cookies are passed to yt-dlp for feeds with videos that need a login.
+	// May be nil.
+	Cookies *cookies.Store
+
+	// RefreshInterval is used for feeds without an interval of their own.
+	RefreshInterval time.Duration
+	// MinRefreshInterval and MaxRefreshInterval bound adaptive schedules.
+	MinRefreshInterval time.Duration
+	MaxRefreshInterval time.Duration
+	// RefreshJitter randomly moves each scheduled refresh by up to this
+	// fraction of its interval.
+	RefreshJitter float64
+}
+
+// queueSize is how many targeted refreshes may wait to be processed.
+const queueSize = 256
+
+type Updater struct {
+	baseURL     *url.URL
+	client      *http.Client
+	concurrency int
+	cookies     *cookies.Store
+	downloader  *audio.Downloader
+	feedTimeout time.Duration
+	feedURL     *url.URL
+	limiter     *rate.Limiter
+	logger      *slog.Logger
+	queries     *data.Queries
+	schedule    scheduleConfig
+
+	queue    chan string
+	mu       sync.Mutex
+	inflight map[string]bool
+	// skipped holds, by feed, the channel feed entries the last refresh
+	// left without an episode, like shorts and upcoming premieres, with
+	// when each was last updated. They only count as changed once they're
+	// updated again.
+	skipped map[string]map[string]time.Time
+}
+
+func NewUpdater(
+	logger *slog.Logger,
 	baseURL *url.URL,
 	queries *data.Queries,
-) error {
+	cfg UpdaterConfig,
+) (*Updater, error) {
+	if cfg.Concurrency < 1 {
+		return nil, errors.New("refresh concurrency must be at least 1")
+	}
+	if cfg.FeedTimeout <= 0 {
+		return nil, errors.New("feed refresh timeout must be positive")
+	}
+	if cfg.FeedURL == nil {
+		return nil, errors.New("channel feed url is required")
+	}
+
+	if cfg.RefreshInterval <= 0 {
+		return nil, errors.New("refresh interval must be positive")
+	}
+	if cfg.MinRefreshInterval <= 0 || cfg.MinRefreshInterval > cfg.MaxRefreshInterval {
+		return nil, errors.New("refresh interval bounds must be positive and min must not exceed max")
+	}
+	if cfg.RefreshJitter < 0 || cfg.RefreshJitter >= 1 {
+		return nil, errors.New("refresh jitter must be in range [0, 1)")
+	}
+
+	limit := cfg.RateLimit
+	if limit <= 0 {
+		limit = rate.Inf
+	}
+	burst := cfg.RateBurst
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &Updater{
+		baseURL:     baseURL,
+		client:      &http.Client{Timeout: 30 * time.Second},
+		concurrency: cfg.Concurrency,
+		cookies:     cfg.Cookies,
+		downloader:  cfg.Downloader,
+		feedTimeout: cfg.FeedTimeout,
+		feedURL:     cfg.FeedURL,
+		limiter:     rate.NewLimiter(limit, burst),
+		logger:      logger,
+		queries:     queries,
+		queue:       make(chan string, queueSize),
+		inflight:    make(map[string]bool),
+		skipped:     make(map[string]map[string]time.Time),
+		schedule: scheduleConfig{
+			defaultInterval: cfg.RefreshInterval,
+			minInterval:     cfg.MinRefreshInterval,
+			maxInterval:     cfg.MaxRefreshInterval,
+			jitter:          cfg.RefreshJitter,
+		},
+	}, nil
+}
+
+func (u *Updater) update(ctx context.Context, feedID string) error {
+	ctx, cancel := context.WithTimeout(ctx, u.feedTimeout)
+	defer cancel()
+
 	ytURL := &url.URL{
 		Scheme: "https",
 		Host:   "www.youtube.com",
 	}
 	ytURL = ytURL.JoinPath("channel", feedID)
-	c, err := youtube.FetchChannel(ytURL)
+
+	var opts []youtube.FetchChannelOption
+	n, entries, err := u.numChangedVideos(ctx, feedID)
+	if err != nil {
+		// yt-dlp can still tell us what changed, just more slowly
+		u.logger.Warn(
+			"could not check channel feed for changes",
+			slog.String("feed_id", feedID),
+			slog.String("err", err.Error()),
+		)
+	} else if n == 0 {
+		u.logger.Debug("no new videos in channel feed", slog.String("feed_id", feedID))
+		return nil
+	} else {
+		opts = append(opts, youtube.WithNItems(n))
+	}
+
+	cookiesPath, saveCookies, err := u.cookies.File(ctx, feedID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := saveCookies(ctx); err != nil {
+			u.logger.Warn(
+				"could not save cookie jar",
+				slog.String("feed_id", feedID),
+				slog.String("err", err.Error()),
+			)
+		}
+	}()
+	if cookiesPath != "" {
+		opts = append(opts, youtube.WithCookies(cookiesPath))
+	}
+
+	if err := u.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	c, err := youtube.FetchChannel(ctx, ytURL, opts...)
 	if err != nil {
 		return err
 	}
 
-	p, err := podcast.FromChannel(*c, *baseURL) // TODO: decide what to do about PubDate
+	feedOpts, err := podcast.FeedOptions(ctx, u.queries, feedID)
+	if err != nil {
+		return err
+	}
+	p, err := podcast.FromChannel(*c, *u.baseURL, feedOpts...) // TODO: decide what to do about PubDate
 	if err != nil {
 		return err
 	}
 
-	ctx = context.WithValue(ctx, "queries", queries) // TODO: smelly
+	ctx = context.WithValue(ctx, "queries", u.queries) // TODO: smelly
 	p, err = p.AppendOldEps(ctx)
 	if err != nil {
 		return err
 	}
 
-	err = podcast.UpsertPodcast(queries, *p, ctx)
+	err = podcast.UpsertPodcast(u.queries, *p, ctx)
 	if err != nil {
 		return err
 	}
+	if entries != nil {
+		if err := u.rememberSkipped(ctx, feedID, entries); err != nil {
+			// Only means they're looked at again next time
+			u.logger.Warn(
+				"could not remember skipped videos",
+				slog.String("feed_id", feedID),
+				slog.String("err", err.Error()),
+			)
+		}
+	}
+
+	if err := u.prefetchNewest(ctx, feedID); err != nil {
+		// The feed itself was updated fine
+		u.logger.Error(
+			"could not prefetch new episodes",
+			slog.String("feed_id", feedID),
+			slog.String("err", err.Error()),
+		)
+	}
 	return nil
 }
 
-func updateAll(
-	ctx context.Context,
-	logger *slog.Logger,
-	baseURL *url.URL,
-	queries *data.Queries,
-) error {
-	ids, err := queries.GetAllFeedIds(ctx)
+// prefetchNewest queues the audio of the feed's newest episodes for download,
+// if the feed asks for it.
+func (u *Updater) prefetchNewest(ctx context.Context, feedID string) error {
+	if u.downloader == nil {
+		return nil
+	}
+
+	n, err := u.queries.GetFeedPrefetchCount(ctx, feedID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if n <= 0 {
+		return nil
+	}
+
+	eps, err := u.queries.GetNewestEpisodesForFeed(ctx, data.GetNewestEpisodesForFeedParams{
+		FeedID: feedID,
+		Limit:  n,
+	})
 	if err != nil {
-		logger.Error(
+		return err
+	}
+	media, err := u.queries.GetFeedMedia(ctx, feedID)
+	if err != nil {
+		return err
+	}
+	for _, ep := range eps {
+		enclosureURL := ep.AudioUrl
+		if podcast.Media(media) == podcast.MediaVideo && ep.VideoEnclosureUrl.Valid {
+			enclosureURL = ep.VideoEnclosureUrl.String
+		}
+		m, err := audio.MetadataFromURL(enclosureURL)
+		if err != nil {
+			return err
+		}
+		m.FeedId = feedID
+		u.downloader.Prefetch(m)
+	}
+	return nil
+}
+
+// numChangedVideos compares the channel's Atom feed against the episodes we
+// have, and returns how many of the channel's latest videos need to be
+// extracted to pick up every new or changed one, along with the feed's
+// entries.
+func (u *Updater) numChangedVideos(ctx context.Context, feedID string) (uint64, []youtube.FeedEntry, error) {
+	if err := u.limiter.Wait(ctx); err != nil {
+		return 0, nil, err
+	}
+	entries, err := youtube.FetchFeedEntries(ctx, u.client, u.feedURL, feedID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	eps, err := u.queries.GetEpisodesForFeed(ctx, feedID)
+	if err != nil {
+		return 0, nil, err
+	}
+	u.mu.Lock()
+	skipped := u.skipped[feedID]
+	u.mu.Unlock()
+	return changedVideos(entries, eps, skipped), entries, nil
+}
+
+// rememberSkipped records which of the channel feed's entries are still not
+// episodes after a refresh, so the next one doesn't run yt-dlp for them
+// again.
+func (u *Updater) rememberSkipped(ctx context.Context, feedID string, entries []youtube.FeedEntry) error {
+	eps, err := u.queries.GetEpisodesForFeed(ctx, feedID)
+	if err != nil {
+		return err
+	}
+	have := make(map[string]bool, len(eps))
+	for _, ep := range eps {
+		have[ep.VideoID] = true
+	}
+	skipped := make(map[string]time.Time)
+	for _, e := range entries {
+		if !have[e.VideoId] {
+			skipped[e.VideoId] = e.Updated
+		}
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.skipped[feedID] = skipped
+	return nil
+}
+
+// changedVideos returns one past the index of the last entry that is either
+// missing from eps or has a different title. Entries in skipped count as
+// unchanged until they're updated. Entries are newest first, in the same
+// order yt-dlp lists the channel's videos.
+func changedVideos(entries []youtube.FeedEntry, eps []data.Episode, skipped map[string]time.Time) uint64 {
+	titles := make(map[string]string, len(eps))
+	for _, ep := range eps {
+		titles[ep.VideoID] = ep.Title
+	}
+
+	var n uint64
+	for i, e := range entries {
+		title, ok := titles[e.VideoId]
+		if ok && title == e.Title {
+			continue
+		}
+		if updated, ok := skipped[e.VideoId]; !ok || !updated.Equal(e.Updated) {
+			n = uint64(i) + 1
+		}
+	}
+	return n
+}
+
+// UpdateAll refreshes every feed, regardless of its schedule.
+func (u *Updater) UpdateAll(ctx context.Context) error {
+	ids, err := u.queries.GetAllFeedIds(ctx)
+	if err != nil {
+		u.logger.Error(
 			"could not get feeds from DB",
 			slog.String("err", err.Error()),
 		)
 		return err
 	}
+	return u.updateFeeds(ctx, ids)
+}
+
+// UpdateDue refreshes the feeds whose next scheduled refresh has passed.
+func (u *Updater) UpdateDue(ctx context.Context) error {
+	ids, err := u.queries.GetDueFeedIds(ctx, sql.NullTime{
+		Time:  time.Now().UTC(),
+		Valid: true,
+	})
+	if err != nil {
+		u.logger.Error(
+			"could not get due feeds from DB",
+			slog.String("err", err.Error()),
+		)
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return u.updateFeeds(ctx, ids)
+}
+
+func (u *Updater) updateFeeds(ctx context.Context, ids [][]byte) error {
+	feedIDs := make(chan string)
+	var wg sync.WaitGroup
+	for range u.concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range feedIDs {
+				u.updateOne(ctx, id)
+			}
+		}()
+	}
+
+	start := time.Now()
 	for _, idBytes := range ids {
 		if idBytes == nil {
-			logger.Warn("got a nil id from the DB")
+			u.logger.Warn("got a nil id from the DB")
 			continue
 		}
-		id := string(idBytes)
-		logger.Debug(
-			"updating feed",
-			slog.String("feed_id", id),
+		select {
+		case feedIDs <- string(idBytes):
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(feedIDs)
+	wg.Wait()
+
+	u.logger.Info(
+		"finished updating feeds",
+		slog.Int("num_feeds", len(ids)),
+		slog.Int64("duration_ms", time.Since(start).Milliseconds()),
+	)
+	return ctx.Err()
+}
+
+// Enqueue asks for feedID to be refreshed outside of its schedule. It never
+// blocks, and reports false if the queue is full.
+func (u *Updater) Enqueue(feedID string) bool {
+	select {
+	case u.queue <- feedID:
+		return true
+	default:
+		u.logger.Warn("refresh queue is full", slog.String("feed_id", feedID))
+		return false
+	}
+}
+
+// Run refreshes enqueued feeds until ctx is done.
+func (u *Updater) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for range u.concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case id := <-u.queue:
+					u.updateOne(ctx, id)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Refresh refreshes a feed now, and schedules its next refresh.
+func (u *Updater) Refresh(ctx context.Context, feedID string) error {
+	return u.updateOne(ctx, feedID)
+}
+
+// updateOne refreshes a feed unless it's already being refreshed, and
+// returns why the refresh failed. A failure to schedule the next one is
+// only logged.
+func (u *Updater) updateOne(ctx context.Context, id string) error {
+	logger := u.logger.With(slog.String("feed_id", id))
+
+	// The same feed can be both due and enqueued, only refresh it once
+	u.mu.Lock()
+	if u.inflight[id] {
+		u.mu.Unlock()
+		logger.Debug("feed is already updating")
+		return nil
+	}
+	u.inflight[id] = true
+	u.mu.Unlock()
+	defer func() {
+		u.mu.Lock()
+		delete(u.inflight, id)
+		u.mu.Unlock()
+	}()
+
+	ctx, span := tracing.Start(ctx, "refresh feed", attribute.String("feed_id", id))
+	var updateErr error
+	defer func() { tracing.End(span, updateErr) }()
+	if sc := span.SpanContext(); sc.IsValid() {
+		logger = logger.With(slog.String("trace_id", sc.TraceID().String()))
+	}
+	logger.Debug("updating feed")
+
+	updateErr = u.update(ctx, id)
+	metrics.ObserveFeedRefresh(id, updateErr)
+	if updateErr != nil {
+		logger.Error(
+			"could not update feed",
+			slog.String("err", updateErr.Error()),
+		)
+	} else {
+		logger.Info("updated feed")
+	}
+
+	// Failed feeds are retried on their normal schedule
+	next, err := u.scheduleNext(ctx, id)
+	if err != nil {
+		logger.Error(
+			"could not schedule next refresh",
+			slog.String("err", err.Error()),
 		)
-		err = update(ctx, id, baseURL, queries)
+		return updateErr
+	}
+	logger.Debug("scheduled next refresh", slog.Time("next_refresh_at", next))
+	return updateErr
+}
+
+func (u *Updater) scheduleNext(ctx context.Context, id string) (time.Time, error) {
+	mode := RefreshModeFixed
+	interval := u.schedule.defaultInterval
+
+	settings, err := u.queries.GetFeedSchedule(ctx, id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, err
+	}
+	if err == nil {
+		if m, err := ParseRefreshMode(settings.RefreshMode); err == nil {
+			mode = m
+		}
+		if settings.RefreshIntervalSeconds.Valid && settings.RefreshIntervalSeconds.Int64 > 0 {
+			interval = time.Duration(settings.RefreshIntervalSeconds.Int64) * time.Second
+		}
+	}
+
+	now := time.Now().UTC()
+	if mode == RefreshModeAdaptive {
+		rows, err := u.queries.GetRecentReleaseTimes(ctx, data.GetRecentReleaseTimesParams{
+			FeedID: id,
+			Limit:  numReleasesForCadence,
+		})
 		if err != nil {
-			logger.Error(
-				"could not update feed",
-				slog.String("err", err.Error()),
-			)
-			continue // TODO: handle this case
+			return time.Time{}, err
 		}
-		logger.Info(
-			"updated feed",
-			slog.String("feed_id", id),
-		)
+		releases := make([]time.Time, 0, len(rows))
+		for _, r := range rows {
+			releases = append(releases, r.Time)
+		}
+		cfg := u.schedule
+		cfg.defaultInterval = interval
+		interval = adaptiveInterval(releases, now, cfg)
 	}
-	return nil
+
+	next := now.Add(withJitter(interval, u.schedule.jitter))
+	err = u.queries.SetFeedRefreshed(ctx, data.SetFeedRefreshedParams{
+		FeedID:          id,
+		LastRefreshedAt: sql.NullTime{Time: now, Valid: true},
+		NextRefreshAt:   sql.NullTime{Time: next, Valid: true},
+	})
+	return next, err
 }
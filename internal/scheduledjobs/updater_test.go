@@ -0,0 +1,209 @@
+package scheduledjobs
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"vpod/internal/audio"
+	"vpod/internal/data"
+	"vpod/internal/podcast"
+	"vpod/internal/youtube"
+)
+
+func Test_changedVideos(t *testing.T) {
+	ep := func(id string, title string) data.Episode {
+		return data.Episode{Title: title, VideoID: id}
+	}
+	updated := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	entry := func(id string, title string) youtube.FeedEntry {
+		return youtube.FeedEntry{VideoId: id, Title: title, Updated: updated}
+	}
+
+	tests := []struct {
+		name    string
+		entries []youtube.FeedEntry
+		eps     []data.Episode
+		skipped map[string]time.Time
+		want    uint64
+	}{
+		{
+			name:    "Nothing changed",
+			entries: []youtube.FeedEntry{entry("b", "B"), entry("a", "A")},
+			eps:     []data.Episode{ep("a", "A"), ep("b", "B")},
+			want:    0,
+		},
+		{
+			name:    "One new video",
+			entries: []youtube.FeedEntry{entry("c", "C"), entry("b", "B"), entry("a", "A")},
+			eps:     []data.Episode{ep("a", "A"), ep("b", "B")},
+			want:    1,
+		},
+		{
+			name:    "Retitled older video",
+			entries: []youtube.FeedEntry{entry("c", "C"), entry("b", "B2"), entry("a", "A")},
+			eps:     []data.Episode{ep("a", "A"), ep("b", "B"), ep("c", "C")},
+			want:    2,
+		},
+		{
+			name:    "Brand new feed",
+			entries: []youtube.FeedEntry{entry("b", "B"), entry("a", "A")},
+			eps:     nil,
+			want:    2,
+		},
+		{
+			name:    "Skipped last time",
+			entries: []youtube.FeedEntry{entry("short", "S"), entry("b", "B"), entry("a", "A")},
+			eps:     []data.Episode{ep("a", "A"), ep("b", "B")},
+			skipped: map[string]time.Time{"short": updated},
+			want:    0,
+		},
+		{
+			name:    "Skipped video updated since",
+			entries: []youtube.FeedEntry{entry("c", "C"), entry("premiere", "P"), entry("a", "A")},
+			eps:     []data.Episode{ep("a", "A"), ep("c", "C")},
+			skipped: map[string]time.Time{"premiere": updated.Add(-time.Hour)},
+			want:    2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := changedVideos(tt.entries, tt.eps, tt.skipped); got != tt.want {
+				t.Errorf("changedVideos() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRememberSkipped(t *testing.T) {
+	ctx := context.Background()
+	queries := initDb(t)
+	u := &Updater{queries: queries, skipped: map[string]map[string]time.Time{}}
+	err := queries.UpsertEpisode(ctx, data.UpsertEpisodeParams{
+		ID:       []byte("https://vpod.example.com/audio/episodeAAAA/140"),
+		AudioUrl: "https://vpod.example.com/audio/episodeAAAA/140",
+		FeedID:   "UCfeed",
+		Title:    "Episode",
+		VideoID:  "episodeAAAA",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updated := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	entries := []youtube.FeedEntry{
+		{VideoId: "premiereAAA", Title: "Premiere", Updated: updated},
+		{VideoId: "episodeAAAA", Title: "Episode", Updated: updated},
+	}
+	if err := u.rememberSkipped(ctx, "UCfeed", entries); err != nil {
+		t.Fatal(err)
+	}
+	skipped := u.skipped["UCfeed"]
+	if len(skipped) != 1 || !skipped["premiereAAA"].Equal(updated) {
+		t.Errorf("skipped = %v, want only the premiere", skipped)
+	}
+
+	eps, err := queries.GetEpisodesForFeed(ctx, "UCfeed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := changedVideos(entries, eps, skipped); n != 0 {
+		t.Errorf("changedVideos() after remembering = %d, want 0", n)
+	}
+}
+
+func TestPrefetchNewestFollowsFeedSetting(t *testing.T) {
+	// A yt-dlp that writes its output like the real one does
+	bin := t.TempDir()
+	script := `#!/bin/sh
+for a; do case "$a" in --output=*) out="${a#--output=}";; esac; done
+printf 'audio' > "$out"
+`
+	if err := os.WriteFile(filepath.Join(bin, "yt-dlp"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger := slog.New(slog.DiscardHandler)
+	queries := initDb(t)
+	dir := t.TempDir()
+	downloader, err := audio.New(logger, queries, audio.Config{Dir: dir, MaxConcurrentDownloads: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer downloader.Close()
+	u := &Updater{downloader: downloader, logger: logger, queries: queries}
+
+	addFeed := func(id string, prefetch int64, videoIDs ...string) {
+		c := youtube.Channel{
+			Id:    id,
+			Title: id,
+			URL:   url.URL{Scheme: "https", Host: "youtube.com", Path: "/channel/" + id},
+		}
+		for i, v := range videoIDs {
+			c.Videos = append(c.Videos, youtube.Video{
+				Id:               v,
+				Title:            v,
+				Url:              "https://youtube.com/watch?v=" + v,
+				Duration:         300,
+				ReleaseTimestamp: youtube.UnixTime{Time: time.Date(2023, 5, 15+i, 12, 0, 0, 0, time.UTC)},
+				Formats: []youtube.VideoFormat{{
+					Id:         "140",
+					Resolution: "audio only",
+					AudioExt:   "m4a",
+					Language:   "en",
+				}},
+			})
+		}
+		p, err := podcast.FromChannel(c, url.URL{Scheme: "https", Host: "vpod.example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := podcast.UpsertPodcast(queries, *p, ctx); err != nil {
+			t.Fatal(err)
+		}
+		err = queries.SetFeedPrefetchCount(ctx, data.SetFeedPrefetchCountParams{
+			FeedID:        id,
+			PrefetchCount: prefetch,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	addFeed("UCoff", 0, "offAAAAAAAA")
+	addFeed("UCon", 1, "olderAAAAAA", "newerAAAAAA")
+
+	for _, id := range []string{"UCoff", "UCon"} {
+		if err := u.prefetchNewest(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	go downloader.Run(ctx)
+
+	// Prefetches run in order, so by the time the last one is done
+	// anything queued for UCoff would be too
+	newest := filepath.Join(dir, "newerAAAAAA.140.m4a")
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(newest); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("newest episode was never prefetched")
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Name() != "newerAAAAAA.140.m4a" {
+			t.Errorf("prefetched %s, want only the newest episode of UCon", e.Name())
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Package sessions keeps the frontend's sign ins. The cookie only carries a
+// random token, everything else stays in the DB where it can be revoked.
+package sessions
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"mime"
+	"net/http"
+	"time"
+	"vpod/internal/data"
+)
+
+const (
+	// CookieName is the cookie the session token is kept in.
+	CookieName = "vpod_session"
+	// CSRFHeader and CSRFField are where unsafe requests carry the CSRF
+	// token, the header for htmx and the field for plain forms.
+	CSRFHeader = "X-CSRF-Token"
+	CSRFField  = "csrf_token"
+)
+
+var ErrNotFound = errors.New("session not found")
+
+// Session is a signed in user's session.
+type Session struct {
+	Token     string
+	UserID    int64
+	CSRFToken string
+	ExpiresAt time.Time
+}
+
+type Store struct {
+	queries *data.Queries
+	ttl     time.Duration
+	now     func() time.Time
+}
+
+// New returns a Store whose sessions last for ttl.
+func New(queries *data.Queries, ttl time.Duration) *Store {
+	return &Store{queries: queries, ttl: ttl, now: time.Now}
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hash is what's stored instead of the token, so a leaked DB can't be used
+// to sign in.
+func hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create starts a session for a user. Expired sessions are cleaned up
+// while at it.
+func (s *Store) Create(ctx context.Context, userID int64) (Session, error) {
+	now := s.now().UTC()
+	if err := s.queries.DeleteExpiredSessions(ctx, now); err != nil {
+		return Session{}, err
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return Session{}, err
+	}
+	csrf, err := newToken()
+	if err != nil {
+		return Session{}, err
+	}
+	sess := Session{
+		Token:     token,
+		UserID:    userID,
+		CSRFToken: csrf,
+		ExpiresAt: now.Add(s.ttl),
+	}
+	err = s.queries.CreateSession(ctx, data.CreateSessionParams{
+		IDHash:    hash(token),
+		UserID:    userID,
+		CsrfToken: csrf,
+		ExpiresAt: sess.ExpiresAt,
+	})
+	if err != nil {
+		return Session{}, err
+	}
+	return sess, nil
+}
+
+// Lookup returns the session for a token, or ErrNotFound if there is none
+// or it has expired.
+func (s *Store) Lookup(ctx context.Context, token string) (Session, error) {
+	row, err := s.queries.GetSession(ctx, hash(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Session{}, ErrNotFound
+	} else if err != nil {
+		return Session{}, err
+	}
+	if !s.now().Before(row.ExpiresAt) {
+		return Session{}, ErrNotFound
+	}
+	return Session{
+		Token:     token,
+		UserID:    row.UserID,
+		CSRFToken: row.CsrfToken,
+		ExpiresAt: row.ExpiresAt,
+	}, nil
+}
+
+// Delete ends a session.
+func (s *Store) Delete(ctx context.Context, token string) error {
+	return s.queries.DeleteSession(ctx, hash(token))
+}
+
+// DeleteForUser ends every session of a user except the one for the token
+// given, which may be empty.
+func (s *Store) DeleteForUser(ctx context.Context, userID int64, except string) error {
+	exceptHash := ""
+	if except != "" {
+		exceptHash = hash(except)
+	}
+	return s.queries.DeleteUserSessions(ctx, data.DeleteUserSessionsParams{
+		UserID:       userID,
+		ExceptIDHash: exceptHash,
+	})
+}
+
+// CheckCSRF reports whether an unsafe request carries the session's CSRF
+// token. The field is only looked for in plain form posts, whose bodies
+// ParseForm caps; uploads have to use the header so they aren't read before
+// their handler limits them.
+func (sess Session) CheckCSRF(r *http.Request) bool {
+	got := r.Header.Get(CSRFHeader)
+	if got == "" && r.Method == http.MethodPost {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType == "application/x-www-form-urlencoded" {
+			got = r.PostFormValue(CSRFField)
+		}
+	}
+	return got != "" && subtle.ConstantTimeCompare([]byte(got), []byte(sess.CSRFToken)) == 1
+}
+
+// WithSession returns a copy of ctx carrying the session.
+func WithSession(ctx context.Context, sess Session) context.Context {
+	return context.WithValue(ctx, "session", sess)
+}
+
+// FromContext returns the request's session, if it was signed in with one.
+func FromContext(ctx context.Context) (Session, bool) {
+	sess, ok := ctx.Value("session").(Session)
+	return sess, ok
+}
@@ -0,0 +1,157 @@
+package sessions
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"vpod/internal/data"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func initDb(t *testing.T) *data.Queries {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	if err := data.Migrate(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	return data.New(db)
+}
+
+func TestLifecycle(t *testing.T) {
+	s := New(initDb(t), time.Hour)
+	ctx := context.Background()
+
+	sess, err := s.Create(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Lookup(ctx, sess.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.UserID != 1 || got.CSRFToken != sess.CSRFToken {
+		t.Errorf("Lookup() = %+v, want %+v", got, sess)
+	}
+
+	if err := s.Delete(ctx, sess.Token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Lookup(ctx, sess.Token); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Lookup() after Delete() err = %v, want ErrNotFound", err)
+	}
+	if _, err := s.Lookup(ctx, "unknown"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Lookup() of unknown token err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	s := New(initDb(t), time.Hour)
+	ctx := context.Background()
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	sess, err := s.Create(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.now = func() time.Time { return now.Add(59 * time.Minute) }
+	if _, err := s.Lookup(ctx, sess.Token); err != nil {
+		t.Errorf("Lookup() before expiry err = %v", err)
+	}
+	s.now = func() time.Time { return now.Add(time.Hour) }
+	if _, err := s.Lookup(ctx, sess.Token); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Lookup() after expiry err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestDeleteForUser(t *testing.T) {
+	s := New(initDb(t), time.Hour)
+	ctx := context.Background()
+
+	var own []Session
+	for range 3 {
+		sess, err := s.Create(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		own = append(own, sess)
+	}
+	other, err := s.Create(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.DeleteForUser(ctx, 1, own[0].Token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Lookup(ctx, own[0].Token); err != nil {
+		t.Errorf("Lookup() of kept session err = %v", err)
+	}
+	for _, sess := range own[1:] {
+		if _, err := s.Lookup(ctx, sess.Token); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Lookup() of ended session err = %v, want ErrNotFound", err)
+		}
+	}
+	if _, err := s.Lookup(ctx, other.Token); err != nil {
+		t.Errorf("Lookup() of another user's session err = %v", err)
+	}
+
+	if err := s.DeleteForUser(ctx, 1, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Lookup(ctx, own[0].Token); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Lookup() after ending every session err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestCheckCSRF(t *testing.T) {
+	sess := Session{CSRFToken: "secret"}
+	form := "application/x-www-form-urlencoded"
+	upload := "multipart/form-data; boundary=x"
+	uploadBody := "--x\r\nContent-Disposition: form-data; name=\"csrf_token\"\r\n\r\nsecret\r\n--x--\r\n"
+
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		header      string
+		body        string
+		want        bool
+	}{
+		{"header", "POST", form, "secret", "", true},
+		{"form field", "POST", form, "", url.Values{CSRFField: {"secret"}}.Encode(), true},
+		{"wrong header", "POST", form, "guess", url.Values{CSRFField: {"secret"}}.Encode(), false},
+		{"wrong form field", "POST", form, "", url.Values{CSRFField: {"guess"}}.Encode(), false},
+		{"missing", "POST", form, "", "", false},
+		{"upload with header", "PUT", upload, "secret", uploadBody, true},
+		{"upload field", "POST", upload, "", uploadBody, false},
+		{"put form field", "PUT", form, "", url.Values{CSRFField: {"secret"}}.Encode(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := strings.NewReader(tt.body)
+			r := httptest.NewRequest(tt.method, "/ui/gen", body)
+			r.Header.Set("Content-Type", tt.contentType)
+			if tt.header != "" {
+				r.Header.Set(CSRFHeader, tt.header)
+			}
+			if got := sess.CheckCSRF(r); got != tt.want {
+				t.Errorf("CheckCSRF() = %v, want %v", got, tt.want)
+			}
+			if !tt.want && tt.contentType == upload && body.Len() != len(tt.body) {
+				t.Error("CheckCSRF() read the upload's body")
+			}
+		})
+	}
+}
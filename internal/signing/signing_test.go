@@ -0,0 +1,116 @@
+package signing
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newSigner(t *testing.T, now time.Time) *Signer {
+	baseURL, err := url.Parse("https://vpod.local/pod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New([]byte(strings.Repeat("k", MinKeySize)), 24*time.Hour, *baseURL)
+	s.now = func() time.Time { return now }
+	return s
+}
+
+// query signs a URL and returns its query, failing if it wasn't signed.
+func query(t *testing.T, s *Signer, rawURL string) url.Values {
+	signed, err := url.Parse(s.Sign(rawURL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signed.Query().Get("sig") == "" {
+		t.Fatalf("Sign(%s) = %s, want it signed", rawURL, signed)
+	}
+	return signed.Query()
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 30, 0, 0, time.UTC)
+	s := newSigner(t, now)
+	q := query(t, s, "https://vpod.local/pod/audio/dQw4w9WgXcQ/140")
+
+	tampered := url.Values{"exp": {"99999999999"}, "sig": q["sig"]}
+
+	tests := []struct {
+		name     string
+		kind     string
+		videoID  string
+		formatID string
+		query    url.Values
+		after    time.Duration
+		want     error
+	}{
+		{"valid", "audio", "dQw4w9WgXcQ", "140", q, 0, nil},
+		{"valid until expiry", "audio", "dQw4w9WgXcQ", "140", q, 24 * time.Hour, nil},
+		{"expired", "audio", "dQw4w9WgXcQ", "140", q, 25 * time.Hour, ErrExpired},
+		{"another format", "audio", "dQw4w9WgXcQ", "251", q, 0, ErrInvalidSignature},
+		{"another video", "audio", "9bZkp7q19f0", "140", q, 0, ErrInvalidSignature},
+		{"as video", "video", "dQw4w9WgXcQ", "140", q, 0, ErrInvalidSignature},
+		{"extended expiry", "audio", "dQw4w9WgXcQ", "140", tampered, 0, ErrInvalidSignature},
+		{"unsigned", "audio", "dQw4w9WgXcQ", "140", url.Values{}, 0, ErrMissingSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.now = func() time.Time { return now.Add(tt.after) }
+			err := s.Verify(tt.kind, tt.videoID, tt.formatID, tt.query)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Verify() err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignStableWithinWindow(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 5, 0, 0, time.UTC)
+	s := newSigner(t, now)
+	first := s.Sign("https://vpod.local/pod/video/dQw4w9WgXcQ/18")
+	s.now = func() time.Time { return now.Add(50 * time.Minute) }
+	if second := s.Sign("https://vpod.local/pod/video/dQw4w9WgXcQ/18"); second != first {
+		t.Errorf("Sign() changed within the hour: %s then %s", first, second)
+	}
+}
+
+func TestSignFeed(t *testing.T) {
+	s := newSigner(t, time.Now())
+	feedXML := `<item><enclosure url="https://vpod.local/pod/audio/abc/140" length="1" type="audio/x-m4a"></enclosure>` +
+		`<guid>https://vpod.local/pod/audio/abc/140</guid>` +
+		`<podcast:alternateEnclosure type="video/mp4"><podcast:source uri="https://vpod.local/pod/video/abc/18"></podcast:source></podcast:alternateEnclosure>` +
+		`<link>https://www.youtube.com/watch?v=abc</link></item>`
+
+	got := s.SignFeed(feedXML)
+	if strings.Count(got, "sig=") != 2 {
+		t.Errorf("SignFeed() = %s, want both enclosures signed", got)
+	}
+	// & has to be escaped in attributes
+	if !strings.Contains(got, `/audio/abc/140?exp=`) || !strings.Contains(got, `&amp;sig=`) {
+		t.Errorf("SignFeed() = %s, want escaped signed urls", got)
+	}
+	if !strings.Contains(got, "<guid>https://vpod.local/pod/audio/abc/140</guid>") {
+		t.Errorf("SignFeed() = %s, changed the guid", got)
+	}
+
+	var nilSigner *Signer
+	if got := nilSigner.SignFeed(feedXML); got != feedXML {
+		t.Errorf("nil SignFeed() = %s, want the feed unchanged", got)
+	}
+}
+
+func TestSignIgnoresOtherURLs(t *testing.T) {
+	s := newSigner(t, time.Now())
+	for _, u := range []string{
+		"https://www.youtube.com/watch?v=abc",
+		"https://vpod.local/pod/feed/UCabc",
+		"https://vpod.local/pod/f/token/audio/abc/140",
+		"https://vpod.local/pod/audio/abc",
+	} {
+		if got := s.Sign(u); got != u {
+			t.Errorf("Sign(%s) = %s, want it unchanged", u, got)
+		}
+	}
+}
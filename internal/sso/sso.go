@@ -0,0 +1,268 @@
+// Package sso signs users in to the frontend with an OpenID Connect
+// provider, using the authorization code flow with PKCE. Only identities on
+// the allow lists get in, each is linked to a vpod user the first time they
+// sign in.
+package sso
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"vpod/internal/accounts"
+	"vpod/internal/data"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrNotAllowed    = errors.New("this account is not allowed to use vpod")
+	ErrInvalidNonce  = errors.New("id token nonce does not match")
+	ErrMissingClaims = errors.New("id token has no subject")
+)
+
+type Config struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback registered with the provider
+	RedirectURL string
+	Scopes      []string
+
+	// Anyone matching one of these can sign in
+	AllowedEmails  []string
+	AllowedDomains []string
+	AllowedGroups  []string
+	// Users in these groups are admins, when it's set
+	AdminGroups []string
+	GroupsClaim string
+}
+
+// Identity is who the provider says signed in.
+type Identity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string
+	Groups        []string
+}
+
+type Provider struct {
+	cfg      Config
+	oauth    oauth2.Config
+	verifier *oidc.IDTokenVerifier
+	queries  *data.Queries
+	users    *accounts.Store
+}
+
+// New discovers the provider's endpoints from its issuer URL.
+func New(ctx context.Context, cfg Config, queries *data.Queries, users *accounts.Store) (*Provider, error) {
+	p, err := oidc.NewProvider(ctx, cfg.IssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("could not discover oidc provider: %w", err)
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	scopes := cfg.Scopes
+	if !slices.Contains(scopes, oidc.ScopeOpenID) {
+		scopes = append([]string{oidc.ScopeOpenID}, scopes...)
+	}
+
+	return &Provider{
+		cfg: cfg,
+		oauth: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			Endpoint:     p.Endpoint(),
+			RedirectURL:  cfg.RedirectURL,
+			Scopes:       scopes,
+		},
+		verifier: p.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+		queries:  queries,
+		users:    users,
+	}, nil
+}
+
+// Flow is what has to be remembered between sending someone to the
+// provider and them coming back.
+type Flow struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Start begins a sign in, returning the provider URL to send the user to.
+func (p *Provider) Start() (Flow, string, error) {
+	state, err := randomString()
+	if err != nil {
+		return Flow{}, "", err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return Flow{}, "", err
+	}
+	f := Flow{State: state, Nonce: nonce, Verifier: oauth2.GenerateVerifier()}
+	authURL := p.oauth.AuthCodeURL(f.State, oidc.Nonce(f.Nonce), oauth2.S256ChallengeOption(f.Verifier))
+	return f, authURL, nil
+}
+
+// Exchange trades the code the provider sent back for the identity in its
+// verified ID token.
+func (p *Provider) Exchange(ctx context.Context, f Flow, code string) (Identity, error) {
+	tok, err := p.oauth.Exchange(ctx, code, oauth2.VerifierOption(f.Verifier))
+	if err != nil {
+		return Identity{}, fmt.Errorf("could not exchange code: %w", err)
+	}
+	rawIDToken, ok := tok.Extra("id_token").(string)
+	if !ok {
+		return Identity{}, errors.New("token response has no id token")
+	}
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return Identity{}, fmt.Errorf("could not verify id token: %w", err)
+	}
+	if idToken.Nonce != f.Nonce {
+		return Identity{}, ErrInvalidNonce
+	}
+
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		return Identity{}, err
+	}
+	id := Identity{
+		Issuer:  idToken.Issuer,
+		Subject: idToken.Subject,
+		Groups:  stringsClaim(claims[p.cfg.GroupsClaim]),
+	}
+	id.Email, _ = claims["email"].(string)
+	id.EmailVerified, _ = claims["email_verified"].(bool)
+	id.Username, _ = claims["preferred_username"].(string)
+	if id.Subject == "" {
+		return Identity{}, ErrMissingClaims
+	}
+	return id, nil
+}
+
+// stringsClaim reads a claim that is a list of strings, or a single one.
+func stringsClaim(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var out []string
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// Allowed reports whether an identity is on one of the allow lists. Emails
+// only count once the provider has verified them.
+func (p *Provider) Allowed(id Identity) bool {
+	if id.Email != "" && id.EmailVerified {
+		email := strings.ToLower(id.Email)
+		for _, e := range p.cfg.AllowedEmails {
+			if strings.ToLower(e) == email {
+				return true
+			}
+		}
+		_, domain, _ := strings.Cut(email, "@")
+		for _, d := range p.cfg.AllowedDomains {
+			if strings.ToLower(strings.TrimPrefix(d, "@")) == domain {
+				return true
+			}
+		}
+	}
+	return inGroups(id.Groups, p.cfg.AllowedGroups)
+}
+
+func inGroups(groups, want []string) bool {
+	for _, g := range groups {
+		if slices.Contains(want, g) {
+			return true
+		}
+	}
+	return false
+}
+
+var invalidUsernameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// username picks a vpod username for an identity, from the name it goes
+// by on the provider or its email.
+func username(id Identity) string {
+	name := id.Username
+	if name == "" {
+		name, _, _ = strings.Cut(id.Email, "@")
+	}
+	name = strings.Trim(invalidUsernameChars.ReplaceAllString(name, "-"), "-")
+	if len(name) > 56 {
+		name = name[:56]
+	}
+	if name == "" {
+		name = "user"
+	}
+	return name
+}
+
+// User returns the vpod user linked to an identity, creating one the first
+// time it signs in. Identities that aren't allowed get ErrNotAllowed, even
+// if they were before. When admin groups are set, they decide who is an
+// admin on every sign in.
+func (p *Provider) User(ctx context.Context, id Identity) (data.User, error) {
+	if !p.Allowed(id) {
+		return data.User{}, ErrNotAllowed
+	}
+	admin := inGroups(id.Groups, p.cfg.AdminGroups)
+
+	userID, err := p.queries.GetUserIdentity(ctx, data.GetUserIdentityParams{
+		Issuer:  id.Issuer,
+		Subject: id.Subject,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		u, err := p.users.CreateExternal(ctx, username(id), admin)
+		if err != nil {
+			return data.User{}, err
+		}
+		err = p.queries.CreateUserIdentity(ctx, data.CreateUserIdentityParams{
+			Issuer:  id.Issuer,
+			Subject: id.Subject,
+			UserID:  u.ID,
+			Email:   id.Email,
+		})
+		return u, err
+	} else if err != nil {
+		return data.User{}, err
+	}
+
+	u, err := p.users.Get(ctx, userID)
+	if err != nil {
+		return data.User{}, err
+	}
+	if len(p.cfg.AdminGroups) > 0 && u.IsAdmin != admin {
+		if err := p.users.SetAdmin(ctx, u.ID, admin); err != nil {
+			return data.User{}, err
+		}
+		u.IsAdmin = admin
+	}
+	return u, nil
+}
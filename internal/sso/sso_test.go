@@ -0,0 +1,294 @@
+package sso
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+	"vpod/internal/accounts"
+	"vpod/internal/data"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func initDb(t *testing.T) *data.Queries {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	if err := data.Migrate(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	return data.New(db)
+}
+
+// grant is an authorization the mock provider handed out a code for.
+type grant struct {
+	challenge string
+	claims    map[string]any
+}
+
+// mockProvider is just enough of an OIDC provider for the code flow: the
+// discovery document, its keys and a token endpoint that checks PKCE.
+type mockProvider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]grant
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockProvider{key: key, grants: map[string]grant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                m.URL,
+			"authorization_endpoint":                m.URL + "/authorize",
+			"token_endpoint":                        m.URL + "/token",
+			"jwks_uri":                              m.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("GET /keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"kid": "test",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, ok := r.BasicAuth(); !ok || id != "vpod" || secret != "secret" {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+		m.mu.Lock()
+		g, ok := m.grants[r.PostFormValue("code")]
+		delete(m.grants, r.PostFormValue("code"))
+		m.mu.Unlock()
+
+		sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     m.sign(t, g.claims),
+		})
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *mockProvider) sign(t *testing.T, claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": "test"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// authorize plays the user signing in at the provider, and returns the
+// code it would redirect back with. claims are added to the ID token.
+func (m *mockProvider) authorize(t *testing.T, authURL string, claims map[string]any) string {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Fatalf("authorization url %s has no S256 code challenge", authURL)
+	}
+	all := map[string]any{
+		"iss":   m.URL,
+		"aud":   "vpod",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": q.Get("nonce"),
+	}
+	for k, v := range claims {
+		all[k] = v
+	}
+
+	code := "code-" + q.Get("state")
+	m.mu.Lock()
+	m.grants[code] = grant{challenge: q.Get("code_challenge"), claims: all}
+	m.mu.Unlock()
+	return code
+}
+
+func newProvider(t *testing.T, cfg Config) (*Provider, *mockProvider) {
+	m := newMockProvider(t)
+	queries := initDb(t)
+	cfg.IssuerURL = m.URL
+	cfg.ClientID = "vpod"
+	cfg.ClientSecret = "secret"
+	cfg.RedirectURL = "https://vpod.local/ui/oidc/callback"
+	p, err := New(context.Background(), cfg, queries, accounts.New(queries))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, m
+}
+
+func TestLogin(t *testing.T) {
+	p, m := newProvider(t, Config{
+		AllowedDomains: []string{"example.com"},
+		AdminGroups:    []string{"vpod-admins"},
+	})
+	ctx := context.Background()
+
+	login := func(claims map[string]any) (data.User, error) {
+		flow, authURL, err := p.Start()
+		if err != nil {
+			t.Fatal(err)
+		}
+		code := m.authorize(t, authURL, claims)
+		id, err := p.Exchange(ctx, flow, code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p.User(ctx, id)
+	}
+
+	claims := map[string]any{
+		"sub":                "alice-id",
+		"email":              "alice@example.com",
+		"email_verified":     true,
+		"preferred_username": "alice@example.com",
+		"groups":             []string{"vpod-admins"},
+	}
+	first, err := login(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Username != "alice-example.com" || !first.IsAdmin {
+		t.Errorf("User() = %+v, want admin alice-example.com", first)
+	}
+
+	// Same subject, same user, and admin rights follow the groups
+	claims["groups"] = []string{}
+	second, err := login(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.ID != first.ID || second.IsAdmin {
+		t.Errorf("User() on second login = %+v, want user %d without admin", second, first.ID)
+	}
+
+	// Another subject with the same name gets its own user
+	claims["sub"] = "other-alice-id"
+	other, err := login(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.ID == first.ID || other.Username != "alice-example.com-2" {
+		t.Errorf("User() for another subject = %+v, want a new user", other)
+	}
+
+	claims["email"] = "mallory@evil.test"
+	if _, err := login(claims); !errors.Is(err, ErrNotAllowed) {
+		t.Errorf("User() for another domain err = %v, want ErrNotAllowed", err)
+	}
+}
+
+func TestExchangeChecksPKCE(t *testing.T) {
+	p, m := newProvider(t, Config{AllowedDomains: []string{"example.com"}})
+	flow, authURL, err := p.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := m.authorize(t, authURL, map[string]any{"sub": "alice-id"})
+
+	// A stolen code is no use without the verifier
+	other, _, err := p.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	flow.Verifier = other.Verifier
+	if _, err := p.Exchange(context.Background(), flow, code); err == nil {
+		t.Error("Exchange() with the wrong verifier succeeded")
+	}
+}
+
+func TestExchangeChecksNonce(t *testing.T) {
+	p, m := newProvider(t, Config{AllowedDomains: []string{"example.com"}})
+	flow, authURL, err := p.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := m.authorize(t, authURL, map[string]any{"sub": "alice-id"})
+
+	flow.Nonce = "replayed"
+	if _, err := p.Exchange(context.Background(), flow, code); !errors.Is(err, ErrInvalidNonce) {
+		t.Errorf("Exchange() err = %v, want ErrInvalidNonce", err)
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	p := &Provider{cfg: Config{
+		AllowedEmails:  []string{"Bob@Elsewhere.test"},
+		AllowedDomains: []string{"example.com"},
+		AllowedGroups:  []string{"podcasters"},
+	}}
+
+	tests := []struct {
+		name string
+		id   Identity
+		want bool
+	}{
+		{"allowed email", Identity{Email: "bob@elsewhere.test", EmailVerified: true}, true},
+		{"allowed domain", Identity{Email: "alice@example.com", EmailVerified: true}, true},
+		{"unverified email", Identity{Email: "alice@example.com"}, false},
+		{"subdomain", Identity{Email: "alice@evil.example.com", EmailVerified: true}, false},
+		{"allowed group", Identity{Groups: []string{"staff", "podcasters"}}, true},
+		{"other group", Identity{Groups: []string{"staff"}}, false},
+		{"nothing", Identity{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.Allowed(tt.id); got != tt.want {
+				t.Errorf("Allowed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+// Package tokens gives each subscriber of a private feed their own secret
+// URL, so one subscriber's access can be revoked without affecting the rest.
+package tokens
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strings"
+	"vpod/internal/data"
+	"vpod/internal/podcast"
+)
+
+// tokenBytes is how much randomness goes into a token.
+const tokenBytes = 32
+
+var ErrNotFound = errors.New("token not found")
+
+// Grant is a valid token, and the feed it gives access to.
+type Grant struct {
+	ID     int64
+	FeedID string
+	Name   string
+	Token  string
+}
+
+// Store issues and checks tokens. Only a hash of each token is kept, the
+// token itself is shown once when it is issued.
+//
+// Tokens remember the user that issued them. Managing tokens takes a user id
+// to limit it to that user's tokens, or 0 for every token of the feed, which
+// is for admins and the command line.
+type Store struct {
+	baseURL url.URL
+	db      *sql.DB
+	queries *data.Queries
+}
+
+func New(db *sql.DB, queries *data.Queries, baseURL url.URL) *Store {
+	return &Store{baseURL: baseURL, db: db, queries: queries}
+}
+
+func hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// byUser turns a user id into a creator, where 0 is nobody.
+func byUser(userID int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: userID, Valid: userID != 0}
+}
+
+// Create issues a new token for a feed on behalf of a user, or nobody for
+// 0. name is only there to tell subscribers apart when revoking.
+func (s *Store) Create(ctx context.Context, feedID string, name string, userID int64) (Grant, error) {
+	return create(ctx, s.queries, feedID, name, byUser(userID))
+}
+
+func create(ctx context.Context, queries *data.Queries, feedID string, name string, createdBy sql.NullInt64) (Grant, error) {
+	b := make([]byte, tokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return Grant{}, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	id, err := queries.CreateFeedToken(ctx, data.CreateFeedTokenParams{
+		FeedID:    feedID,
+		Name:      name,
+		TokenHash: hash(token),
+		CreatedBy: createdBy,
+	})
+	if err != nil {
+		return Grant{}, err
+	}
+	return Grant{ID: id, FeedID: feedID, Name: name, Token: token}, nil
+}
+
+// List returns the tokens for a feed that haven't been revoked.
+func (s *Store) List(ctx context.Context, feedID string, userID int64) ([]data.FeedToken, error) {
+	return s.queries.GetFeedTokens(ctx, data.GetFeedTokensParams{
+		FeedID:    feedID,
+		CreatedBy: byUser(userID),
+	})
+}
+
+// Revoke stops a token from working. It returns ErrNotFound if the feed has
+// no such token, or it was already revoked.
+func (s *Store) Revoke(ctx context.Context, feedID string, id int64, userID int64) error {
+	_, err := revoke(ctx, s.queries, feedID, id, userID)
+	return err
+}
+
+func revoke(ctx context.Context, queries *data.Queries, feedID string, id int64, userID int64) (data.RevokeFeedTokenRow, error) {
+	row, err := queries.RevokeFeedToken(ctx, data.RevokeFeedTokenParams{
+		ID:        id,
+		FeedID:    feedID,
+		CreatedBy: byUser(userID),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return row, ErrNotFound
+	}
+	return row, err
+}
+
+// Rotate revokes a token and issues a new one with the same name and
+// creator, for when a subscriber's URL has leaked. If it fails the old token
+// keeps working.
+func (s *Store) Rotate(ctx context.Context, feedID string, id int64, userID int64) (Grant, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Grant{}, err
+	}
+	defer tx.Rollback()
+	queries := s.queries.WithTx(tx)
+
+	old, err := revoke(ctx, queries, feedID, id, userID)
+	if err != nil {
+		return Grant{}, err
+	}
+	g, err := create(ctx, queries, feedID, old.Name, old.CreatedBy)
+	if err != nil {
+		return Grant{}, err
+	}
+	return g, tx.Commit()
+}
+
+// Lookup checks a token, returning ErrNotFound if it was never issued or has
+// been revoked.
+func (s *Store) Lookup(ctx context.Context, token string) (Grant, error) {
+	row, err := s.queries.GetFeedTokenByHash(ctx, hash(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Grant{}, ErrNotFound
+	} else if err != nil {
+		return Grant{}, err
+	}
+	return Grant{ID: row.ID, FeedID: row.FeedID, Token: token}, nil
+}
+
+// WithGrant returns a copy of ctx carrying the grant of the request's token.
+func WithGrant(ctx context.Context, g Grant) context.Context {
+	return context.WithValue(ctx, "grant", g)
+}
+
+// FromContext returns the grant of the request's token, if it had one.
+func FromContext(ctx context.Context) (Grant, bool) {
+	g, ok := ctx.Value("grant").(Grant)
+	return g, ok
+}
+
+// CanRead reports whether the request in ctx may read a feed. Anyone may
+// read a public feed, private feeds need a token for that feed.
+func (s *Store) CanRead(ctx context.Context, feedID string) (bool, error) {
+	private, err := s.queries.GetFeedPrivate(ctx, feedID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Feeds without settings are public
+		private = false
+	} else if err != nil {
+		return false, err
+	}
+	if !private {
+		return true, nil
+	}
+	g, ok := FromContext(ctx)
+	return ok && g.FeedID == feedID, nil
+}
+
+// EnclosureFeed returns the feed the request in ctx downloads a video's
+// enclosure of kind, audio or video, in a format through. It's feedID when
+// the request names one, otherwise the first feed by id it may read whose
+// episode has that enclosure. Requests through a token always go through
+// the token's feed. ok is false when there's no such feed: videos that
+// aren't an episode of any feed, and formats no feed offers, are never
+// allowed, so the server can't be used to download arbitrary videos or
+// streams.
+func (s *Store) EnclosureFeed(ctx context.Context, kind, videoID, formatID, feedID string) (string, bool, error) {
+	if g, ok := FromContext(ctx); ok {
+		feedID = g.FeedID
+	}
+	eps, err := s.queries.GetEpisodesForVideo(ctx, videoID)
+	if err != nil {
+		return "", false, err
+	}
+	for _, ep := range eps {
+		if feedID != "" && ep.FeedID != feedID {
+			continue
+		}
+		enclosure := ep.AudioUrl
+		if kind == "video" {
+			enclosure = ep.VideoEnclosureUrl.String
+		}
+		if !hasEnclosure(enclosure, kind, videoID, formatID) {
+			continue
+		}
+		ok, err := s.CanRead(ctx, ep.FeedID)
+		if err != nil {
+			return "", false, err
+		}
+		if ok {
+			return ep.FeedID, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// hasEnclosure reports whether an enclosure url stored for an episode is
+// the one for kind, videoID and formatID.
+func hasEnclosure(rawURL, kind, videoID, formatID string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	return strings.HasSuffix(u.Path, "/"+kind+"/"+videoID+"/"+formatID)
+}
+
+// URL returns a subscriber's secret URL for a feed.
+func (s *Store) URL(g Grant) string {
+	return s.baseURL.JoinPath("f", g.Token, "feed", g.FeedID).String()
+}
+
+// Rewrite points the enclosures in feedID's stored feed at the token's
+// URLs, so apps send the token when downloading episodes too. Feeds are
+// stored with plain URLs, which without a token in ctx get the feed added
+// to their query instead. Either way downloads say which feed they're for,
+// and are produced with its settings.
+func (s *Store) Rewrite(ctx context.Context, feedID, xml string) string {
+	g, ok := FromContext(ctx)
+	return podcast.RewriteEnclosures(xml, func(u string) string {
+		for _, kind := range []string{"audio", "video"} {
+			plain := s.baseURL.JoinPath(kind).String() + "/"
+			rest, found := strings.CutPrefix(u, plain)
+			if !found {
+				continue
+			}
+			if ok {
+				return s.baseURL.JoinPath("f", g.Token, kind).String() + "/" + rest
+			}
+			return u + "?feed=" + url.QueryEscape(feedID)
+		}
+		return u
+	})
+}
@@ -0,0 +1,248 @@
+package tokens
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"vpod/internal/data"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func initDb(t *testing.T) (*sql.DB, *data.Queries) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	if err := data.Migrate(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	return db, data.New(db)
+}
+
+func newStore(t *testing.T) (*Store, *sql.DB) {
+	db, queries := initDb(t)
+	ctx := context.Background()
+	for _, ep := range []struct{ feedID, videoID string }{
+		{"UCprivate", "vid-UCprivate"},
+		{"UCpublic", "vid-UCpublic"},
+		// Videos can be in more than one feed
+		{"UCprivate", "vid-UCpublic"},
+		{"UCsecret", "vid-UCprivate"},
+	} {
+		err := queries.UpsertEpisode(ctx, data.UpsertEpisodeParams{
+			ID:       []byte(ep.videoID),
+			AudioUrl: "https://vpod.local/audio/" + ep.videoID + "/140",
+			FeedID:   ep.feedID,
+			Title:    "Episode",
+			VideoID:  ep.videoID,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, feedID := range []string{"UCprivate", "UCsecret"} {
+		err := queries.SetFeedPrivate(ctx, data.SetFeedPrivateParams{FeedID: feedID, Private: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	baseURL, _ := url.Parse("https://vpod.local")
+	return New(db, queries, *baseURL), db
+}
+
+func TestLifecycle(t *testing.T) {
+	s, _ := newStore(t)
+	ctx := context.Background()
+
+	g, err := s.Create(ctx, "UCprivate", "phone", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Lookup(ctx, g.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != g.ID || got.FeedID != "UCprivate" {
+		t.Errorf("Lookup() = %+v, want %+v", got, g)
+	}
+
+	rotated, err := s.Rotate(ctx, "UCprivate", g.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.Name != "phone" || rotated.Token == g.Token {
+		t.Errorf("Rotate() = %+v, want a new token named phone", rotated)
+	}
+	if _, err := s.Lookup(ctx, g.Token); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Lookup() of rotated token err = %v, want ErrNotFound", err)
+	}
+
+	// Tokens can only be revoked through their own feed
+	if err := s.Revoke(ctx, "UCpublic", rotated.ID, 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Revoke() through another feed err = %v, want ErrNotFound", err)
+	}
+	if err := s.Revoke(ctx, "UCprivate", rotated.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Lookup(ctx, rotated.Token); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Lookup() of revoked token err = %v, want ErrNotFound", err)
+	}
+
+	list, err := s.List(ctx, "UCprivate", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 0 {
+		t.Errorf("List() = %+v, want no tokens", list)
+	}
+}
+
+func TestUsersManageTheirOwnTokens(t *testing.T) {
+	s, _ := newStore(t)
+	ctx := context.Background()
+	const alice, bob = 1, 2
+
+	g, err := s.Create(ctx, "UCprivate", "phone", alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list, err := s.List(ctx, "UCprivate", bob); err != nil || len(list) != 0 {
+		t.Errorf("List() for another user = %+v, %v, want no tokens", list, err)
+	}
+	if _, err := s.Rotate(ctx, "UCprivate", g.ID, bob); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Rotate() by another user err = %v, want ErrNotFound", err)
+	}
+	if err := s.Revoke(ctx, "UCprivate", g.ID, bob); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Revoke() by another user err = %v, want ErrNotFound", err)
+	}
+
+	// Admins can rotate anyone's token, which stays theirs
+	rotated, err := s.Rotate(ctx, "UCprivate", g.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := s.List(ctx, "UCprivate", alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].ID != rotated.ID {
+		t.Errorf("List() = %+v, want the rotated token", list)
+	}
+	if err := s.Revoke(ctx, "UCprivate", rotated.ID, alice); err != nil {
+		t.Errorf("Revoke() of own token: %v", err)
+	}
+}
+
+func TestRotateIsAtomic(t *testing.T) {
+	s, db := newStore(t)
+	ctx := context.Background()
+
+	g, err := s.Create(ctx, "UCprivate", "phone", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE TRIGGER no_tokens BEFORE INSERT ON FeedTokens BEGIN SELECT RAISE(ABORT, 'no new tokens'); END`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Rotate(ctx, "UCprivate", g.ID, 0); err == nil {
+		t.Fatal("Rotate() succeeded without issuing a token")
+	}
+	if _, err := s.Lookup(ctx, g.Token); err != nil {
+		t.Errorf("a failed rotation revoked the old token: %v", err)
+	}
+}
+
+func TestEnclosureFeed(t *testing.T) {
+	s, _ := newStore(t)
+	ctx := context.Background()
+
+	g, err := s.Create(ctx, "UCprivate", "phone", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := s.Create(ctx, "UCpublic", "phone", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := s.Create(ctx, "UCsecret", "phone", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		kind     string
+		videoID  string
+		formatID string
+		feedID   string
+		want     string
+	}{
+		{"public without token", ctx, "audio", "vid-UCpublic", "140", "", "UCpublic"},
+		{"private without token", ctx, "audio", "vid-UCprivate", "140", "", ""},
+		{"private with token", WithGrant(ctx, g), "audio", "vid-UCprivate", "140", "", "UCprivate"},
+		{"private with another feed's token", WithGrant(ctx, other), "audio", "vid-UCprivate", "140", "", ""},
+		{"private with the token of another feed it's in", WithGrant(ctx, secret), "audio", "vid-UCprivate", "140", "", "UCsecret"},
+		{"also in a private feed", ctx, "audio", "vid-UCpublic", "140", "", "UCpublic"},
+		{"unknown video", WithGrant(ctx, g), "audio", "dQw4w9WgXcQ", "140", "", ""},
+		{"another format", ctx, "audio", "vid-UCpublic", "137", "", ""},
+		{"selector", ctx, "audio", "vid-UCpublic", "bestvideo", "", ""},
+		{"audio format as video", ctx, "video", "vid-UCpublic", "140", "", ""},
+		{"named feed", ctx, "audio", "vid-UCpublic", "140", "UCpublic", "UCpublic"},
+		{"named private feed", ctx, "audio", "vid-UCpublic", "140", "UCprivate", ""},
+		{"named feed it isn't in", ctx, "audio", "vid-UCpublic", "140", "UCother", ""},
+		{"token over named feed", WithGrant(ctx, g), "audio", "vid-UCpublic", "140", "UCpublic", "UCprivate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok, err := s.EnclosureFeed(tt.ctx, tt.kind, tt.videoID, tt.formatID, tt.feedID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || ok != (tt.want != "") {
+				t.Errorf("EnclosureFeed() = %q, %v, want %q", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	s, _ := newStore(t)
+	ctx := context.Background()
+	xml := `<enclosure url="https://vpod.local/audio/abc/140" length="1" type="audio/x-m4a"></enclosure>` +
+		`<guid>https://vpod.local/audio/abc/140</guid>` +
+		`<podcast:source uri="https://vpod.local/video/abc/18"></podcast:source>`
+
+	got := s.Rewrite(ctx, "UCpublic", xml)
+	for _, want := range []string{
+		"https://vpod.local/audio/abc/140?feed=UCpublic",
+		"https://vpod.local/video/abc/18?feed=UCpublic",
+		"<guid>https://vpod.local/audio/abc/140</guid>",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Rewrite() without a token = %s, want it to contain %s", got, want)
+		}
+	}
+
+	g := Grant{FeedID: "UCprivate", Token: "secret"}
+	got = s.Rewrite(WithGrant(ctx, g), "UCprivate", xml)
+	for _, want := range []string{
+		"https://vpod.local/f/secret/audio/abc/140",
+		"https://vpod.local/f/secret/video/abc/18",
+		// guids must not change, or apps see every episode as new
+		"<guid>https://vpod.local/audio/abc/140</guid>",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Rewrite() = %s, want it to contain %s", got, want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// collector is a stand in for an OTLP/HTTP collector that keeps every span
+// it's sent.
+type collector struct {
+	mu    sync.Mutex
+	paths []string
+	spans []*tracepb.Span
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req collectortrace.ExportTraceServiceRequest
+	if err := proto.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paths = append(c.paths, r.URL.Path)
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			c.spans = append(c.spans, ss.Spans...)
+		}
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(nil)
+}
+
+func TestSetupExportsToCollector(t *testing.T) {
+	c := &collector{}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+	defer otel.SetTracerProvider(otel.GetTracerProvider())
+
+	shutdown, err := Setup(context.Background(), Config{Endpoint: srv.URL, SampleRatio: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, parent := Start(context.Background(), "job update feeds")
+	_, child := Start(ctx, "yt-dlp channel", attribute.String("url", "https://www.youtube.com/@example"))
+	End(child, errors.New("yt-dlp failed"))
+	End(parent, nil)
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, p := range c.paths {
+		if p != "/v1/traces" {
+			t.Errorf("exported to %q, want /v1/traces", p)
+		}
+	}
+	byName := map[string]*tracepb.Span{}
+	for _, s := range c.spans {
+		byName[s.Name] = s
+	}
+	p, ok := byName["job update feeds"]
+	if !ok {
+		t.Fatalf("parent span not exported, got %v", c.spans)
+	}
+	ch, ok := byName["yt-dlp channel"]
+	if !ok {
+		t.Fatalf("child span not exported, got %v", c.spans)
+	}
+	if string(ch.ParentSpanId) != string(p.SpanId) || string(ch.TraceId) != string(p.TraceId) {
+		t.Error("child span isn't part of the parent's trace")
+	}
+	if ch.Status.GetCode() != tracepb.Status_STATUS_CODE_ERROR {
+		t.Errorf("child status = %v, want error", ch.Status.GetCode())
+	}
+	if p.Status.GetCode() == tracepb.Status_STATUS_CODE_ERROR {
+		t.Error("parent span is marked as failed")
+	}
+}
+
+func TestSetupWithoutEndpoint(t *testing.T) {
+	shutdown, err := Setup(context.Background(), Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := Start(context.Background(), "nothing")
+	if span.SpanContext().IsSampled() {
+		t.Error("span recorded without an endpoint")
+	}
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,340 @@
+package websub
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"vpod/internal/data"
+)
+
+// maxNotificationBytes bounds how much of a pushed notification we read.
+const maxNotificationBytes = 1 * 1024 * 1024
+
+type Config struct {
+	// HubURL is where subscription requests are sent.
+	HubURL *url.URL
+	// TopicURL is the channel feed URL without the channel_id query.
+	TopicURL *url.URL
+	// CallbackBaseURL is the public base url of vpod. Callbacks are served
+	// under /websub/{feedID}.
+	CallbackBaseURL *url.URL
+	// Lease is the subscription lifetime we ask the hub for.
+	Lease time.Duration
+	// RenewBefore is how long before a lease expires it gets renewed.
+	RenewBefore time.Duration
+}
+
+type Subscriber struct {
+	cfg     Config
+	client  *http.Client
+	logger  *slog.Logger
+	notify  func(feedID string)
+	queries *data.Queries
+}
+
+// New creates a Subscriber. notify is called with the feed id whenever the
+// hub pushes a validly signed notification for that feed.
+func New(
+	logger *slog.Logger,
+	queries *data.Queries,
+	cfg Config,
+	notify func(feedID string),
+) (*Subscriber, error) {
+	if cfg.HubURL == nil || cfg.TopicURL == nil || cfg.CallbackBaseURL == nil {
+		return nil, errors.New("websub hub, topic and callback urls are required")
+	}
+	if cfg.Lease <= 0 {
+		return nil, errors.New("websub lease must be positive")
+	}
+	if notify == nil {
+		return nil, errors.New("websub notify func is required")
+	}
+	return &Subscriber{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		logger:  logger,
+		notify:  notify,
+		queries: queries,
+	}, nil
+}
+
+func (s *Subscriber) topic(feedID string) string {
+	u := *s.cfg.TopicURL
+	q := u.Query()
+	q.Set("channel_id", feedID)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func (s *Subscriber) callback(feedID string) string {
+	return s.cfg.CallbackBaseURL.JoinPath("websub", feedID).String()
+}
+
+// Subscribe asks the hub to push updates for feedID to us. The hub confirms
+// the subscription asynchronously by calling Verify.
+func (s *Subscriber) Subscribe(ctx context.Context, feedID string) error {
+	sub, err := s.queries.GetWebSubSubscription(ctx, feedID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	// Keep the secret across renewals, so notifications signed with it are
+	// still accepted while the hub processes the renewal.
+	secret := sub.Secret
+	if secret == "" {
+		secret, err = newSecret()
+		if err != nil {
+			return err
+		}
+	}
+
+	// The hub's verification is only accepted while this request is
+	// pending, so nobody else can confirm or cancel our subscriptions.
+	topic := s.topic(feedID)
+	err = s.queries.UpsertWebSubSubscription(ctx, data.UpsertWebSubSubscriptionParams{
+		FeedID:      feedID,
+		Topic:       topic,
+		Secret:      secret,
+		PendingMode: sql.NullString{String: "subscribe", Valid: true},
+	})
+	if err != nil {
+		return err
+	}
+
+	form := url.Values{}
+	form.Set("hub.callback", s.callback(feedID))
+	form.Set("hub.mode", "subscribe")
+	form.Set("hub.topic", topic)
+	form.Set("hub.secret", secret)
+	form.Set("hub.lease_seconds", strconv.Itoa(int(s.cfg.Lease.Seconds())))
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		s.cfg.HubURL.String(),
+		strings.NewReader(form.Encode()),
+	)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("hub rejected subscription with status %d: %s", resp.StatusCode, body)
+	}
+	return nil
+}
+
+// RenewAll subscribes every feed that has no lease, or whose lease is about
+// to expire.
+func (s *Subscriber) RenewAll(ctx context.Context) error {
+	ids, err := s.queries.GetFeedIdsNeedingWebSub(ctx, sql.NullTime{
+		Time:  time.Now().UTC().Add(s.cfg.RenewBefore),
+		Valid: true,
+	})
+	if err != nil {
+		s.logger.Error(
+			"could not get feeds needing websub subscriptions from DB",
+			slog.String("err", err.Error()),
+		)
+		return err
+	}
+
+	for _, idBytes := range ids {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		id := string(idBytes)
+		logger := s.logger.With(slog.String("feed_id", id))
+		if err := s.Subscribe(ctx, id); err != nil {
+			logger.Error(
+				"could not subscribe to websub hub",
+				slog.String("err", err.Error()),
+			)
+			continue
+		}
+		logger.Debug("requested websub subscription")
+	}
+	return nil
+}
+
+// Verify answers the hub's intent verification for a subscription. Only
+// verifications of the request we have pending for the feed are confirmed.
+func (s *Subscriber) Verify() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := ctx.Value("logger").(*slog.Logger)
+
+		feedID := r.PathValue("feedID")
+		logger = logger.With(slog.String("feed_id", feedID))
+
+		q := r.URL.Query()
+		mode := q.Get("hub.mode")
+		topic := q.Get("hub.topic")
+
+		sub, err := s.queries.GetWebSubSubscription(ctx, feedID)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Unknown subscription", http.StatusNotFound)
+			return
+		} else if err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Could not get websub subscription")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if topic != sub.Topic {
+			logger.Warn("websub verification for unexpected topic", slog.String("topic", topic))
+			http.Error(w, "Unknown topic", http.StatusNotFound)
+			return
+		}
+
+		// Hubs deny a subscription in place of verifying it
+		pending := mode
+		if mode == "denied" {
+			pending = "subscribe"
+		}
+		if !sub.PendingMode.Valid || sub.PendingMode.String != pending {
+			logger.Warn("websub verification without a pending request", slog.String("mode", mode))
+			http.Error(w, "No pending request", http.StatusNotFound)
+			return
+		}
+
+		var lease sql.NullTime
+		switch mode {
+		case "subscribe":
+			seconds, err := strconv.ParseInt(q.Get("hub.lease_seconds"), 10, 64)
+			if err != nil || seconds <= 0 {
+				http.Error(w, "Invalid hub.lease_seconds", http.StatusBadRequest)
+				return
+			}
+			// Hubs may grant less than we asked for, but never need more.
+			// This also keeps the duration from overflowing.
+			seconds = min(seconds, int64(s.cfg.Lease/time.Second))
+			lease = sql.NullTime{
+				Time:  time.Now().UTC().Add(time.Duration(seconds) * time.Second),
+				Valid: true,
+			}
+		case "unsubscribe":
+		case "denied":
+			logger.Warn("websub hub denied subscription", slog.String("reason", q.Get("hub.reason")))
+		default:
+			http.Error(w, "Invalid hub.mode", http.StatusBadRequest)
+			return
+		}
+
+		err = s.queries.SetWebSubLease(ctx, data.SetWebSubLeaseParams{
+			LeaseExpiresAt: lease,
+			FeedID:         feedID,
+		})
+		if err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Could not save websub lease")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("verified websub intent", slog.String("mode", mode))
+
+		if mode == "denied" {
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(q.Get("hub.challenge")))
+	}
+}
+
+// Notify handles content pushed by the hub and enqueues a refresh of the feed.
+func (s *Subscriber) Notify() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := ctx.Value("logger").(*slog.Logger)
+
+		feedID := r.PathValue("feedID")
+		logger = logger.With(slog.String("feed_id", feedID))
+
+		sub, err := s.queries.GetWebSubSubscription(ctx, feedID)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Unknown subscription", http.StatusNotFound)
+			return
+		} else if err != nil {
+			logger.With(slog.String("err", err.Error())).Error("Could not get websub subscription")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxNotificationBytes))
+		if err != nil {
+			http.Error(w, "Could not read body", http.StatusBadRequest)
+			return
+		}
+
+		// Per the spec, invalid signatures are ignored but still acknowledged
+		// so the hub doesn't keep retrying them.
+		if !validSignature(r.Header.Get("X-Hub-Signature"), body, sub.Secret) {
+			logger.Warn("ignoring websub notification with invalid signature")
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+
+		logger.Info("received websub notification")
+		s.notify(feedID)
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// validSignature checks an X-Hub-Signature header of the form "method=hex".
+func validSignature(header string, body []byte, secret string) bool {
+	method, sigHex, ok := strings.Cut(header, "=")
+	if !ok {
+		return false
+	}
+
+	var h func() hash.Hash
+	switch method {
+	case "sha1":
+		h = sha1.New
+	case "sha256":
+		h = sha256.New
+	case "sha384":
+		h = sha512.New384
+	case "sha512":
+		h = sha512.New
+	default:
+		return false
+	}
+
+	got, err := hex.DecodeString(sigHex)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
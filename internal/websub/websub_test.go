@@ -0,0 +1,331 @@
+package websub
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+	"time"
+	"vpod/internal/data"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// fakeHub is a minimal WebSub hub. It verifies intent for subscriptions
+// synchronously, and can publish signed content to its subscribers.
+type fakeHub struct {
+	t      *testing.T
+	mu     sync.Mutex
+	subs   map[string]fakeSub // keyed by topic
+	client *http.Client
+}
+
+type fakeSub struct {
+	callback string
+	secret   string
+}
+
+func newFakeHub(t *testing.T) (*fakeHub, *httptest.Server) {
+	h := &fakeHub{
+		t:      t,
+		subs:   make(map[string]fakeSub),
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+	return h, httptest.NewServer(h)
+}
+
+func (h *fakeHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	callback := r.FormValue("hub.callback")
+	topic := r.FormValue("hub.topic")
+
+	challenge := "challenge-" + topic
+	u, err := url.Parse(callback)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q := u.Query()
+	q.Set("hub.mode", r.FormValue("hub.mode"))
+	q.Set("hub.topic", topic)
+	q.Set("hub.challenge", challenge)
+	q.Set("hub.lease_seconds", r.FormValue("hub.lease_seconds"))
+	u.RawQuery = q.Encode()
+
+	resp, err := h.client.Get(u.String())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != challenge {
+		http.Error(w, "verification failed", http.StatusBadRequest)
+		return
+	}
+
+	h.mu.Lock()
+	h.subs[topic] = fakeSub{callback: callback, secret: r.FormValue("hub.secret")}
+	h.mu.Unlock()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *fakeHub) publish(topic string, content []byte, secret string) int {
+	h.mu.Lock()
+	sub, ok := h.subs[topic]
+	h.mu.Unlock()
+	if !ok {
+		h.t.Fatalf("no subscription for topic %s", topic)
+	}
+
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write(content)
+	req, err := http.NewRequest(http.MethodPost, sub.callback, bytes.NewReader(content))
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/atom+xml")
+	req.Header.Set("X-Hub-Signature", "sha1="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func initDb(t *testing.T) *data.Queries {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	if err := data.Migrate(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	return data.New(db)
+}
+
+func TestSubscribeAndNotify(t *testing.T) {
+	const feedID = "UCtest"
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	queries := initDb(t)
+
+	err := queries.UpsertFeed(ctx, data.UpsertFeedParams{
+		ID:    []byte(feedID),
+		Title: "test",
+		Link:  "https://www.youtube.com/channel/" + feedID,
+		Xml:   "<rss></rss>",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notified := make(chan string, 1)
+	var sub *Subscriber
+
+	mux := http.NewServeMux()
+	withLogger := func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			h(w, r.WithContext(context.WithValue(r.Context(), "logger", logger)))
+		}
+	}
+	mux.HandleFunc("GET /websub/{feedID}", withLogger(func(w http.ResponseWriter, r *http.Request) {
+		sub.Verify()(w, r)
+	}))
+	mux.HandleFunc("POST /websub/{feedID}", withLogger(func(w http.ResponseWriter, r *http.Request) {
+		sub.Notify()(w, r)
+	}))
+	vpod := httptest.NewServer(mux)
+	defer vpod.Close()
+
+	hub, hubServer := newFakeHub(t)
+	defer hubServer.Close()
+
+	hubURL, _ := url.Parse(hubServer.URL)
+	callbackURL, _ := url.Parse(vpod.URL)
+	topicURL, _ := url.Parse("https://www.youtube.com/xml/feeds/videos.xml")
+
+	sub, err = New(logger, queries, Config{
+		HubURL:          hubURL,
+		TopicURL:        topicURL,
+		CallbackBaseURL: callbackURL,
+		Lease:           24 * time.Hour,
+		RenewBefore:     6 * time.Hour,
+	}, func(feedID string) { notified <- feedID })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sub.RenewAll(ctx); err != nil {
+		t.Fatalf("RenewAll failed: %v", err)
+	}
+
+	stored, err := queries.GetWebSubSubscription(ctx, feedID)
+	if err != nil {
+		t.Fatalf("subscription was not stored: %v", err)
+	}
+	if !stored.LeaseExpiresAt.Valid || time.Until(stored.LeaseExpiresAt.Time) < 23*time.Hour {
+		t.Errorf("expected a verified lease of about 24h, got %+v", stored.LeaseExpiresAt)
+	}
+
+	// A verified subscription should not be renewed again right away
+	needing, err := queries.GetFeedIdsNeedingWebSub(ctx, sql.NullTime{
+		Time:  time.Now().UTC().Add(6 * time.Hour),
+		Valid: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(needing) != 0 {
+		t.Errorf("expected no feeds to need renewal, got %d", len(needing))
+	}
+
+	topic := fmt.Sprintf("%s?channel_id=%s", topicURL, feedID)
+	content := []byte("<feed><entry><yt:videoId>abc</yt:videoId></entry></feed>")
+
+	if code := hub.publish(topic, content, "wrong-secret"); code/100 != 2 {
+		t.Errorf("expected badly signed notifications to be acknowledged, got %d", code)
+	}
+	select {
+	case id := <-notified:
+		t.Fatalf("badly signed notification enqueued a refresh for %s", id)
+	default:
+	}
+
+	if code := hub.publish(topic, content, stored.Secret); code/100 != 2 {
+		t.Errorf("expected notification to be acknowledged, got %d", code)
+	}
+	select {
+	case id := <-notified:
+		if id != feedID {
+			t.Errorf("expected refresh for %s, got %s", feedID, id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("notification did not enqueue a refresh")
+	}
+}
+
+func TestVerifyRejectsUnknownTopic(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	queries := initDb(t)
+
+	err := queries.UpsertWebSubSubscription(ctx, data.UpsertWebSubSubscriptionParams{
+		FeedID: "UCtest",
+		Topic:  "https://www.youtube.com/xml/feeds/videos.xml?channel_id=UCtest",
+		Secret: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse("https://example.com")
+	sub, err := New(logger, queries, Config{
+		HubURL:          u,
+		TopicURL:        u,
+		CallbackBaseURL: u,
+		Lease:           time.Hour,
+	}, func(string) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := url.Values{}
+	q.Set("hub.mode", "subscribe")
+	q.Set("hub.topic", "https://evil.example.com/feed")
+	q.Set("hub.challenge", "challenge")
+	q.Set("hub.lease_seconds", "3600")
+	req := httptest.NewRequest(http.MethodGet, "/websub/UCtest?"+q.Encode(), nil)
+	req.SetPathValue("feedID", "UCtest")
+	req = req.WithContext(context.WithValue(req.Context(), "logger", logger))
+
+	rr := httptest.NewRecorder()
+	sub.Verify()(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, rr.Code)
+	}
+	if rr.Body.String() == "challenge" {
+		t.Error("challenge was echoed for an unknown topic")
+	}
+}
+
+func TestVerifyOnlyConfirmsPendingRequests(t *testing.T) {
+	const topic = "https://www.youtube.com/xml/feeds/videos.xml?channel_id=UCtest"
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	queries := initDb(t)
+
+	err := queries.UpsertWebSubSubscription(ctx, data.UpsertWebSubSubscriptionParams{
+		FeedID:      "UCtest",
+		Topic:       topic,
+		Secret:      "secret",
+		PendingMode: sql.NullString{String: "subscribe", Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse("https://example.com")
+	sub, err := New(logger, queries, Config{
+		HubURL:          u,
+		TopicURL:        u,
+		CallbackBaseURL: u,
+		Lease:           time.Hour,
+	}, func(string) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verify := func(mode, leaseSeconds string) *httptest.ResponseRecorder {
+		q := url.Values{}
+		q.Set("hub.mode", mode)
+		q.Set("hub.topic", topic)
+		q.Set("hub.challenge", "challenge")
+		q.Set("hub.lease_seconds", leaseSeconds)
+		req := httptest.NewRequest(http.MethodGet, "/websub/UCtest?"+q.Encode(), nil)
+		req.SetPathValue("feedID", "UCtest")
+		req = req.WithContext(context.WithValue(req.Context(), "logger", logger))
+		rr := httptest.NewRecorder()
+		sub.Verify()(rr, req)
+		return rr
+	}
+
+	if rr := verify("unsubscribe", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("unsubscribe we never asked for: status %d", rr.Code)
+	}
+
+	rr := verify("subscribe", "9223372036854775807")
+	if rr.Code != http.StatusOK || rr.Body.String() != "challenge" {
+		t.Fatalf("pending subscribe: status %d, body %q", rr.Code, rr.Body.String())
+	}
+	stored, err := queries.GetWebSubSubscription(ctx, "UCtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.LeaseExpiresAt.Valid || time.Until(stored.LeaseExpiresAt.Time) > time.Hour {
+		t.Errorf("lease wasn't clamped to what we asked for: %+v", stored.LeaseExpiresAt)
+	}
+
+	if rr := verify("subscribe", "3600"); rr.Code != http.StatusNotFound {
+		t.Errorf("replayed verification: status %d", rr.Code)
+	}
+}
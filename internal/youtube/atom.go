@@ -0,0 +1,72 @@
+package youtube
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// FeedEntry is a video listed in a channel's public Atom feed.
+type FeedEntry struct {
+	VideoId   string    `xml:"http://www.youtube.com/xml/schemas/2015 videoId"`
+	Title     string    `xml:"title"`
+	Published time.Time `xml:"published"`
+	Updated   time.Time `xml:"updated"`
+}
+
+type atomFeed struct {
+	Entries []FeedEntry `xml:"entry"`
+}
+
+// maxFeedBytes bounds how much of an Atom feed we read.
+const maxFeedBytes = 4 * 1024 * 1024
+
+// FetchFeedEntries gets the most recent videos of a channel from its public
+// Atom feed, newest first. This is much cheaper than running yt-dlp, but
+// only has basic metadata. feedURL is the feed endpoint without the
+// channel_id query, usually https://www.youtube.com/feeds/videos.xml.
+func FetchFeedEntries(
+	ctx context.Context,
+	client *http.Client,
+	feedURL *url.URL,
+	channelID string,
+) ([]FeedEntry, error) {
+	u := *feedURL
+	q := u.Query()
+	q.Set("channel_id", channelID)
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching channel feed failed with status %d", resp.StatusCode)
+	}
+
+	var feed atomFeed
+	err = xml.NewDecoder(io.LimitReader(resp.Body, maxFeedBytes)).Decode(&feed)
+	if err != nil {
+		return nil, err
+	}
+	return feed.Entries, nil
+}
+
+// VideoIdFromURL returns the id of a youtube watch url, or "" if there is none.
+func VideoIdFromURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Query().Get("v")
+}
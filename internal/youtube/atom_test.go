@@ -0,0 +1,85 @@
+package youtube
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+const testFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns:yt="http://www.youtube.com/xml/schemas/2015" xmlns:media="http://search.yahoo.com/mrss/" xmlns="http://www.w3.org/2005/Atom">
+ <link rel="self" href="http://www.youtube.com/feeds/videos.xml?channel_id=UCtest"/>
+ <id>yt:channel:test</id>
+ <yt:channelId>test</yt:channelId>
+ <title>Test Channel</title>
+ <entry>
+  <id>yt:video:newest</id>
+  <yt:videoId>newest</yt:videoId>
+  <yt:channelId>UCtest</yt:channelId>
+  <title>Newest video</title>
+  <published>2025-03-02T12:00:00+00:00</published>
+  <updated>2025-03-02T13:00:00+00:00</updated>
+ </entry>
+ <entry>
+  <id>yt:video:older</id>
+  <yt:videoId>older</yt:videoId>
+  <yt:channelId>UCtest</yt:channelId>
+  <title>Older video</title>
+  <published>2025-03-01T12:00:00+00:00</published>
+  <updated>2025-03-01T12:00:00+00:00</updated>
+ </entry>
+</feed>`
+
+func TestFetchFeedEntries(t *testing.T) {
+	var gotChannel string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotChannel = r.URL.Query().Get("channel_id")
+		if r.URL.Path != "/feeds/videos.xml" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/atom+xml")
+		w.Write([]byte(testFeed))
+	}))
+	defer srv.Close()
+
+	feedURL, _ := url.Parse(srv.URL + "/feeds/videos.xml")
+	entries, err := FetchFeedEntries(context.Background(), srv.Client(), feedURL, "UCtest")
+	if err != nil {
+		t.Fatalf("FetchFeedEntries failed: %v", err)
+	}
+
+	if gotChannel != "UCtest" {
+		t.Errorf("expected channel_id UCtest, got %q", gotChannel)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+
+	want := FeedEntry{
+		VideoId:   "newest",
+		Title:     "Newest video",
+		Published: time.Date(2025, 3, 2, 12, 0, 0, 0, time.UTC),
+		Updated:   time.Date(2025, 3, 2, 13, 0, 0, 0, time.UTC),
+	}
+	got := entries[0]
+	if got.VideoId != want.VideoId || got.Title != want.Title || !got.Published.Equal(want.Published) || !got.Updated.Equal(want.Updated) {
+		t.Errorf("expected first entry %+v, got %+v", want, got)
+	}
+	if entries[1].VideoId != "older" {
+		t.Errorf("expected second entry to be older, got %s", entries[1].VideoId)
+	}
+}
+
+func TestFetchFeedEntries_BadStatus(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	feedURL, _ := url.Parse(srv.URL)
+	if _, err := FetchFeedEntries(context.Background(), srv.Client(), feedURL, "UCtest"); err == nil {
+		t.Fatal("expected an error for a 404 feed")
+	}
+}
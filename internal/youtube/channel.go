@@ -2,6 +2,7 @@ package youtube
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,6 +10,11 @@ import (
 	"os/exec"
 	"strings"
 	"time"
+	"vpod/internal/metrics"
+	"vpod/internal/proc"
+	"vpod/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type UnixTime struct {
@@ -112,7 +118,8 @@ func (c *Channel) GetLogo() *url.URL {
 }
 
 type fetchChannelOptions struct {
-	numItems *uint64
+	cookiesPath string
+	numItems    *uint64
 }
 
 type FetchChannelOption func(options *fetchChannelOptions) error
@@ -127,7 +134,18 @@ func WithNItems(n uint64) FetchChannelOption {
 	}
 }
 
-func FetchChannel(ytURL *url.URL, opts ...FetchChannelOption) (*Channel, error) {
+// WithCookies passes yt-dlp a Netscape format cookie jar, so videos that
+// need a login can be extracted.
+func WithCookies(path string) FetchChannelOption {
+	return func(options *fetchChannelOptions) error {
+		options.cookiesPath = path
+		return nil
+	}
+}
+
+// FetchChannel shells out to yt-dlp to fetch the channel's metadata. The
+// yt-dlp process is killed if ctx is cancelled before it finishes.
+func FetchChannel(ctx context.Context, ytURL *url.URL, opts ...FetchChannelOption) (*Channel, error) {
 	var options fetchChannelOptions
 	for _, opt := range opts {
 		err := opt(&options)
@@ -143,20 +161,34 @@ func FetchChannel(ytURL *url.URL, opts ...FetchChannelOption) (*Channel, error)
 		numItems = *options.numItems
 	}
 
-	cmd := exec.Command(
-		"yt-dlp",
+	args := []string{
 		"--dump-single-json",
-		"--ignore-no-formats-error", // ignore when a video is age-restricted
-		// ^ TODO: add a feature to pass in cookies as desired
+		// Without cookies for an account that can see them, age-restricted
+		// and members-only videos have no formats
+		"--ignore-no-formats-error",
 		fmt.Sprintf("--playlist-items=0:%d", numItems),
-		ytURL.String(),
-	)
+	}
+	if options.cookiesPath != "" {
+		args = append(args, "--cookies="+options.cookiesPath)
+	}
+	args = append(args, ytURL.String())
+	cmd := proc.Command(ctx, "yt-dlp", args...)
 
 	var outb, errb bytes.Buffer
 	cmd.Stdout = &outb
 	cmd.Stderr = &errb
 
+	_, span := tracing.Start(ctx, "yt-dlp channel",
+		attribute.String("url", ytURL.String()),
+		attribute.Int64("items", int64(numItems)),
+	)
+	start := time.Now()
 	err := cmd.Run()
+	metrics.ObserveYtDlp("channel", start, err)
+	tracing.End(span, err)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
 	if err != nil {
 		var exitError *exec.ExitError
 		if errors.As(err, &exitError) {
@@ -167,7 +199,9 @@ func FetchChannel(ytURL *url.URL, opts ...FetchChannelOption) (*Channel, error)
 	}
 
 	var c Channel
+	_, span = tracing.Start(ctx, "decode channel json", attribute.Int("bytes", outb.Len()))
 	err = json.Unmarshal(outb.Bytes(), &c)
+	tracing.End(span, err)
 	if err != nil {
 		return nil, err
 	}
@@ -25,6 +25,7 @@ type VideoFormat struct {
 	Description   string `json:"format"`
 	Drm           bool   `json:"has_drm"`
 	Ext           string
+	Height        int
 	Language      string `json:"language"`
 
 	// no idea what the difference between these two is
@@ -35,4 +36,5 @@ type VideoFormat struct {
 	Protocol   string
 	Resolution string
 	Url        string
+	VideoCodec string `json:"vcodec"`
 }